@@ -17,7 +17,7 @@ import (
 )
 
 // DefaultRequestHandler creates an instance of the default HTTP request handler
-func DefaultRequestHandler(logger log.Logger, store *plex.Store, cfg plex.Config) (*goji.Mux, error) {
+func DefaultRequestHandler(logger log.Logger, store *plex.Store, engine *plex.Engine) (*goji.Mux, error) {
 
 	v, err := schema.NewValidator()
 	if err != nil {
@@ -27,8 +27,21 @@ func DefaultRequestHandler(logger log.Logger, store *plex.Store, cfg plex.Config
 	mux := goji.NewMux()
 
 	mux.HandleFunc(pat.Get("/health"), handleHealthCheck())
-	mux.HandleFunc(pat.Post("/hook"), handlePlexWebhook(v, store, cfg))
+	mux.HandleFunc(pat.Post("/hook"), handlePlexWebhook(v, store, engine))
+	mux.HandleFunc(pat.Post("/simulate"), handleSimulate(v, engine))
 	mux.HandleFunc(pat.Get("/activity"), handleGetAllHooks(store))
+	mux.HandleFunc(pat.Get("/thumbs/:id"), handleGetThumb(store))
+	mux.HandleFunc(pat.Get("/pending"), handleGetPending(engine))
+	mux.HandleFunc(pat.Get("/jobs"), handleGetJobs(engine))
+	mux.HandleFunc(pat.Post("/jobs/:id/cancel"), handleCancelJob(engine))
+	mux.HandleFunc(pat.Get("/deadletters"), handleGetDeadLetters(engine))
+	mux.HandleFunc(pat.Get("/deadletters/:id"), handleGetDeadLetter(engine))
+	mux.HandleFunc(pat.Post("/deadletters/:id/redrive"), handleRedrive(engine))
+	mux.HandleFunc(pat.Get("/triggers"), handleGetTriggers(engine))
+	mux.HandleFunc(pat.Post("/triggers/:id/enable"), handleSetTriggerEnabled(engine, true))
+	mux.HandleFunc(pat.Post("/triggers/:id/disable"), handleSetTriggerEnabled(engine, false))
+	mux.HandleFunc(pat.Post("/tags/:tag/enable"), handleSetTagEnabled(engine, true))
+	mux.HandleFunc(pat.Post("/tags/:tag/disable"), handleSetTagEnabled(engine, false))
 
 	mux.Use(loggerMiddleware(logger))
 	return mux, nil
@@ -59,94 +72,298 @@ func hasContentType(r *http.Request, mimetype string) bool {
 	return false
 }
 
-func handlePlexWebhook(v *schema.Validator, store *plex.Store, cfg plex.Config) func(w http.ResponseWriter, r *http.Request) {
+// webhook is a Plex webhook read from an HTTP request
+type webhook struct {
+	payload   []byte
+	doc       interface{}
+	pl        plex.WebhookPayload
+	thumb     []byte
+	thumbName string
+}
+
+// readWebhook reads and validates a Plex webhook from the request.  The returned status code accompanies any error.
+func readWebhook(r *http.Request, v *schema.Validator, logger log.Logger) (webhook, int, error) {
+	wh := webhook{}
+
+	// https://support.plex.tv/articles/115002267687-webhooks/
+	// Per their documentation, Plex will send a multipart form request, and 'payload' is the JSON of the hook
+	// We want to be flexible (makes testing easier), so lets see if we can handle both scenarios (multipart vs raw JSON post)
+	if hasContentType(r, "multipart/form-data") {
+		err := r.ParseMultipartForm(10 * 1024 * 1024) // 10mb
+		if err != nil {
+			return wh, http.StatusBadRequest, err
+		}
+		wh.payload = []byte(r.FormValue("payload"))
+		thumb, fh, err := r.FormFile("thumb")
+		if err == nil {
+			defer thumb.Close()
+			if wh.thumb, err = ioutil.ReadAll(thumb); err != nil {
+				logger.Log("msg", "could not read thumb bytes", "err", err)
+				wh.thumb = nil
+			}
+			wh.thumbName = fh.Filename
+		}
+	} else {
+		// Assume raw JSON post
+		pl, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			return wh, http.StatusInternalServerError, err
+		}
+		wh.payload = pl
+	}
+
+	// Should have JSON bytes by this point
+	// Validate the request
+	if err := v.Validate(wh.payload); err != nil {
+		return wh, http.StatusBadRequest, err
+	}
+	if err := json.Unmarshal(wh.payload, &wh.pl); err != nil {
+		return wh, http.StatusBadRequest, err
+	}
+	doc, err := plex.ParseDocument(wh.payload)
+	if err != nil {
+		return wh, http.StatusBadRequest, err
+	}
+	wh.doc = doc
+	return wh, http.StatusOK, nil
+}
+
+func handlePlexWebhook(v *schema.Validator, store *plex.Store, engine *plex.Engine) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger := r.Context().Value(keyLogger).(log.Logger)
 		reqID := r.Context().Value(keyRequestID).(string)
 
-		// https://support.plex.tv/articles/115002267687-webhooks/
-		// Per their documentation, Plex will send a multipart form request, and 'payload' is the JSON of the hook
-		// We want to be flexible (makes testing easier), so lets see if we can handle both scenarios (multipart vs raw JSON post)
-		payload := []byte{}
+		wh, code, err := readWebhook(r, v, logger)
+		if err != nil {
+			Failure(w, err, code, logger)
+			return
+		}
+
 		thumbPath := ""
-		if hasContentType(r, "multipart/form-data") {
-			err := r.ParseMultipartForm(10 * 1024 * 1024) // 10mb
+		if wh.thumb != nil {
+			tp, err := store.AddThumb(reqID, wh.thumbName, wh.thumb)
+			if err != nil {
+				logger.Log("msg", "could not save thumb to store", "err", err)
+			} else {
+				thumbPath = tp
+			}
+		}
+
+		// Store result
+		act := plex.Activity{
+			RequestID:  reqID,
+			ReceivedAt: time.Now(),
+			Payload:    wh.pl,
+			ThumbPath:  thumbPath,
+		}
+		err = store.AddActivity(act)
+		if err != nil {
+			Failure(w, err, http.StatusInternalServerError, logger)
+			return
+		}
+
+		// Pass activity to the engine
+		err = engine.Handle(logger, act, wh.doc)
+		if err != nil {
+			Failure(w, err, http.StatusInternalServerError, logger)
+			return
+		}
+
+		Ok(w, messageResponse{Message: "Ok"}, logger)
+	}
+}
+
+// simulateResponse is the outcome of running a webhook through every trigger without executing actions
+type simulateResponse struct {
+	ReceivedAt time.Time            `json:"receivedAt"`
+	Triggers   []plex.TriggerResult `json:"triggers"`
+	Actions    []simulatedAction    `json:"actions"`
+}
+
+type simulatedAction struct {
+	Trigger string `json:"trigger"`
+	Type    string `json:"type"`
+}
+
+// handleSimulate explains how the configured triggers evaluate a webhook, accepting the same requests as /hook.  The
+// optional 'at' query parameter (RFC 3339) sets the time the webhook is considered received.
+func handleSimulate(v *schema.Validator, engine *plex.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(keyLogger).(log.Logger)
+
+		at := time.Now()
+		if q := r.URL.Query().Get("at"); q != "" {
+			t, err := time.Parse(time.RFC3339, q)
 			if err != nil {
 				Failure(w, err, http.StatusBadRequest, logger)
 				return
 			}
+			at = t
+		}
 
-			// TODO: not super happy with this conditional hell.  Fix it.
-			payload = []byte(r.FormValue("payload"))
-			thumb, fh, err := r.FormFile("thumb")
-			if err == nil {
-				tbs, err := ioutil.ReadAll(thumb)
-				if err != nil {
-					logger.Log("msg", "could not read thumb bytes", "err", err)
-				} else {
-					tp, err := store.AddThumb(reqID, fh.Filename, tbs)
-					if err != nil {
-						logger.Log("msg", "could not save thumb to store", "err", err)
-					} else {
-						thumbPath = tp
-					}
-				}
+		wh, code, err := readWebhook(r, v, logger)
+		if err != nil {
+			Failure(w, err, code, logger)
+			return
+		}
 
+		res := simulateResponse{
+			ReceivedAt: at,
+			Triggers:   engine.Explain(wh.doc, at),
+			Actions:    []simulatedAction{},
+		}
+		for _, t := range res.Triggers {
+			if !t.Fired || t.Arms {
+				continue
 			}
-		} else {
-			// Assume raw JSON post
-			pl, err := ioutil.ReadAll(r.Body)
-			defer r.Body.Close()
-			if err != nil {
-				Failure(w, err, http.StatusInternalServerError, logger)
-				return
+			for _, a := range t.Actions {
+				res.Actions = append(res.Actions, simulatedAction{Trigger: t.ID, Type: a})
 			}
-			payload = pl
 		}
+		Ok(w, res, logger)
+	}
+}
 
-		// Should have JSON bytes by this point
-		// Validate the request
-		err := v.Validate(payload)
+func handleGetAllHooks(store *plex.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(keyLogger).(log.Logger)
+		act, err := store.GetAllActivity()
 		if err != nil {
-			Failure(w, err, http.StatusBadRequest, logger)
+			Failure(w, err, http.StatusInternalServerError, logger)
 			return
 		}
+		Ok(w, act, logger)
+	}
+}
 
-		// Store result
-		pl := plex.WebhookPayload{}
-		if err = json.Unmarshal(payload, &pl); err != nil {
+// handleGetThumb serves the thumb saved with the webhook of the given request, which chat actions link to
+func handleGetThumb(store *plex.Store) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(keyLogger).(log.Logger)
+		tp, err := store.GetThumb(pat.Param(r, "id"))
+		if err == plex.ErrUnknownThumb {
+			Failure(w, err, http.StatusNotFound, logger)
+			return
+		}
+		if err != nil {
 			Failure(w, err, http.StatusInternalServerError, logger)
 			return
 		}
-		err = store.AddActivity(plex.Activity{
-			RequestID:  reqID,
-			ReceivedAt: time.Now(),
-			Payload:    pl,
-			ThumbPath:  thumbPath,
-		})
+		http.ServeFile(w, r, tp)
+	}
+}
+
+func handleGetPending(engine *plex.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(keyLogger).(log.Logger)
+		Ok(w, engine.Pending(), logger)
+	}
+}
+
+// handleGetJobs lists the delayed actions, optionally only those with the status given as a query parameter
+func handleGetJobs(engine *plex.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(keyLogger).(log.Logger)
+		status := r.URL.Query().Get("status")
+		js := []plex.ScheduledJob{}
+		for _, j := range engine.Jobs() {
+			if status == "" || j.Status == status {
+				js = append(js, j)
+			}
+		}
+		Ok(w, js, logger)
+	}
+}
+
+func handleCancelJob(engine *plex.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(keyLogger).(log.Logger)
+		if err := engine.CancelJob(logger, pat.Param(r, "id")); err != nil {
+			Failure(w, err, http.StatusNotFound, logger)
+			return
+		}
+		Ok(w, messageResponse{Message: "Ok"}, logger)
+	}
+}
+
+func handleGetDeadLetters(engine *plex.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(keyLogger).(log.Logger)
+		ds, err := engine.DeadLetters()
 		if err != nil {
 			Failure(w, err, http.StatusInternalServerError, logger)
 			return
 		}
+		Ok(w, ds, logger)
+	}
+}
 
-		// Pass payload to configuration handler
-		err = cfg.Handle(logger, pl, payload)
+func handleGetDeadLetter(engine *plex.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(keyLogger).(log.Logger)
+		d, err := engine.DeadLetter(pat.Param(r, "id"))
+		if err == plex.ErrUnknownDeadLetter {
+			Failure(w, err, http.StatusNotFound, logger)
+			return
+		}
 		if err != nil {
 			Failure(w, err, http.StatusInternalServerError, logger)
+			return
 		}
+		Ok(w, d, logger)
+	}
+}
 
+// handleRedrive queues a dead letter's action to be executed again, responding with 202 Accepted and the dead letter's
+// ID.  The outcome is recorded on the dead letter.
+func handleRedrive(engine *plex.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(keyLogger).(log.Logger)
+		id := pat.Param(r, "id")
+		err := engine.Redrive(logger, id)
+		switch err {
+		case nil:
+			Accepted(w, acceptedResponse{Message: "Accepted", ID: id}, logger)
+		case plex.ErrUnknownDeadLetter, plex.ErrUnknownTrigger:
+			Failure(w, err, http.StatusNotFound, logger)
+		case plex.ErrQueueFull, plex.ErrShuttingDown:
+			Failure(w, err, http.StatusServiceUnavailable, logger)
+		default:
+			Failure(w, err, http.StatusConflict, logger)
+		}
+	}
+}
+
+func handleGetTriggers(engine *plex.Engine) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(keyLogger).(log.Logger)
+		Ok(w, engine.Triggers(), logger)
+	}
+}
+
+func handleSetTriggerEnabled(engine *plex.Engine, enabled bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(keyLogger).(log.Logger)
+		id := pat.Param(r, "id")
+		if err := engine.SetTriggerEnabled(id, enabled); err != nil {
+			Failure(w, err, http.StatusNotFound, logger)
+			return
+		}
+		logger.Log("msg", "toggled trigger", "trigger", id, "enabled", enabled)
 		Ok(w, messageResponse{Message: "Ok"}, logger)
 	}
 }
 
-func handleGetAllHooks(store *plex.Store) func(w http.ResponseWriter, r *http.Request) {
+func handleSetTagEnabled(engine *plex.Engine, enabled bool) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger := r.Context().Value(keyLogger).(log.Logger)
-		act, err := store.GetAllActivity()
-		if err != nil {
-			Failure(w, err, http.StatusInternalServerError, logger)
+		tag := pat.Param(r, "tag")
+		if err := engine.SetTagEnabled(tag, enabled); err != nil {
+			Failure(w, err, http.StatusNotFound, logger)
 			return
 		}
-		Ok(w, act, logger)
+		logger.Log("msg", "toggled tag", "tag", tag, "enabled", enabled)
+		Ok(w, messageResponse{Message: "Ok"}, logger)
 	}
 }
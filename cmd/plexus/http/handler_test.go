@@ -0,0 +1,430 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/clocklear/plexus/pkg/plex"
+	"github.com/clocklear/plexus/pkg/plex/schema"
+)
+
+var webhookPayload = []byte(`{
+	"event": "media.play",
+	"user": true,
+	"owner": true,
+	"Account": {"id": 1, "thumb": "https://plex.tv/users/1/avatar", "title": "someone"},
+	"Server": {"title": "server", "uuid": "54664a3d8acc39983675640ec9ce00b70af9cc36"},
+	"Player": {"local": true, "publicAddress": "10.0.0.1", "title": "Living Room", "uuid": "abc123"},
+	"Metadata": {
+		"librarySectionType": "show",
+		"ratingKey": "1936545",
+		"key": "/library/metadata/1936545",
+		"guid": "com.plexapp.agents.thetvdb://1234/2/4?lang=en",
+		"librarySectionID": 2,
+		"summary": "Things happen.",
+		"thumb": "/library/metadata/1936545/thumb/1559390400",
+		"art": "/library/metadata/1936530/art/1559390400",
+		"addedAt": 1559390400,
+		"updatedAt": 1559390400,
+		"type": "episode",
+		"title": "An Episode",
+		"grandparentTitle": "A Show",
+		"index": 4,
+		"parentIndex": 2
+	}
+}`)
+
+func TestReadWebhook(t *testing.T) {
+	v, err := schema.NewValidator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/hook", bytes.NewReader(webhookPayload))
+	r.Header.Set("Content-Type", "application/json")
+	wh, code, err := readWebhook(r, v, log.NewNopLogger())
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("Unexpected error reading webhook: %d %v", code, err)
+	}
+	if wh.pl.Event != "media.play" || wh.pl.Player.UUID != "abc123" || wh.pl.Metadata.Index != 4 {
+		t.Errorf("Unexpected payload %+v", wh.pl)
+	}
+
+	r = httptest.NewRequest("POST", "/hook", bytes.NewReader([]byte(`{"event": 1}`)))
+	r.Header.Set("Content-Type", "application/json")
+	if _, code, err := readWebhook(r, v, log.NewNopLogger()); err == nil || code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid payload to be rejected, got %d %v", code, err)
+	}
+}
+
+// apiConfig configures the triggers behind the api under test: "lights" calls the receiver on every play, "dim"
+// waits an hour before calling it on a pause, "paused" arms a correlation on a pause and the trigger without an id
+// calls the receiver on every stop
+const apiConfig = `{
+	"triggers": [
+		{
+			"id": "lights",
+			"tags": ["evening"],
+			"properties": {"event": "media.play"},
+			"actions": [{"type": "webhook", "config": {"url": "RECEIVER"}}]
+		},
+		{
+			"id": "dim",
+			"properties": {"event": "media.pause"},
+			"actions": [{"type": "webhook", "delay": "1h", "config": {"url": "RECEIVER"}}]
+		},
+		{
+			"id": "paused",
+			"properties": {"event": "media.pause"},
+			"correlation": {"key": ["Player.uuid"], "timeout": "1h", "cancel": [{"event": "media.resume"}]},
+			"actions": []
+		},
+		{
+			"properties": {"event": "media.stop"},
+			"actions": [{"type": "webhook", "config": {"url": "RECEIVER"}}]
+		}
+	]
+}`
+
+// testAPI is the api served over an engine running apiConfig, whose webhook actions call a receiver answering with
+// status
+type testAPI struct {
+	t        *testing.T
+	handler  http.Handler
+	engine   *plex.Engine
+	receiver *httptest.Server
+	status   int32
+	dir      string
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	a := testAPI{t: t, status: http.StatusOK}
+	a.receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&a.status)))
+	}))
+	var err error
+	if a.dir, err = ioutil.TempDir("", "plexus"); err != nil {
+		t.Fatal(err)
+	}
+	store, err := plex.NewStore(a.dir)
+	if err != nil {
+		a.close()
+		t.Fatal(err)
+	}
+	cfg, err := plex.NewConfig(strings.NewReader(strings.Replace(apiConfig, "RECEIVER", a.receiver.URL, -1)))
+	if err != nil {
+		a.close()
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	a.engine = plex.NewEngine(cfg, store)
+	if a.handler, err = DefaultRequestHandler(log.NewNopLogger(), store, a.engine); err != nil {
+		a.close()
+		t.Fatal(err)
+	}
+	return &a
+}
+
+func (a *testAPI) close() {
+	a.receiver.Close()
+	os.RemoveAll(a.dir)
+}
+
+// fail makes the webhook receiver fail, or succeed again
+func (a *testAPI) fail(fail bool) {
+	status := http.StatusOK
+	if fail {
+		status = http.StatusInternalServerError
+	}
+	atomic.StoreInt32(&a.status, int32(status))
+}
+
+// do serves a request, decoding the JSON response into v unless it is nil, and checks its status code
+func (a *testAPI) do(method, path, contentType string, body io.Reader, want int, v interface{}) {
+	a.t.Helper()
+	r := httptest.NewRequest(method, path, body)
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	a.handler.ServeHTTP(w, r)
+	if w.Code != want {
+		a.t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, want, w.Code, w.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			a.t.Fatalf("%s %s: could not decode the response: %v", method, path, err)
+		}
+	}
+}
+
+// hook posts the test webhook payload as the given event
+func (a *testAPI) hook(event string) {
+	a.t.Helper()
+	a.do("POST", "/hook", "application/json", bytes.NewReader(eventPayload(event)), http.StatusOK, nil)
+}
+
+// eventPayload returns the test webhook payload as the given event
+func eventPayload(event string) []byte {
+	return bytes.Replace(webhookPayload, []byte(`"media.play"`), []byte(`"`+event+`"`), 1)
+}
+
+// multipartWebhook encodes the payload as Plex does, with a thumb when it is not nil
+func multipartWebhook(t *testing.T, payload, thumb []byte) (io.Reader, string) {
+	buf := bytes.Buffer{}
+	mw := multipart.NewWriter(&buf)
+	if payload != nil {
+		if err := mw.WriteField("payload", string(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if thumb != nil {
+		fw, err := mw.CreateFormFile("thumb", "thumb.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(thumb)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf, mw.FormDataContentType()
+}
+
+func TestHandleHook(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.close()
+	a.do("GET", "/health", "", nil, http.StatusOK, nil)
+
+	defer a.engine.Shutdown(context.Background())
+
+	// The pause schedules the dim job, which carries the webhook's activity
+	body, contentType := multipartWebhook(t, eventPayload("media.pause"), []byte("JPG"))
+	a.do("POST", "/hook", contentType, body, http.StatusOK, nil)
+	a.do("POST", "/hook", "application/json", strings.NewReader(`{"event": 1}`), http.StatusBadRequest, nil)
+	body, contentType = multipartWebhook(t, nil, nil)
+	a.do("POST", "/hook", contentType, body, http.StatusBadRequest, nil)
+
+	var js []plex.ScheduledJob
+	a.do("GET", "/jobs", "", nil, http.StatusOK, &js)
+	if len(js) != 1 || js[0].Activity.Payload.Event != "media.pause" || js[0].Activity.ThumbPath == "" {
+		t.Fatalf("Expected the valid webhook to be handled with its thumb, got %+v", js)
+	}
+	r := httptest.NewRequest("GET", "/thumbs/"+js[0].Activity.RequestID, nil)
+	w := httptest.NewRecorder()
+	a.handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "JPG" {
+		t.Errorf("Expected the uploaded thumb, got %d %q", w.Code, w.Body.String())
+	}
+	a.do("GET", "/thumbs/unknown", "", nil, http.StatusNotFound, nil)
+}
+
+func TestHandleSimulate(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.close()
+	check := func(res simulateResponse) {
+		t.Helper()
+		if len(res.Triggers) != 4 || !res.Triggers[0].Fired || res.Triggers[1].Fired {
+			t.Errorf("Expected only the lights to fire, got %+v", res.Triggers)
+		}
+		if len(res.Actions) != 1 || res.Actions[0] != (simulatedAction{Trigger: "lights", Type: "webhook"}) {
+			t.Errorf("Expected the lights' webhook to be simulated, got %+v", res.Actions)
+		}
+	}
+
+	a.fail(true)
+	res := simulateResponse{}
+	a.do("POST", "/simulate?at=2019-06-01T20:00:00Z", "application/json", bytes.NewReader(webhookPayload), http.StatusOK, &res)
+	check(res)
+	if want := "2019-06-01T20:00:00Z"; res.ReceivedAt.Format(time.RFC3339) != want {
+		t.Errorf("Expected the webhook to be simulated at %s, got %v", want, res.ReceivedAt)
+	}
+	body, contentType := multipartWebhook(t, webhookPayload, []byte("JPG"))
+	res = simulateResponse{}
+	a.do("POST", "/simulate", contentType, body, http.StatusOK, &res)
+	check(res)
+
+	a.do("POST", "/simulate?at=tonight", "application/json", bytes.NewReader(webhookPayload), http.StatusBadRequest, nil)
+	a.do("POST", "/simulate", "application/json", strings.NewReader(`{"event": 1}`), http.StatusBadRequest, nil)
+	body, contentType = multipartWebhook(t, []byte(`{}`), nil)
+	a.do("POST", "/simulate", contentType, body, http.StatusBadRequest, nil)
+
+	// Simulating does not run actions, so none of them failed
+	var ds []plex.DeadLetter
+	a.do("GET", "/deadletters", "", nil, http.StatusOK, &ds)
+	if len(ds) != 0 {
+		t.Errorf("Expected no actions to run, got dead letters %+v", ds)
+	}
+}
+
+func TestHandleToggles(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.close()
+	enabled := func() bool {
+		t.Helper()
+		var ts []plex.TriggerStatus
+		a.do("GET", "/triggers", "", nil, http.StatusOK, &ts)
+		if len(ts) != 4 || ts[0].ID != "lights" {
+			t.Fatalf("Unexpected triggers %+v", ts)
+		}
+		return ts[0].Enabled
+	}
+
+	if !enabled() {
+		t.Fatal("Expected the lights to be enabled")
+	}
+	a.do("POST", "/tags/evening/disable", "", nil, http.StatusOK, nil)
+	if enabled() {
+		t.Error("Expected the lights to be disabled by tag")
+	}
+	a.do("POST", "/tags/evening/enable", "", nil, http.StatusOK, nil)
+	if !enabled() {
+		t.Error("Expected the lights to be enabled by tag")
+	}
+	a.do("POST", "/triggers/lights/disable", "", nil, http.StatusOK, nil)
+	if enabled() {
+		t.Error("Expected the lights to be disabled by id")
+	}
+	a.do("POST", "/triggers/lights/enable", "", nil, http.StatusOK, nil)
+	if !enabled() {
+		t.Error("Expected the lights to be enabled by id")
+	}
+
+	a.do("POST", "/triggers/unknown/enable", "", nil, http.StatusNotFound, nil)
+	a.do("POST", "/triggers/unknown/disable", "", nil, http.StatusNotFound, nil)
+	a.do("POST", "/tags/unknown/enable", "", nil, http.StatusNotFound, nil)
+	a.do("POST", "/tags/unknown/disable", "", nil, http.StatusNotFound, nil)
+}
+
+func TestHandleDeadLetters(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.close()
+	var ds []plex.DeadLetter
+	a.do("GET", "/deadletters", "", nil, http.StatusOK, &ds)
+	if len(ds) != 0 {
+		t.Fatalf("Expected no dead letters, got %+v", ds)
+	}
+
+	a.fail(true)
+	a.hook("media.play")
+	a.hook("media.play")
+	a.hook("media.stop")
+	a.do("GET", "/deadletters", "", nil, http.StatusOK, &ds)
+	if len(ds) != 3 {
+		t.Fatalf("Expected 3 dead letters, got %+v", ds)
+	}
+	var lights []string
+	derived := ""
+	for _, d := range ds {
+		if d.Trigger == "lights" {
+			lights = append(lights, d.ID)
+		} else {
+			derived = d.ID
+		}
+	}
+	if len(lights) != 2 || derived == "" {
+		t.Fatalf("Expected 2 dead letters of the lights and 1 of the trigger without an id, got %+v", ds)
+	}
+	d := plex.DeadLetter{}
+	a.do("GET", "/deadletters/"+lights[0], "", nil, http.StatusOK, &d)
+	if d.ID != lights[0] || d.Type != "webhook" || d.Attempts != 1 {
+		t.Errorf("Unexpected dead letter %+v", d)
+	}
+	a.do("GET", "/deadletters/unknown", "", nil, http.StatusNotFound, nil)
+
+	// Until the worker pool is started, redrives run before the response is written
+	a.fail(false)
+	res := acceptedResponse{}
+	a.do("POST", "/deadletters/"+lights[0]+"/redrive", "", nil, http.StatusAccepted, &res)
+	if res.ID != lights[0] {
+		t.Errorf("Expected the redriven dead letter's id, got %+v", res)
+	}
+	a.do("GET", "/deadletters/"+lights[0], "", nil, http.StatusNotFound, nil)
+	a.do("POST", "/deadletters/"+lights[0]+"/redrive", "", nil, http.StatusNotFound, nil)
+	a.do("POST", "/deadletters/"+derived+"/redrive", "", nil, http.StatusConflict, nil)
+
+	a.engine.Start(plex.ExecutorOptions{Workers: 1, QueueSize: 1})
+	if err := a.engine.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	a.do("POST", "/deadletters/"+lights[1]+"/redrive", "", nil, http.StatusServiceUnavailable, nil)
+}
+
+func TestHandleJobs(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.close()
+	a.hook("media.pause")
+	defer a.engine.Shutdown(context.Background())
+
+	var js []plex.ScheduledJob
+	a.do("GET", "/jobs", "", nil, http.StatusOK, &js)
+	if len(js) != 1 || js[0].Trigger != "dim" || js[0].Status != plex.JobPending {
+		t.Fatalf("Expected the dim job to be pending, got %+v", js)
+	}
+	id := js[0].ID
+	a.do("GET", "/jobs?status=cancelled", "", nil, http.StatusOK, &js)
+	if len(js) != 0 {
+		t.Errorf("Expected no cancelled jobs, got %+v", js)
+	}
+
+	a.do("POST", "/jobs/"+id+"/cancel", "", nil, http.StatusOK, nil)
+	a.do("POST", "/jobs/"+id+"/cancel", "", nil, http.StatusNotFound, nil)
+	a.do("POST", "/jobs/unknown/cancel", "", nil, http.StatusNotFound, nil)
+	a.do("GET", "/jobs?status=pending", "", nil, http.StatusOK, &js)
+	if len(js) != 0 {
+		t.Errorf("Expected no pending jobs, got %+v", js)
+	}
+	a.do("GET", "/jobs?status=cancelled", "", nil, http.StatusOK, &js)
+	if len(js) != 1 || js[0].ID != id || js[0].CancelledAt == nil {
+		t.Errorf("Expected the cancelled job, got %+v", js)
+	}
+}
+
+func TestHandlePending(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.close()
+	defer a.engine.Shutdown(context.Background())
+
+	var ps []plex.Pending
+	a.do("GET", "/pending", "", nil, http.StatusOK, &ps)
+	if len(ps) != 0 {
+		t.Fatalf("Expected no pending correlations, got %+v", ps)
+	}
+	a.hook("media.pause")
+	a.do("GET", "/pending", "", nil, http.StatusOK, &ps)
+	if len(ps) != 1 || ps[0].Trigger != "paused" || ps[0].Key == "" {
+		t.Fatalf("Expected the paused correlation to be armed, got %+v", ps)
+	}
+	a.hook("media.resume")
+	a.do("GET", "/pending", "", nil, http.StatusOK, &ps)
+	if len(ps) != 0 {
+		t.Errorf("Expected the resume to cancel the correlation, got %+v", ps)
+	}
+}
+
+func BenchmarkReadWebhook(b *testing.B) {
+	v, err := schema.NewValidator()
+	if err != nil {
+		b.Fatal(err)
+	}
+	logger := log.NewNopLogger()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest("POST", "/hook", bytes.NewReader(webhookPayload))
+		r.Header.Set("Content-Type", "application/json")
+		if _, _, err := readWebhook(r, v, logger); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -110,6 +110,11 @@ func Ok(w http.ResponseWriter, v interface{}, logger log.Logger) {
 	writeJSON(http.StatusOK, w, v, logger)
 }
 
+// Accepted writes an API message for work that was queued to the response
+func Accepted(w http.ResponseWriter, v interface{}, logger log.Logger) {
+	writeJSON(http.StatusAccepted, w, v, logger)
+}
+
 // Failure writes an API error message to the response and logger.
 func Failure(w http.ResponseWriter, err error, code int, logger log.Logger) {
 	if logger != nil {
@@ -130,3 +135,8 @@ type failureResponse struct {
 type messageResponse struct {
 	Message string `json:"msg"`
 }
+
+type acceptedResponse struct {
+	Message string `json:"msg"`
+	ID      string `json:"id"`
+}
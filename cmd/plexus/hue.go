@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/clocklear/plexus/pkg/plex"
+)
+
+// runHue implements the 'plexus hue' subcommands.  'plexus hue pair' obtains an app key from a Hue bridge, waiting for
+// its link button to be pressed.  It returns the process exit code.
+func runHue(args []string) int {
+	if len(args) == 0 || args[0] != "pair" {
+		fmt.Fprintln(os.Stderr, "usage: plexus hue pair -address <bridge address>")
+		return 2
+	}
+	host, _ := os.Hostname()
+	fs := flag.NewFlagSet("hue pair", flag.ExitOnError)
+	address := fs.String("address", "", "The host name or IP address of the Hue bridge")
+	deviceType := fs.String("devicetype", "plexus#"+host, "The name the bridge shows for the app key")
+	timeout := fs.Duration("timeout", time.Minute, "The time to wait for the link button to be pressed")
+	fs.Parse(args[1:])
+	if *address == "" {
+		fmt.Fprintln(os.Stderr, "missing -address")
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	fmt.Fprintf(os.Stderr, "press the link button on the bridge at %s\n", *address)
+	for {
+		key, err := plex.PairHueBridge(ctx, *address, *deviceType)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "paired; add the bridge to the config's \"hue\" settings:\n")
+			fmt.Printf("{ \"address\": %q, \"key\": %q }\n", *address, key)
+			return 0
+		}
+		if !plex.IsHueLinkButtonError(err) && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "could not pair with %s: %v\n", *address, err)
+			return 1
+		}
+		select {
+		case <-ctx.Done():
+			fmt.Fprintf(os.Stderr, "could not pair with %s: the link button was not pressed within %s\n", *address, *timeout)
+			return 1
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
@@ -18,12 +19,25 @@ import (
 
 func main() {
 
+	// Subcommands.
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		os.Exit(runTests(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "hue" {
+		os.Exit(runHue(os.Args[2:]))
+	}
+
 	// Config.
 	var (
-		httpAddr       = flag.String("http.addr", ":3000", "HTTP listen address")
-		debugAddr      = flag.String("debug.addr", ":3001", "Debug and metrics listen address")
-		storeDirectory = flag.String("db.path", "./store", "The folder to be used as a JSON database for plexus")
-		configFile     = flag.String("config.file", "config.json", "The trigger configuration file")
+		httpAddr        = flag.String("http.addr", ":3000", "HTTP listen address")
+		debugAddr       = flag.String("debug.addr", ":3001", "Debug and metrics listen address")
+		storeDirectory  = flag.String("db.path", "./store", "The folder to be used as a JSON database for plexus")
+		configFile      = flag.String("config.file", "config.json", "The trigger configuration file")
+		configTests     = flag.String("config.tests", "fail", "What to do when embedded trigger tests fail: fail, warn or off")
+		actionWorkers   = flag.Int("actions.workers", 4, "The number of actions run concurrently")
+		actionQueue     = flag.Int("actions.queue", 100, "The number of matched triggers whose actions may wait for a worker")
+		actionTimeout   = flag.Duration("actions.timeout", 30*time.Second, "The time limit of each action attempt, unless the action sets its own")
+		shutdownTimeout = flag.Duration("shutdown.timeout", 30*time.Second, "The time to wait for queued actions when shutting down")
 	)
 	flag.Parse()
 
@@ -51,43 +65,92 @@ func main() {
 	}()
 
 	// App.
-	go func() {
-		var srv http.Server
-
-		logger := log.With(logger, "transport", "http")
-		logger.Log("addr", *httpAddr)
+	httpLogger := log.With(logger, "transport", "http")
 
-		// Set up store
-		s, err := plex.NewStore(*storeDirectory)
-		if err != nil {
-			errc <- err
-		}
+	// Set up store
+	s, err := plex.NewStore(*storeDirectory)
+	if err != nil {
+		httpLogger.Log("exit", err)
+		os.Exit(1)
+	}
 
-		// Load config
-		cf, err := os.Open(*configFile)
-		if err != nil {
-			errc <- err
-		}
-		cfg, err := plex.NewConfig(cf)
-		if err != nil {
-			errc <- err
-		}
+	// Load config
+	cfg, err := loadConfig(*configFile)
+	if err == nil {
+		err = checkTests(cfg, *configTests, httpLogger)
+	}
+	if err != nil {
+		httpLogger.Log("exit", err)
+		os.Exit(1)
+	}
+	engine := plex.NewEngine(cfg, s)
+	engine.Start(plex.ExecutorOptions{
+		Workers:   *actionWorkers,
+		QueueSize: *actionQueue,
+		Timeout:   *actionTimeout,
+	})
+	if err := engine.Restore(httpLogger); err != nil {
+		httpLogger.Log("exit", err)
+		os.Exit(1)
+	}
 
-		logger.Log("store", *storeDirectory, "config", *configFile)
+	httpLogger.Log("store", *storeDirectory, "config", *configFile)
 
-		// Server config
-		h, err := ph.DefaultRequestHandler(logger, s, cfg)
-		if err != nil {
-			errc <- err
+	// Reload config on SIGHUP
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGHUP)
+		for range c {
+			cfg, err := loadConfig(*configFile)
+			if err != nil {
+				httpLogger.Log("msg", "could not reload config, keeping current config", "err", err)
+				continue
+			}
+			if err := checkTests(cfg, *configTests, httpLogger); err != nil {
+				httpLogger.Log("msg", "could not reload config, keeping current config", "err", err)
+				continue
+			}
+			engine.Load(cfg)
+			httpLogger.Log("msg", "reloaded config", "config", *configFile)
 		}
-		srv.Addr = *httpAddr
-		srv.Handler = h
-		srv.ReadTimeout = time.Second * 30
-		srv.WriteTimeout = time.Second * 30
+	}()
 
+	// Server config
+	h, err := ph.DefaultRequestHandler(httpLogger, s, engine)
+	if err != nil {
+		httpLogger.Log("exit", err)
+		os.Exit(1)
+	}
+	srv := http.Server{
+		Addr:         *httpAddr,
+		Handler:      h,
+		ReadTimeout:  time.Second * 30,
+		WriteTimeout: time.Second * 30,
+	}
+	go func() {
+		httpLogger.Log("addr", *httpAddr)
 		errc <- srv.ListenAndServe()
 	}()
 
 	// Run.
 	logger.Log("exit", <-errc)
+
+	// Stop accepting webhooks, then let the queued actions run
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Log("msg", "could not shut down http server", "err", err)
+	}
+	if err := engine.Shutdown(ctx); err != nil {
+		logger.Log("msg", "gave up waiting for queued actions, saved them as dead letters", "err", err)
+	}
+}
+
+func loadConfig(path string) (plex.Config, error) {
+	cf, err := os.Open(path)
+	if err != nil {
+		return plex.Config{}, err
+	}
+	defer cf.Close()
+	return plex.NewConfig(cf)
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/clocklear/plexus/pkg/plex"
+	"github.com/go-kit/kit/log"
+)
+
+// runTests implements the 'plexus test' subcommand, running the test cases embedded in the config's triggers.  It
+// returns the process exit code.
+func runTests(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	configFile := fs.String("config.file", "config.json", "The trigger configuration file")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not load %s: %v\n", *configFile, err)
+		return 2
+	}
+	r := cfg.RunTests()
+	r.Write(os.Stdout)
+	if len(r.Failed()) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkTests runs the test cases embedded in the config according to mode: "fail" returns an error when any of them
+// fail, "warn" only logs the failures and "off" skips them altogether.
+func checkTests(cfg plex.Config, mode string, logger log.Logger) error {
+	if mode == "off" {
+		return nil
+	}
+	failed := cfg.RunTests().Failed()
+	for _, res := range failed {
+		logger.Log("msg", "trigger test failed", "trigger", res.Trigger, "test", res.Name, "expected", res.Expected, "actual", res.Actual)
+	}
+	if len(failed) > 0 && mode != "warn" {
+		return fmt.Errorf("%d trigger test(s) failed", len(failed))
+	}
+	return nil
+}
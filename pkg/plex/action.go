@@ -0,0 +1,89 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Action represents a type of thing to be done for a webhook event.  Execute should give up when ctx is done.
+type Action interface {
+	Execute(ctx context.Context, ev Event) error
+}
+
+// ActionConfig is the configuration of an action, given to the ActionFactory of its type
+type ActionConfig struct {
+	// Type is the action's type
+	Type string
+	// Raw is the action's "config" object
+	Raw json.RawMessage
+	// Root is the Config being loaded, for settings shared by the actions of a type
+	Root *Config
+}
+
+// Decode decodes the action's configuration into v, typically a pointer to a struct.  Fields v does not have are
+// an error.
+func (c ActionConfig) Decode(v interface{}) error {
+	raw := c.Raw
+	if len(raw) == 0 || string(raw) == "null" {
+		raw = json.RawMessage("{}")
+	}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("invalid %s config: %v", c.Type, err)
+	}
+	return nil
+}
+
+// ActionFactory creates an Action from its configuration, returning an error if the configuration is invalid
+type ActionFactory func(c ActionConfig) (Action, error)
+
+var (
+	actionsMu sync.RWMutex
+	actions   = map[string]ActionFactory{}
+)
+
+// RegisterAction makes an action type available to configs, created by the given factory.  It is typically called
+// from an init function.  Registering a type twice, or a nil factory, panics.
+func RegisterAction(typ string, f ActionFactory) {
+	actionsMu.Lock()
+	defer actionsMu.Unlock()
+	if f == nil {
+		panic("plex: RegisterAction factory is nil")
+	}
+	if _, dup := actions[typ]; dup {
+		panic("plex: RegisterAction called twice for action type " + typ)
+	}
+	actions[typ] = f
+}
+
+// ActionTypes returns the registered action types, sorted
+func ActionTypes() []string {
+	actionsMu.RLock()
+	defer actionsMu.RUnlock()
+	ts := make([]string, 0, len(actions))
+	for t := range actions {
+		ts = append(ts, t)
+	}
+	sort.Strings(ts)
+	return ts
+}
+
+// newAction creates an Action with the factory registered for its type
+func newAction(ra RawAction, root *Config) (Action, error) {
+	actionsMu.RLock()
+	f, ok := actions[ra.Type]
+	actionsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown action type %q", ra.Type)
+	}
+	raw, err := json.Marshal(ra.Config)
+	if err != nil {
+		return nil, err
+	}
+	return f(ActionConfig{Type: ra.Type, Raw: raw, Root: root})
+}
@@ -0,0 +1,104 @@
+package plex
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// countingAction is a test action type, registered as "counting", that records the events it runs for and fails
+// until it has been attempted Fails times
+type countingAction struct {
+	Fails  int    `json:"fails"`
+	Prefix string `json:"prefix"`
+
+	events *[]Event
+}
+
+var countedEvents []Event
+
+func init() {
+	RegisterAction("counting", func(c ActionConfig) (Action, error) {
+		a := countingAction{events: &countedEvents}
+		if err := c.Decode(&a); err != nil {
+			return nil, err
+		}
+		if a.Fails < 0 {
+			return nil, errors.New("fails must not be negative")
+		}
+		if c.Root.Location != nil {
+			a.Prefix += "located "
+		}
+		return a, nil
+	})
+}
+
+func (a countingAction) Execute(ctx context.Context, ev Event) error {
+	*a.events = append(*a.events, ev)
+	if ev.Attempt <= a.Fails {
+		return errors.New(a.Prefix + "failed")
+	}
+	return nil
+}
+
+func TestNewConfigActions(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		err    string
+	}{
+		{"registered", `{"type": "counting", "config": {"fails": 1}}`, ""},
+		{"no config", `{"type": "counting"}`, ""},
+		{"unknown type", `{"type": "carrier-pigeon", "config": {}}`, `unknown action type "carrier-pigeon"`},
+		{"unknown field", `{"type": "counting", "config": {"fail": 1}}`, `invalid counting config`},
+		{"wrong type", `{"type": "counting", "config": {"fails": "1"}}`, `invalid counting config`},
+		{"invalid", `{"type": "counting", "config": {"fails": -1}}`, `fails must not be negative`},
+		{"webhook unknown field", `{"type": "webhook", "config": {"url": "http://example.com", "verb": "POST"}}`, `invalid webhook config`},
+		{"webhook missing url", `{"type": "webhook", "config": {}}`, `missing URL`},
+	}
+	for _, tt := range tests {
+		_, err := NewConfig(strings.NewReader(`{"triggers": [{"properties": {}, "actions": [` + tt.action + `]}]}`))
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: expected an error containing %q, got: %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestRegisterActionTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected registering an action type twice to panic")
+		}
+	}()
+	RegisterAction("counting", func(c ActionConfig) (Action, error) { return nil, nil })
+}
+
+func TestActionEvent(t *testing.T) {
+	countedEvents = nil
+	cfg, err := NewConfig(strings.NewReader(`{"location": {"latitude": 40.7, "longitude": -74}, "triggers": [{
+		"id": "lights", "name": "Dim the lights", "tags": ["living"], "properties": {"event": "media.play"},
+		"actions": [{"type": "counting", "config": {"fails": 1}, "retry": {"attempts": 2, "backoff": "1ms"}}]
+	}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	if a := cfg.Triggers[0].ParsedActions[0].(countingAction); a.Prefix != "located " {
+		t.Errorf("Expected the factory to see the root config, got prefix %q", a.Prefix)
+	}
+	play(t, NewEngine(cfg, nil))
+	if len(countedEvents) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(countedEvents))
+	}
+	for i, ev := range countedEvents {
+		if ev.Trigger != "lights" || ev.TriggerName != "Dim the lights" || len(ev.Tags) != 1 || ev.Attempt != i+1 || ev.Logger == nil {
+			t.Errorf("Unexpected event for attempt %d: %+v", i+1, ev)
+		}
+		if ev.Activity.RequestID != "abc123" || ev.Document == nil {
+			t.Errorf("Expected the event to carry the activity, got %+v", ev)
+		}
+	}
+}
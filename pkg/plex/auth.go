@@ -0,0 +1,149 @@
+package plex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSignatureHeader = "X-Plexus-Signature"
+	defaultTimestampHeader = "X-Plexus-Timestamp"
+)
+
+// WebhookAuth authenticates the requests made by a WebhookAction.  Type is one of
+//
+//	basic:  HTTP basic auth with Username and Password
+//	bearer: an "Authorization: Bearer" header with Token
+//	header: a custom Header set to Value
+//	hmac:   an HMAC-SHA256 signature of the request, keyed with Secret
+//
+// An hmac signature is computed over the unix timestamp of the request, a '.' and the body, and sent hex encoded as
+// "sha256=<signature>" in the Header (X-Plexus-Signature by default), alongside the timestamp in the TimestampHeader
+// (X-Plexus-Timestamp by default).  Receivers should recompute the signature and reject stale timestamps.
+type WebhookAuth struct {
+	Type            string `json:"type"`
+	Username        string `json:"username,omitempty"`
+	Password        string `json:"password,omitempty"`
+	Token           string `json:"token,omitempty"`
+	Header          string `json:"header,omitempty"`
+	Value           string `json:"value,omitempty"`
+	Secret          string `json:"secret,omitempty"`
+	TimestampHeader string `json:"timestampHeader,omitempty"`
+}
+
+// validate checks that the settings required by the auth type are present
+func (a WebhookAuth) validate() error {
+	switch a.Type {
+	case "basic":
+		if a.Username == "" {
+			return fmt.Errorf("basic auth requires a username")
+		}
+	case "bearer":
+		if a.Token == "" {
+			return fmt.Errorf("bearer auth requires a token")
+		}
+	case "header":
+		if a.Header == "" || a.Value == "" {
+			return fmt.Errorf("header auth requires a header and a value")
+		}
+	case "hmac":
+		if a.Secret == "" {
+			return fmt.Errorf("hmac auth requires a secret")
+		}
+	default:
+		return fmt.Errorf("unknown auth type %q", a.Type)
+	}
+	return nil
+}
+
+// apply authenticates the request, whose body is given, as of now
+func (a WebhookAuth) apply(req *http.Request, body []byte, now time.Time) {
+	switch a.Type {
+	case "basic":
+		req.SetBasicAuth(a.Username, a.Password)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	case "header":
+		req.Header.Set(a.Header, a.Value)
+	case "hmac":
+		ts := strconv.FormatInt(now.Unix(), 10)
+		sh, th := a.Header, a.TimestampHeader
+		if sh == "" {
+			sh = defaultSignatureHeader
+		}
+		if th == "" {
+			th = defaultTimestampHeader
+		}
+		req.Header.Set(th, ts)
+		req.Header.Set(sh, "sha256="+signature(a.Secret, ts, body))
+	}
+}
+
+// signature computes the hex encoded HMAC-SHA256 of the timestamp and body
+func signature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// redactURL hides the secrets a request URL may carry, so it can be logged: any password in its user info, the values
+// of its query string and the segments of its path rendered from the template tmpl
+func redactURL(raw, tmpl string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "xxxxx"
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			q[k] = []string{"xxxxx"}
+		}
+		u.RawQuery = q.Encode()
+	}
+	if strings.Contains(templatePath(tmpl), "{{") {
+		segs, tsegs := strings.Split(u.Path, "/"), strings.Split(templatePath(tmpl), "/")
+		if len(segs) != len(tsegs) {
+			// A template rendered a slash, so there is no telling which segments are templated
+			u.Path = "/xxxxx"
+		} else {
+			for i, ts := range tsegs {
+				if strings.Contains(ts, "{{") {
+					segs[i] = "xxxxx"
+				}
+			}
+			u.Path = strings.Join(segs, "/")
+		}
+		u.RawPath = ""
+	}
+	return u.String()
+}
+
+// templatePath returns the path of a URL template: what follows its scheme and host, up to its query or fragment
+func templatePath(tmpl string) string {
+	if i := strings.Index(tmpl, "://"); i >= 0 {
+		tmpl = tmpl[i+3:]
+	}
+	i := strings.Index(tmpl, "/")
+	if i < 0 {
+		return ""
+	}
+	tmpl = tmpl[i:]
+	if i := strings.IndexAny(tmpl, "?#"); i >= 0 {
+		tmpl = tmpl[:i]
+	}
+	return tmpl
+}
@@ -0,0 +1,300 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterAction("chat", newChatAction)
+}
+
+// chatTemplates are the default title and text of chat messages, by webhook event; chatFallback is used for the
+// others
+var (
+	chatTemplates = map[string][2]string{
+		"media.play":  {"{{.Payload.Account.Title}} started {{.Payload.MediaTitle}} on {{.Payload.Player.Title}}", ""},
+		"media.stop":  {"{{.Payload.Account.Title}} stopped {{.Payload.MediaTitle}} on {{.Payload.Player.Title}}", ""},
+		"media.rate":  {"{{.Payload.Account.Title}} rated {{.Payload.MediaTitle}}{{with .Raw.Metadata.userRating}} {{.}}/10{{end}}", ""},
+		"library.new": {"New on {{.Payload.Server.Title}}: {{.Payload.MediaTitle}}", "{{.Payload.Metadata.Summary}}"},
+	}
+	chatFallback = [2]string{"{{.Payload.Account.Title | default .Payload.Server.Title}}: {{.Payload.Event}} {{.Payload.MediaTitle}}", ""}
+)
+
+// ChatAction posts a message about the event to a Slack, Discord or Microsoft Teams incoming webhook, formatted as
+// Slack blocks, a Discord embed or a Teams adaptive card.  Title and Text are templates rendered over the event (see
+// templateData); each defaults to a message suited to the webhook event, such as "Alice started The Expanse S02E03 on
+// Living Room TV".
+//
+// Thumb decides how the poster saved with the webhook is shown: "link" links to it through Plexus' thumbs endpoint,
+// which requires the config's PublicURL; "upload" attaches it to the message, which only Discord supports (Slack
+// webhooks cannot upload images, and a poster inlined in a Teams card exceeds its size limit); "none" leaves it out.
+// It defaults to "link" when a PublicURL is configured, and "none" otherwise.
+type ChatAction struct {
+	Platform string `json:"platform"`
+	URL      string `json:"url"`
+	Title    string `json:"title,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Thumb    string `json:"thumb,omitempty"`
+
+	publicURL string
+	title     *Template
+	text      *Template
+}
+
+// newChatAction creates a ChatAction from its configuration, compiling its templates
+func newChatAction(c ActionConfig) (Action, error) {
+	a := ChatAction{}
+	if err := c.Decode(&a); err != nil {
+		return nil, err
+	}
+	switch a.Platform {
+	case "slack", "discord", "teams":
+	default:
+		return nil, fmt.Errorf("invalid chat action specified; platform must be slack, discord or teams")
+	}
+	if a.URL == "" {
+		return nil, fmt.Errorf("invalid chat action specified; missing url")
+	}
+	a.publicURL = strings.TrimSuffix(c.Root.PublicURL, "/")
+	var err error
+	if a.Thumb, err = thumbSetting("chat", a.Thumb, a.publicURL, "upload"); err != nil {
+		return nil, err
+	}
+	if a.Thumb == "upload" {
+		switch a.Platform {
+		case "slack":
+			return nil, fmt.Errorf("invalid chat action specified; slack webhooks cannot upload thumbs, link them instead")
+		case "teams":
+			return nil, fmt.Errorf("invalid chat action specified; teams cards are too small to inline thumbs, link them instead")
+		}
+	}
+	if err := compileTemplates("chat", templateField{"title", a.Title, &a.title}, templateField{"text", a.Text, &a.text}); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// thumbSetting validates the Thumb setting of an action that can link thumbs, which is "link", "none" or one of the
+// action's own modes.  It defaults to "link" when the config has a PublicURL, and "none" otherwise.
+func thumbSetting(action, thumb, publicURL string, modes ...string) (string, error) {
+	switch thumb {
+	case "":
+		if publicURL != "" {
+			return "link", nil
+		}
+		return "none", nil
+	case "none":
+		return thumb, nil
+	case "link":
+		if publicURL == "" {
+			return "", fmt.Errorf("invalid %s action specified; linking thumbs requires the config's publicUrl", action)
+		}
+		return thumb, nil
+	}
+	for _, m := range modes {
+		if thumb == m {
+			return thumb, nil
+		}
+	}
+	valid := append([]string{"link"}, modes...)
+	sort.Strings(valid)
+	return "", fmt.Errorf("invalid %s action specified; thumb must be %s or none", action, strings.Join(valid, ", "))
+}
+
+// defaultChatTemplates holds the compiled chatTemplates and chatFallback
+var defaultChatTemplates = func() map[string][2]*Template {
+	m := map[string][2]*Template{}
+	for ev, tt := range chatTemplates {
+		m[ev] = [2]*Template{mustCompileTemplate(ev+" title", tt[0]), mustCompileTemplate(ev+" text", tt[1])}
+	}
+	m[""] = [2]*Template{mustCompileTemplate("title", chatFallback[0]), mustCompileTemplate("text", chatFallback[1])}
+	return m
+}()
+
+// chatDefaults returns the default title and text templates for the event
+func chatDefaults(ev Event) [2]*Template {
+	if d, ok := defaultChatTemplates[ev.Activity.Payload.Event]; ok {
+		return d
+	}
+	return defaultChatTemplates[""]
+}
+
+// mustCompileTemplate compiles a built-in template, panicking if it is invalid
+func mustCompileTemplate(name, text string) *Template {
+	t, err := compileTemplate(name, text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// chatMessage is a rendered chat message
+type chatMessage struct {
+	title string
+	text  string
+	// thumbURL links to the thumb, or names the attachment holding it
+	thumbURL string
+	// thumb and thumbName are the uploaded thumb
+	thumb     []byte
+	thumbName string
+}
+
+// Execute renders the message and posts it
+func (a ChatAction) Execute(ctx context.Context, ev Event) error {
+	msg, err := a.render(ev)
+	if err != nil {
+		return err
+	}
+	var body []byte
+	switch a.Platform {
+	case "slack":
+		body, err = json.Marshal(slackMessage(msg))
+	case "discord":
+		body, err = json.Marshal(discordMessage(msg))
+	case "teams":
+		body, err = json.Marshal(teamsMessage(msg))
+	}
+	if err != nil {
+		return err
+	}
+	contentType := "application/json"
+	if a.Platform == "discord" && msg.thumb != nil {
+		// Discord takes attachments as multipart files alongside the message
+		buf := bytes.Buffer{}
+		mw := multipart.NewWriter(&buf)
+		if err := mw.WriteField("payload_json", string(body)); err != nil {
+			return err
+		}
+		fw, err := mw.CreateFormFile("files[0]", msg.thumbName)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(msg.thumb); err != nil {
+			return err
+		}
+		if err := mw.Close(); err != nil {
+			return err
+		}
+		body, contentType = buf.Bytes(), mw.FormDataContentType()
+	}
+	req, err := http.NewRequest("POST", a.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	ev.logger().Log("action", "chat", "platform", a.Platform, "title", msg.title, "thumb", a.Thumb)
+	return sendRequest(ctx, req, nil)
+}
+
+// render renders the message's title and text, and loads or links its thumb
+func (a ChatAction) render(ev Event) (chatMessage, error) {
+	msg := chatMessage{}
+	defaults := chatDefaults(ev)
+	title, text := a.title, a.text
+	if title == nil {
+		title = defaults[0]
+	}
+	if text == nil {
+		text = defaults[1]
+	}
+	var err error
+	if msg.title, err = title.render(ev); err != nil {
+		return msg, fmt.Errorf("could not render chat title: %v", err)
+	}
+	if msg.text, err = text.render(ev); err != nil {
+		return msg, fmt.Errorf("could not render chat text: %v", err)
+	}
+	tp := ev.Activity.ThumbPath
+	if tp == "" {
+		return msg, nil
+	}
+	switch a.Thumb {
+	case "link":
+		msg.thumbURL = thumbLink(a.publicURL, ev)
+	case "upload":
+		if msg.thumb, err = ioutil.ReadFile(tp); err != nil {
+			return msg, fmt.Errorf("could not read thumb: %v", err)
+		}
+		msg.thumbName = "thumb" + filepath.Ext(tp)
+		msg.thumbURL = "attachment://" + msg.thumbName
+	}
+	return msg, nil
+}
+
+// thumbLink returns the url of the event's thumb on Plexus' thumbs endpoint, under the config's PublicURL
+func thumbLink(publicURL string, ev Event) string {
+	return publicURL + "/thumbs/" + url.PathEscape(ev.Activity.RequestID)
+}
+
+// slackEscape escapes the characters Slack's mrkdwn treats as control characters
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// slackMessage formats the message as Slack blocks: a section with the title and text, and the thumb beside them
+func slackMessage(msg chatMessage) map[string]interface{} {
+	text := "*" + slackEscape(msg.title) + "*"
+	if msg.text != "" {
+		text += "\n" + slackEscape(msg.text)
+	}
+	section := map[string]interface{}{
+		"type": "section",
+		"text": map[string]interface{}{"type": "mrkdwn", "text": text},
+	}
+	if msg.thumbURL != "" {
+		section["accessory"] = map[string]interface{}{"type": "image", "image_url": msg.thumbURL, "alt_text": msg.title}
+	}
+	return map[string]interface{}{
+		"text":   msg.title,
+		"blocks": []interface{}{section},
+	}
+}
+
+// discordMessage formats the message as a Discord embed, with the thumb as its thumbnail
+func discordMessage(msg chatMessage) map[string]interface{} {
+	embed := map[string]interface{}{"title": msg.title}
+	if msg.text != "" {
+		embed["description"] = msg.text
+	}
+	if msg.thumbURL != "" {
+		embed["thumbnail"] = map[string]interface{}{"url": msg.thumbURL}
+	}
+	return map[string]interface{}{"embeds": []interface{}{embed}}
+}
+
+// teamsMessage formats the message as an adaptive card, with the thumb below the title and text
+func teamsMessage(msg chatMessage) map[string]interface{} {
+	body := []interface{}{
+		map[string]interface{}{"type": "TextBlock", "text": msg.title, "weight": "Bolder", "size": "Medium", "wrap": true},
+	}
+	if msg.text != "" {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": msg.text, "wrap": true})
+	}
+	if msg.thumbURL != "" {
+		body = append(body, map[string]interface{}{"type": "Image", "url": msg.thumbURL, "size": "Medium", "altText": msg.title})
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]interface{}{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body":    body,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,200 @@
+package plex
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chatRequest is a request received by the chat webhook stand-in
+type chatRequest struct {
+	contentType string
+	body        interface{}
+	files       map[string]string
+}
+
+// newChatServer starts a chat webhook stand-in recording the messages it receives, decoding multipart messages the
+// way Discord does
+func newChatServer(t *testing.T, got *[]chatRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cr := chatRequest{contentType: r.Header.Get("Content-Type")}
+		body := []byte{}
+		if strings.HasPrefix(cr.contentType, "multipart/form-data") {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Errorf("Could not parse multipart message: %v", err)
+			}
+			body = []byte(r.FormValue("payload_json"))
+			cr.files = map[string]string{}
+			for field, fhs := range r.MultipartForm.File {
+				f, _ := fhs[0].Open()
+				b, _ := ioutil.ReadAll(f)
+				f.Close()
+				cr.files[field] = fhs[0].Filename + ":" + string(b)
+			}
+		} else {
+			body, _ = ioutil.ReadAll(r.Body)
+		}
+		if err := json.Unmarshal(body, &cr.body); err != nil {
+			t.Errorf("Could not decode message %q: %v", body, err)
+		}
+		*got = append(*got, cr)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+// chatEvent is an episode starting on the living room TV, with a thumb saved in dir
+func chatEvent(t *testing.T, dir string) Event {
+	ev := templateEvent(t)
+	ev.Activity.Payload.Account.Title = "Alice"
+	ev.Activity.Payload.Player.Title = "Living Room TV"
+	ev.Activity.Payload.Metadata.Type = "episode"
+	ev.Activity.Payload.Metadata.GrandparentTitle = "The Expanse"
+	ev.Activity.Payload.Metadata.ParentIndex = 2
+	ev.Activity.Payload.Metadata.Index = 3
+	ev.Activity.ThumbPath = dir + "/abc123.png"
+	if err := ioutil.WriteFile(ev.Activity.ThumbPath, []byte("PNG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+// chatMessages runs the chat actions configured by actions over ev, returning the messages they sent
+func chatMessages(t *testing.T, ev Event, publicURL, actions string) []chatRequest {
+	var got []chatRequest
+	srv := newChatServer(t, &got)
+	defer srv.Close()
+	actions = strings.Replace(actions, "URL", srv.URL, -1)
+	cfg, err := NewConfig(strings.NewReader(`{"publicUrl": "` + publicURL + `", "triggers": [{"properties": {}, "actions": [` + actions + `]}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	for _, a := range cfg.Triggers[0].ParsedActions {
+		if err := a.Execute(context.Background(), ev); err != nil {
+			t.Errorf("Unexpected error executing action: %v", err)
+		}
+	}
+	return got
+}
+
+// jsonValue decodes a JSON literal for comparison with a decoded message
+func jsonValue(t *testing.T, s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("Invalid JSON %s: %v", s, err)
+	}
+	return v
+}
+
+func TestChatActionLinked(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	ev := chatEvent(t, s.dbPath)
+	got := chatMessages(t, ev, "https://plexus.example.com/", `
+		{"type": "chat", "config": {"platform": "slack", "url": "URL"}},
+		{"type": "chat", "config": {"platform": "discord", "url": "URL"}},
+		{"type": "chat", "config": {"platform": "teams", "url": "URL", "title": "{{.Payload.MediaTitle}} <{{.Trigger}}>", "text": "on {{.Payload.Player.Title}}"}}`)
+	thumb := "https://plexus.example.com/thumbs/abc123"
+	want := []interface{}{
+		jsonValue(t, `{"text": "Alice started The Expanse S02E03 on Living Room TV", "blocks": [{"type": "section",
+			"text": {"type": "mrkdwn", "text": "*Alice started The Expanse S02E03 on Living Room TV*"},
+			"accessory": {"type": "image", "image_url": "`+thumb+`", "alt_text": "Alice started The Expanse S02E03 on Living Room TV"}}]}`),
+		jsonValue(t, `{"embeds": [{"title": "Alice started The Expanse S02E03 on Living Room TV", "thumbnail": {"url": "`+thumb+`"}}]}`),
+		jsonValue(t, `{"type": "message", "attachments": [{"contentType": "application/vnd.microsoft.card.adaptive", "content": {
+			"$schema": "http://adaptivecards.io/schemas/adaptive-card.json", "type": "AdaptiveCard", "version": "1.4", "body": [
+				{"type": "TextBlock", "text": "The Expanse S02E03 <living-room>", "weight": "Bolder", "size": "Medium", "wrap": true},
+				{"type": "TextBlock", "text": "on Living Room TV", "wrap": true},
+				{"type": "Image", "url": "`+thumb+`", "size": "Medium", "altText": "The Expanse S02E03 <living-room>"}]}}]}`),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d messages, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].contentType != "application/json" || !reflect.DeepEqual(got[i].body, want[i]) {
+			t.Errorf("Message %d: expected %v, got %s %v", i, want[i], got[i].contentType, got[i].body)
+		}
+	}
+}
+
+func TestChatActionUploaded(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	ev := chatEvent(t, s.dbPath)
+	got := chatMessages(t, ev, "", `
+		{"type": "chat", "config": {"platform": "discord", "url": "URL", "thumb": "upload"}},
+		{"type": "chat", "config": {"platform": "slack", "url": "URL"}}`)
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(got))
+	}
+	discord := jsonValue(t, `{"embeds": [{"title": "Alice started The Expanse S02E03 on Living Room TV", "thumbnail": {"url": "attachment://thumb.png"}}]}`)
+	if !reflect.DeepEqual(got[0].body, discord) || !reflect.DeepEqual(got[0].files, map[string]string{"files[0]": "thumb.png:PNG"}) {
+		t.Errorf("Expected discord message %v with the thumb attached, got %v %v", discord, got[0].body, got[0].files)
+	}
+	section := got[1].body.(map[string]interface{})["blocks"].([]interface{})[0].(map[string]interface{})
+	if _, ok := section["accessory"]; ok {
+		t.Errorf("Expected no thumb without a publicUrl, got %v", section)
+	}
+}
+
+func TestChatDefaultTemplates(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	ev := chatEvent(t, s.dbPath)
+	tests := []struct {
+		event  string
+		change func(ev *Event)
+		title  string
+		text   string
+	}{
+		{"media.stop", nil, "Alice stopped The Expanse S02E03 on Living Room TV", ""},
+		{"media.rate", func(ev *Event) {
+			ev.Document = map[string]interface{}{"Metadata": map[string]interface{}{"userRating": 8.0}}
+		}, "Alice rated The Expanse S02E03 8/10", ""},
+		{"library.new", func(ev *Event) {
+			ev.Activity.Payload.Server.Title = "Basement"
+			ev.Activity.Payload.Metadata.Type = "movie"
+			ev.Activity.Payload.Metadata.Summary = "In space, no one can hear you scream."
+		}, "New on Basement: Alien", "In space, no one can hear you scream."},
+		{"media.pause", nil, "Alice: media.pause The Expanse S02E03", ""},
+	}
+	a, err := newChatAction(ActionConfig{Type: "chat", Raw: []byte(`{"platform": "slack", "url": "http://example.com"}`), Root: &Config{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		ev := ev
+		ev.Activity.Payload.Event = tt.event
+		if tt.change != nil {
+			tt.change(&ev)
+		}
+		msg, err := a.(ChatAction).render(ev)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.event, err)
+			continue
+		}
+		if msg.title != tt.title || msg.text != tt.text {
+			t.Errorf("%s: expected %q / %q, got %q / %q", tt.event, tt.title, tt.text, msg.title, msg.text)
+		}
+	}
+}
+
+func TestNewConfigInvalidChat(t *testing.T) {
+	for _, config := range []string{
+		`{"publicUrl": "plexus.local", "triggers": []}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "chat", "config": {"platform": "irc", "url": "http://example.com"}}]}]}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "chat", "config": {"platform": "slack"}}]}]}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "chat", "config": {"platform": "slack", "url": "http://example.com", "thumb": "link"}}]}]}`,
+		`{"publicUrl": "http://plexus.local", "triggers": [{"properties": {}, "actions": [{"type": "chat", "config": {"platform": "slack", "url": "http://example.com", "thumb": "upload"}}]}]}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "chat", "config": {"platform": "teams", "url": "http://example.com", "thumb": "upload"}}]}]}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "chat", "config": {"platform": "discord", "url": "http://example.com", "thumb": "inline"}}]}]}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "chat", "config": {"platform": "discord", "url": "http://example.com", "title": "{{"}}]}]}`,
+	} {
+		if _, err := NewConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("Expected an error loading %s, got none", config)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package plex
+
+import "time"
+
+// Clock tells the time and schedules work for later.  It is abstracted so that time dependent trigger behavior can
+// be unit tested.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call scheduled through a Clock
+type Timer interface {
+	Stop() bool
+}
+
+// SystemClock is the Clock backed by the system time
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
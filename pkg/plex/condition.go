@@ -0,0 +1,142 @@
+package plex
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Condition is a node in a trigger's condition tree.  Every property must match, every block in All must match, at
+// least one block in Any must match (when Any is not empty) and the Not block must not match.  In JSON, a block
+// inside all/any/not may also be written as a bare property map, e.g. {"any": [{"event": "media.play"}, ...]}.
+type Condition struct {
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	All        []Condition            `json:"all,omitempty"`
+	Any        []Condition            `json:"any,omitempty"`
+	Not        *Condition             `json:"not,omitempty"`
+}
+
+// UnmarshalJSON decodes a condition block, accepting the bare property map shorthand
+func (c *Condition) UnmarshalJSON(b []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	group := len(raw) > 0
+	for k := range raw {
+		switch k {
+		case "properties", "all", "any", "not":
+		default:
+			group = false
+		}
+	}
+	if !group {
+		c.Properties = map[string]interface{}{}
+		return json.Unmarshal(b, &c.Properties)
+	}
+	type condition Condition
+	return json.Unmarshal(b, (*condition)(c))
+}
+
+// compile builds a matcher for the condition tree, pre-splitting property paths and compiling operators
+func (c Condition) compile() (*matcher, error) {
+	m := matcher{}
+	keys := make([]string, 0, len(c.Properties))
+	for k := range c.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		path, err := parsePath(k)
+		if err != nil {
+			return nil, err
+		}
+		ops, err := compileOperators(c.Properties[k])
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition for property %q: %v", k, err)
+		}
+		m.props = append(m.props, propertyMatcher{
+			name: k,
+			path: path,
+			ops:  ops,
+		})
+	}
+	for _, sub := range c.All {
+		sm, err := sub.compile()
+		if err != nil {
+			return nil, err
+		}
+		m.all = append(m.all, sm)
+	}
+	for _, sub := range c.Any {
+		sm, err := sub.compile()
+		if err != nil {
+			return nil, err
+		}
+		m.any = append(m.any, sm)
+	}
+	if c.Not != nil {
+		sm, err := c.Not.compile()
+		if err != nil {
+			return nil, err
+		}
+		m.not = sm
+	}
+	return &m, nil
+}
+
+// matcher is a compiled Condition
+type matcher struct {
+	props []propertyMatcher
+	all   []*matcher
+	any   []*matcher
+	not   *matcher
+}
+
+// match evaluates the compiled condition tree against a parsed payload document
+func (m *matcher) match(doc interface{}) bool {
+	for _, p := range m.props {
+		// If we encounter a non-match, short-circuit and return false
+		if !p.match(doc) {
+			return false
+		}
+	}
+	for _, sub := range m.all {
+		if !sub.match(doc) {
+			return false
+		}
+	}
+	if len(m.any) > 0 {
+		ok := false
+		for _, sub := range m.any {
+			if sub.match(doc) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if m.not != nil && m.not.match(doc) {
+		return false
+	}
+	return true
+}
+
+// propertyMatcher tests the value found at a single property path
+type propertyMatcher struct {
+	name string
+	path propertyPath
+	ops  []operator
+}
+
+func (p propertyMatcher) match(doc interface{}) bool {
+	vals, multi := p.path.resolve(doc)
+	for _, op := range p.ops {
+		if !op.matchValues(vals, multi) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,74 +1,250 @@
 package plex
 
 import (
-	"io"
 	"encoding/json"
-	"net/http"
 	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/Jeffail/gabs"
+	"github.com/clocklear/plexus/pkg/plex/schema"
 	"github.com/go-kit/kit/log"
 )
 
 // NewConfig returns an instance of config loaded from the given io.Reader
 func NewConfig(r io.Reader) (Config, error) {
-	cfg := Config{}
+	cfg := Config{
+		Limiter:    NewLimiter(),
+		Correlator: NewCorrelator(nil),
+		Executor:   NewExecutor(nil),
+	}
 	err := json.NewDecoder(r).Decode(&cfg)
 	// The store might be empty, which is ok
 	if err != nil {
 		return cfg, err
 	}
+	if cfg.Location != nil {
+		if err := cfg.Location.validate(); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.PublicURL != "" {
+		u, err := url.Parse(cfg.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("invalid publicUrl %q; must be an http or https url", cfg.PublicURL)
+		}
+	}
+	if cfg.SMTP != nil {
+		if err := cfg.SMTP.compile(); err != nil {
+			return cfg, fmt.Errorf("smtp relay: %v", err)
+		}
+	}
+	for name, b := range cfg.MQTT {
+		if b == nil {
+			return cfg, fmt.Errorf("mqtt broker %q: missing settings", name)
+		}
+		if err := b.compile(); err != nil {
+			return cfg, fmt.Errorf("mqtt broker %q: %v", name, err)
+		}
+	}
+	for name, h := range cfg.HomeAssistant {
+		if h == nil {
+			return cfg, fmt.Errorf("homeassistant instance %q: missing settings", name)
+		}
+		if err := h.validate(); err != nil {
+			return cfg, fmt.Errorf("homeassistant instance %q: %v", name, err)
+		}
+	}
+	for name, b := range cfg.Hue {
+		if b == nil {
+			return cfg, fmt.Errorf("hue bridge %q: missing settings", name)
+		}
+		if err := b.validate(); err != nil {
+			return cfg, fmt.Errorf("hue bridge %q: %v", name, err)
+		}
+	}
+	for name, n := range cfg.Ntfy {
+		if n == nil {
+			return cfg, fmt.Errorf("ntfy server %q: missing settings", name)
+		}
+		if err := n.validate(); err != nil {
+			return cfg, fmt.Errorf("ntfy server %q: %v", name, err)
+		}
+	}
+	for name, g := range cfg.Gotify {
+		if g == nil {
+			return cfg, fmt.Errorf("gotify server %q: missing settings", name)
+		}
+		if err := g.validate(); err != nil {
+			return cfg, fmt.Errorf("gotify server %q: %v", name, err)
+		}
+	}
+	ids := map[string]bool{}
+	// The validator of test payloads is only built when a trigger has tests
+	var validator *schema.Validator
 	for i, t := range cfg.Triggers {
+		m, err := t.condition().compile()
+		if err != nil {
+			return cfg, fmt.Errorf("trigger %d: %v", i, err)
+		}
+		cfg.Triggers[i].matcher = m
+		if t.When != nil {
+			s, err := t.When.compile(cfg.Location)
+			if err != nil {
+				return cfg, fmt.Errorf("trigger %d: invalid when: %v", i, err)
+			}
+			cfg.Triggers[i].schedule = s
+		}
+		if t.Limit != nil {
+			l, err := t.Limit.compile()
+			if err != nil {
+				return cfg, fmt.Errorf("trigger %d: invalid limit: %v", i, err)
+			}
+			cfg.Triggers[i].limit = l
+		}
+		if t.Correlation != nil {
+			cp, err := t.Correlation.compile()
+			if err != nil {
+				return cfg, fmt.Errorf("trigger %d: invalid correlation: %v", i, err)
+			}
+			cfg.Triggers[i].correlation = cp
+		}
+		if t.ID == "" {
+			// Derive an ID from the position and name.  It moves when triggers are inserted, removed or reordered,
+			// so it cannot be trusted to identify the trigger of persisted state (see requiresID).
+			cfg.Triggers[i].ID = t.fallbackID(i)
+			cfg.Triggers[i].derivedID = true
+		}
+		if ids[cfg.Triggers[i].ID] {
+			return cfg, fmt.Errorf("trigger %d: duplicate id %q", i, cfg.Triggers[i].ID)
+		}
+		ids[cfg.Triggers[i].ID] = true
 		cfg.Triggers[i].ParsedActions = []Action{}
-		for _, ra := range t.RawActions {
-			switch ra.Type {
-			case "webhook":
-				c, err := gabs.Consume(ra.Config)
-				if err != nil {
-					return cfg, fmt.Errorf("invalid webhook action configuration specified: %v", err)
+		cfg.Triggers[i].actions = []actionSpec{}
+		for j, ra := range t.RawActions {
+			spec := actionSpec{typ: ra.Type, retry: noRetry}
+			if ra.Retry != nil {
+				if spec.retry, err = ra.Retry.compile(); err != nil {
+					return cfg, fmt.Errorf("trigger %d: action %d: invalid retry: %v", i, j, err)
 				}
-				url, ok := c.Path("url").Data().(string)
-				if !ok {
-					return cfg, fmt.Errorf("invalid webhook action specified; missing URL")
+			}
+			if spec.timeout, err = parsePositiveDuration("timeout", ra.Timeout); err != nil {
+				return cfg, fmt.Errorf("trigger %d: action %d: %v", i, j, err)
+			}
+			if spec.delay, err = parsePositiveDuration("delay", ra.Delay); err != nil {
+				return cfg, fmt.Errorf("trigger %d: action %d: %v", i, j, err)
+			}
+			if ra.CancelOn != nil {
+				if spec.delay == 0 {
+					return cfg, fmt.Errorf("trigger %d: action %d: cancelOn requires a delay", i, j)
 				}
-				act, ok := c.Path("action").Data().(string)
-				if !ok {
-					act = "GET"
+				if spec.cancel, err = ra.CancelOn.compile(); err != nil {
+					return cfg, fmt.Errorf("trigger %d: action %d: invalid cancelOn: %v", i, j, err)
 				}
-				cfg.Triggers[i].ParsedActions = append(cfg.Triggers[i].ParsedActions, WebhookAction{
-					URL: url,
-					Action: act,
-				})
-			default:
-				// Nothing, this is something we don't know how to handle
+				spec.cancelOn = ra.CancelOn
+			}
+			a, err := newAction(ra, &cfg)
+			if err != nil {
+				return cfg, fmt.Errorf("trigger %d: action %d: %v", i, j, err)
+			}
+			cfg.Triggers[i].ParsedActions = append(cfg.Triggers[i].ParsedActions, a)
+			cfg.Triggers[i].actions = append(cfg.Triggers[i].actions, spec)
+		}
+		if cfg.Triggers[i].derivedID && cfg.Triggers[i].requiresID() {
+			return cfg, fmt.Errorf("trigger %d: an id is required for triggers with a correlation, delayed actions or retries", i)
+		}
+		for j, tc := range t.Tests {
+			if validator == nil {
+				if validator, err = schema.NewValidator(); err != nil {
+					return cfg, err
+				}
+			}
+			if err := tc.validate(validator); err != nil {
+				return cfg, fmt.Errorf("trigger %d: test %d: invalid payload: %v", i, j, err)
 			}
 		}
 	}
 	return cfg, nil
-	
+
 }
 
 // Config represents a plexus config
 type Config struct {
-	Triggers []Trigger `json:"triggers"`
+	Triggers      []Trigger                 `json:"triggers"`
+	Location      *Location                 `json:"location,omitempty"`
+	MQTT          map[string]*MQTTBroker    `json:"mqtt,omitempty"`
+	HomeAssistant map[string]*HomeAssistant `json:"homeassistant,omitempty"`
+	Hue           map[string]*HueBridge     `json:"hue,omitempty"`
+	IFTTT         *IFTTT                    `json:"ifttt,omitempty"`
+	SMTP          *SMTPRelay                `json:"smtp,omitempty"`
+	Ntfy          map[string]*NtfyServer    `json:"ntfy,omitempty"`
+	Gotify        map[string]*GotifyServer  `json:"gotify,omitempty"`
+	PublicURL     string                    `json:"publicUrl,omitempty"`
+	Clock         Clock                     `json:"-"`
+	Limiter       *Limiter                  `json:"-"`
+	Correlator    *Correlator               `json:"-"`
+	Toggles       *Toggles                  `json:"-"`
+	Executor      *Executor                 `json:"-"`
+	Scheduler     *Scheduler                `json:"-"`
 }
 
-// Handle uses the current configuration to transact the given webhookpayload
-func (c Config) Handle(logger log.Logger, pl WebhookPayload, raw []byte) error {
+// Handle uses the current configuration to transact the given activity.  doc is the activity's payload parsed into
+// a generic JSON document (see ParseDocument), which is evaluated against every trigger.  Time conditions are
+// evaluated against the activity's ReceivedAt, or the config's Clock when it is not set.  Trigger limits are
+// enforced with the config's Limiter, and correlation timers are held by its Correlator; when either is nil, the
+// corresponding trigger settings are ignored.  Triggers are enabled according to the config's Toggles, or their
+// configured flag when it is nil.  Actions are run by the config's Executor; an action that fails does not fail
+// Handle, but is retried and recorded as a dead letter according to the Executor.  Delayed actions are cancelled by
+// follow-up events with the config's Scheduler, when it is set.
+func (c Config) Handle(logger log.Logger, act Activity, doc interface{}) error {
+	at := act.ReceivedAt
+	if at.IsZero() {
+		at = c.clock().Now()
+	}
+	// Follow-up events cancel armed correlations first
+	if c.Correlator != nil {
+		for _, t := range c.Triggers {
+			if t.correlation != nil && t.correlation.cancels(doc) {
+				c.Correlator.cancel(log.With(logger, "trigger", t.ID), t, doc)
+			}
+		}
+	}
+	if c.Scheduler != nil {
+		c.Scheduler.cancelMatching(logger, act, doc)
+	}
 	m := false
 	for _, t := range c.Triggers {
-		if !t.IsMatch(raw) {
+		if !c.Toggles.enabled(t) || !t.Matches(doc) || !t.ActiveAt(at) {
 			continue
 		}
 		m = true
-		logger.Log("msg", "matched trigger, executing actions")
+		logger := log.With(logger, "trigger", t.ID)
 		// Must be a match
-		for _, a := range t.ParsedActions {
-			err := a.Execute(logger, pl)
-			if err != nil {
-				return err
+		if t.limit != nil && c.Limiter != nil {
+			key := t.limit.stateKey(t.ID, doc)
+			if t.limit.debounce > 0 {
+				logger.Log("msg", "matched trigger, debouncing actions", "debounce", t.limit.debounce)
+				t, clock, limiter := t, c.clock(), c.Limiter
+				limiter.debounce(key, t.limit, clock, func() {
+					now := clock.Now()
+					if !limiter.allow(key, t.limit, now) {
+						logger.Log("msg", "debounced trigger is limited, skipping actions")
+						return
+					}
+					logger.Log("msg", "debounce period elapsed")
+					c.fire(logger, t, act, doc, now)
+				})
+				continue
+			}
+			if !c.Limiter.allow(key, t.limit, at) {
+				logger.Log("msg", "matched trigger, but it is limited; skipping actions")
+				continue
 			}
 		}
+		c.fire(logger, t, act, doc, at)
 	}
 	if !m {
 		logger.Log("msg", "received hook, but did not match any configured triggers")
@@ -76,55 +252,147 @@ func (c Config) Handle(logger log.Logger, pl WebhookPayload, raw []byte) error {
 	return nil
 }
 
+// fire executes the actions of a matched trigger, or arms its timer if it is a correlation trigger
+func (c Config) fire(logger log.Logger, t Trigger, act Activity, doc interface{}, at time.Time) {
+	if t.correlation != nil {
+		if c.Correlator == nil {
+			logger.Log("msg", "matched correlation trigger, but correlations are not enabled; skipping")
+			return
+		}
+		c.Correlator.arm(logger, t, act, doc, at, c.clock())
+		return
+	}
+	logger.Log("msg", "matched trigger, executing actions")
+	c.Executor.execute(logger, t, newEvent(t, act, doc))
+}
+
+func (c Config) clock() Clock {
+	if c.Clock == nil {
+		return SystemClock
+	}
+	return c.Clock
+}
+
 // Trigger is a configuration for tying a specific Plex webhook to a set of desired actions
 type Trigger struct {
-	Properties map[string]interface{} `json:"properties"`
-	RawActions []RawAction `json:"actions"`
-	ParsedActions []Action `json:"-"`
+	ID            string                 `json:"id,omitempty"`
+	Name          string                 `json:"name,omitempty"`
+	Description   string                 `json:"description,omitempty"`
+	Tags          []string               `json:"tags,omitempty"`
+	Enabled       *bool                  `json:"enabled,omitempty"`
+	Properties    map[string]interface{} `json:"properties"`
+	All           []Condition            `json:"all,omitempty"`
+	Any           []Condition            `json:"any,omitempty"`
+	Not           *Condition             `json:"not,omitempty"`
+	When          *When                  `json:"when,omitempty"`
+	Limit         *Limit                 `json:"limit,omitempty"`
+	Correlation   *Correlation           `json:"correlation,omitempty"`
+	Tests         []TriggerTest          `json:"tests,omitempty"`
+	RawActions    []RawAction            `json:"actions"`
+	ParsedActions []Action               `json:"-"`
+
+	matcher     *matcher
+	schedule    *schedule
+	limit       *limitPolicy
+	correlation *correlationPolicy
+	actions     []actionSpec
+	derivedID   bool
+}
+
+// configuredEnabled reports the Trigger's enabled flag from the config.  Triggers are enabled unless stated otherwise.
+func (t Trigger) configuredEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
 }
 
+// actionSpec returns the settings of the i'th parsed action.  Actions not loaded through NewConfig are not retried.
+func (t Trigger) actionSpec(i int) actionSpec {
+	if i < len(t.actions) {
+		return t.actions[i]
+	}
+	return actionSpec{typ: fmt.Sprintf("%T", t.ParsedActions[i]), retry: noRetry}
+}
+
+// fallbackID identifies the i'th Trigger of a config when it has no ID of its own, by its position and name
+func (t Trigger) fallbackID(i int) string {
+	id := "trigger-" + strconv.Itoa(i)
+	if name := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(t.Name), "-"), "-"); name != "" {
+		id += "-" + name
+	}
+	return id
+}
+
+// requiresID determines if the Trigger keeps persisted state referring to it by ID: correlation timers, delayed jobs
+// or dead letters of retried actions
+func (t Trigger) requiresID() bool {
+	if t.correlation != nil {
+		return true
+	}
+	for _, spec := range t.actions {
+		if spec.delay > 0 || spec.retry.attempts > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// nonSlug matches the runs of characters replaced with a dash in fallback trigger IDs
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
 // IsMatch determines if the Trigger matches the given webhook payload
 func (t Trigger) IsMatch(payload []byte) bool {
-	cnt, err := gabs.ParseJSON(payload)
+	doc, err := ParseDocument(payload)
 	if err != nil {
 		return false
 	}
-	// Iterate properties and desired values
-	for k, v := range t.Properties {
-		// If we encounter a non-match, short-circuit and return false
-		if cnt.Path(k).Data() != v {
+	return t.Matches(doc)
+}
+
+// Matches determines if the Trigger matches the given parsed payload document.  Triggers loaded through NewConfig
+// are compiled ahead of time; others are compiled on every call.
+func (t Trigger) Matches(doc interface{}) bool {
+	m := t.matcher
+	if m == nil {
+		var err error
+		if m, err = t.condition().compile(); err != nil {
 			return false
 		}
 	}
-	// If we get here, must be a match
-	return true
+	return m.match(doc)
 }
 
-// RawAction is the definition of a thing that should occur when a Trigger matches a Plex webhook
-type RawAction struct {
-	Type string `json:"type"`
-	Config map[string]interface{} `json:"config"`
+// ActiveAt determines if the given time satisfies the Trigger's When block.  Triggers without one are always active.
+// Triggers not loaded through NewConfig have no Location, so sun relative times never match.
+func (t Trigger) ActiveAt(at time.Time) bool {
+	if t.When == nil {
+		return true
+	}
+	s := t.schedule
+	if s == nil {
+		var err error
+		if s, err = t.When.compile(nil); err != nil {
+			return false
+		}
+	}
+	return s.active(at)
 }
 
-// Action represents a type of thing to be done for a webhook payload
-type Action interface {
-	Execute(logger log.Logger, payload WebhookPayload) error
+// condition returns the root of the Trigger's condition tree
+func (t Trigger) condition() Condition {
+	return Condition{
+		Properties: t.Properties,
+		All:        t.All,
+		Any:        t.Any,
+		Not:        t.Not,
+	}
 }
 
-type WebhookAction struct {
-	URL string
-	Action string
+// RawAction is the definition of a thing that should occur when a Trigger matches a Plex webhook.  An action with a
+// Delay runs that long after the trigger matched, unless an event it CancelOn arrives first (see Scheduler).
+type RawAction struct {
+	Type     string                 `json:"type"`
+	Config   map[string]interface{} `json:"config"`
+	Retry    *Retry                 `json:"retry,omitempty"`
+	Timeout  string                 `json:"timeout,omitempty"`
+	Delay    string                 `json:"delay,omitempty"`
+	CancelOn *CancelOn              `json:"cancelOn,omitempty"`
 }
-
-func (w WebhookAction) Execute(logger log.Logger, payload WebhookPayload) error {
-	// Simple, make a web request to the desired URL
-	c := &http.Client{}
-	req, err := http.NewRequest(w.Action, w.URL, nil)
-	if err != nil {
-		return err
-	}
-	logger.Log("action", "webhook", "msg", "firing webhook", "verb", w.Action, "url", w.URL)
-	// Don't care about response for now
-	_, err = c.Do(req)
-	return err
-}
\ No newline at end of file
@@ -1,16 +1,21 @@
 package plex
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
+
+	"github.com/go-kit/kit/log"
 )
 
 func TestTriggerIsMatch(t *testing.T) {
 	// Create Trigger
 	tr := Trigger{
 		Properties: map[string]interface{}{
-			"PropertyA": "1234",
+			"PropertyA":     "1234",
 			"Deep.Property": "5678",
-			"SomeNumeric": float64(1),
+			"SomeNumeric":   float64(1),
 		},
 	}
 	// Create JSON that should match it
@@ -40,4 +45,297 @@ func TestTriggerIsMatch(t *testing.T) {
 	if tr.IsMatch(payload) {
 		t.Errorf("Expected trigger to NOT match payload, but it did!")
 	}
-}
\ No newline at end of file
+}
+
+// TestTriggerIsMatchPaths documents the semantics of array and wildcard property paths.  A path that can pick more
+// than one value matches when any value satisfies the condition, except for $ne, $nin and $exists: false, which
+// every value must satisfy.
+func TestTriggerIsMatchPaths(t *testing.T) {
+	payload := []byte(`{
+		"event": "media.play",
+		"Metadata": {
+			"title": "Alien",
+			"Genre": [
+				{"id": 1, "tag": "Horror"},
+				{"id": 2, "tag": "Science Fiction"}
+			],
+			"Role": [
+				{"tag": "Sigourney Weaver", "role": "Ripley"},
+				{"tag": "Tom Skerritt", "role": "Dallas"}
+			],
+			"Guid": [
+				{"id": "imdb://tt0078748"},
+				{"id": "tmdb://348"}
+			],
+			"Ratings": [[1, 2], [3, 4]]
+		}
+	}`)
+	tests := []struct {
+		name     string
+		property string
+		cond     interface{}
+		match    bool
+	}{
+		{"any element", "Metadata.Genre[].tag", "Horror", true},
+		{"any element star", "Metadata.Genre[*].tag", "Science Fiction", true},
+		{"any element mismatch", "Metadata.Genre[].tag", "Comedy", false},
+		{"implicit any element", "Metadata.Genre.tag", "Horror", true},
+		{"index", "Metadata.Genre[0].tag", "Horror", true},
+		{"index mismatch", "Metadata.Genre[1].tag", "Horror", false},
+		{"negative index", "Metadata.Genre[-1].tag", "Science Fiction", true},
+		{"index out of range", "Metadata.Genre[5].tag", map[string]interface{}{"$exists": false}, true},
+		{"nested arrays", "Metadata.Ratings[][]", float64(4), true},
+		{"nested index", "Metadata.Ratings[1][0]", float64(3), true},
+		{"any element operator", "Metadata.Guid[].id", map[string]interface{}{"$prefix": "imdb://"}, true},
+		{"any element $in", "Metadata.Role[].role", map[string]interface{}{"$in": []interface{}{"Ripley", "Ash"}}, true},
+		{"$ne requires every element", "Metadata.Genre[].tag", map[string]interface{}{"$ne": "Horror"}, false},
+		{"$ne every element", "Metadata.Genre[].tag", map[string]interface{}{"$ne": "Comedy"}, true},
+		{"$nin requires every element", "Metadata.Genre[].tag", map[string]interface{}{"$nin": []interface{}{"Horror"}}, false},
+		{"$exists any element", "Metadata.Role[].role", map[string]interface{}{"$exists": true}, true},
+		{"key wildcard", "Metadata.*[].tag", "Sigourney Weaver", true},
+		{"key wildcard scalar", "Metadata.*", "Alien", true},
+		{"key wildcard mismatch", "Metadata.*[].tag", "Ian Holm", false},
+		{"whole array", "Metadata.Genre", map[string]interface{}{"$exists": true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := Trigger{
+				Properties: map[string]interface{}{
+					tt.property: tt.cond,
+				},
+			}
+			if m := tr.IsMatch(payload); m != tt.match {
+				t.Errorf("Expected IsMatch to be %v, got %v", tt.match, m)
+			}
+		})
+	}
+}
+
+func TestTriggerIsMatchOperators(t *testing.T) {
+	payload := []byte(`{
+		"event": "media.play",
+		"Player": {
+			"title": "Living Room TV",
+			"local": true
+		},
+		"Metadata": {
+			"librarySectionType": "movie",
+			"index": 4,
+			"ratingKey": "1234"
+		}
+	}`)
+	tests := []struct {
+		name     string
+		property string
+		cond     interface{}
+		match    bool
+	}{
+		{"literal", "event", "media.play", true},
+		{"literal mismatch", "event", "media.stop", false},
+		{"literal string vs number", "Metadata.index", "4", true},
+		{"literal number vs string", "Metadata.ratingKey", float64(1234), true},
+		{"literal bool vs string", "Player.local", "true", true},
+		{"$eq", "event", map[string]interface{}{"$eq": "media.play"}, true},
+		{"$eq mismatch", "event", map[string]interface{}{"$eq": "media.pause"}, false},
+		{"$ne", "event", map[string]interface{}{"$ne": "media.stop"}, true},
+		{"$ne mismatch", "event", map[string]interface{}{"$ne": "media.play"}, false},
+		{"$ne missing", "Missing.path", map[string]interface{}{"$ne": "x"}, true},
+		{"$in", "Metadata.librarySectionType", map[string]interface{}{"$in": []interface{}{"movie", "show"}}, true},
+		{"$in mismatch", "Metadata.librarySectionType", map[string]interface{}{"$in": []interface{}{"artist", "show"}}, false},
+		{"$in coerced", "Metadata.index", map[string]interface{}{"$in": []interface{}{"3", "4"}}, true},
+		{"$nin", "Metadata.librarySectionType", map[string]interface{}{"$nin": []interface{}{"artist", "show"}}, true},
+		{"$nin mismatch", "Metadata.librarySectionType", map[string]interface{}{"$nin": []interface{}{"movie"}}, false},
+		{"$gt", "Metadata.index", map[string]interface{}{"$gt": float64(3)}, true},
+		{"$gt equal", "Metadata.index", map[string]interface{}{"$gt": float64(4)}, false},
+		{"$gt coerced", "Metadata.ratingKey", map[string]interface{}{"$gt": float64(1000)}, true},
+		{"$gte", "Metadata.index", map[string]interface{}{"$gte": float64(4)}, true},
+		{"$gte mismatch", "Metadata.index", map[string]interface{}{"$gte": float64(5)}, false},
+		{"$lt", "Metadata.index", map[string]interface{}{"$lt": "5"}, true},
+		{"$lt mismatch", "Metadata.index", map[string]interface{}{"$lt": float64(4)}, false},
+		{"$lte", "Metadata.index", map[string]interface{}{"$lte": float64(4)}, true},
+		{"$lte mismatch", "Metadata.index", map[string]interface{}{"$lte": float64(3)}, false},
+		{"$lt missing", "Missing.path", map[string]interface{}{"$lt": float64(3)}, false},
+		{"$gt and $lt", "Metadata.index", map[string]interface{}{"$gt": float64(3), "$lt": float64(5)}, true},
+		{"$regex", "Player.title", map[string]interface{}{"$regex": "Living"}, true},
+		{"$regex case sensitive", "Player.title", map[string]interface{}{"$regex": "living"}, false},
+		{"$regex go flags", "Player.title", map[string]interface{}{"$regex": "(?i)^living"}, true},
+		{"$regex slash flags", "Player.title", map[string]interface{}{"$regex": "/living/i"}, true},
+		{"$regex number", "Metadata.index", map[string]interface{}{"$regex": "^[0-9]$"}, true},
+		{"$prefix", "event", map[string]interface{}{"$prefix": "media."}, true},
+		{"$prefix mismatch", "event", map[string]interface{}{"$prefix": "library."}, false},
+		{"$suffix", "event", map[string]interface{}{"$suffix": ".play"}, true},
+		{"$suffix mismatch", "event", map[string]interface{}{"$suffix": ".stop"}, false},
+		{"$contains", "Player.title", map[string]interface{}{"$contains": "Room"}, true},
+		{"$contains mismatch", "Player.title", map[string]interface{}{"$contains": "Den"}, false},
+		{"$exists", "Player.title", map[string]interface{}{"$exists": true}, true},
+		{"$exists mismatch", "Player.uuid", map[string]interface{}{"$exists": true}, false},
+		{"$exists false", "Player.uuid", map[string]interface{}{"$exists": false}, true},
+		{"$exists false mismatch", "Player.title", map[string]interface{}{"$exists": false}, false},
+		{"unknown operator", "event", map[string]interface{}{"$bogus": "media.play"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := Trigger{
+				Properties: map[string]interface{}{
+					tt.property: tt.cond,
+				},
+			}
+			if m := tr.IsMatch(payload); m != tt.match {
+				t.Errorf("Expected IsMatch to be %v, got %v", tt.match, m)
+			}
+		})
+	}
+}
+
+func TestNewConfigInvalidCondition(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+	}{
+		{"unknown operator", `{"triggers": [{"properties": {"event": {"$bogus": 1}}}]}`},
+		{"bad regex", `{"triggers": [{"properties": {"event": {"$regex": "("}}}]}`},
+		{"$in without array", `{"triggers": [{"properties": {"event": {"$in": "media.play"}}}]}`},
+		{"$exists without bool", `{"triggers": [{"properties": {"event": {"$exists": "yes"}}}]}`},
+		{"bad array selector", `{"triggers": [{"properties": {"Metadata.Genre[x].tag": "Horror"}}]}`},
+		{"unterminated array selector", `{"triggers": [{"properties": {"Metadata.Genre[.tag": "Horror"}}]}`},
+		{"nested unknown operator", `{"triggers": [{"any": [{"all": [{"event": {"$bogus": 1}}]}]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewConfig(strings.NewReader(tt.cfg)); err == nil {
+				t.Errorf("Expected an error loading config, got none")
+			}
+		})
+	}
+}
+
+func TestTriggerIsMatchComposition(t *testing.T) {
+	cfg, err := NewConfig(strings.NewReader(`{
+		"triggers": [
+			{
+				"properties": {
+					"Metadata.librarySectionType": "movie"
+				},
+				"any": [
+					{"event": "media.play"},
+					{"event": "media.resume"}
+				],
+				"all": [
+					{
+						"any": [
+							{"Player.title": "Living Room"},
+							{"properties": {"Player.title": "Den"}}
+						]
+					}
+				],
+				"not": {"Player.local": false},
+				"actions": []
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	tr := cfg.Triggers[0]
+
+	tests := []struct {
+		name    string
+		payload string
+		match   bool
+	}{
+		{"play in living room", `{"event": "media.play", "Player": {"title": "Living Room", "local": true}, "Metadata": {"librarySectionType": "movie"}}`, true},
+		{"resume in den", `{"event": "media.resume", "Player": {"title": "Den", "local": true}, "Metadata": {"librarySectionType": "movie"}}`, true},
+		{"stop in den", `{"event": "media.stop", "Player": {"title": "Den", "local": true}, "Metadata": {"librarySectionType": "movie"}}`, false},
+		{"play in kitchen", `{"event": "media.play", "Player": {"title": "Kitchen", "local": true}, "Metadata": {"librarySectionType": "movie"}}`, false},
+		{"play remotely", `{"event": "media.play", "Player": {"title": "Den", "local": false}, "Metadata": {"librarySectionType": "movie"}}`, false},
+		{"play a show", `{"event": "media.play", "Player": {"title": "Den", "local": true}, "Metadata": {"librarySectionType": "show"}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m := tr.IsMatch([]byte(tt.payload)); m != tt.match {
+				t.Errorf("Expected IsMatch to be %v, got %v", tt.match, m)
+			}
+		})
+	}
+}
+
+// benchmarkConfig builds a config with n triggers, none of which match benchmarkPayload
+func benchmarkConfig(b *testing.B, n int) Config {
+	triggers := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		triggers = append(triggers, fmt.Sprintf(`{
+			"properties": {
+				"event": {"$in": ["media.play", "media.resume"]},
+				"Player.title": {"$regex": "(?i)^player %d$"},
+				"Metadata.index": {"$gt": 3}
+			},
+			"any": [
+				{"Metadata.librarySectionType": "movie"},
+				{"Metadata.librarySectionType": "show"}
+			],
+			"actions": []
+		}`, i))
+	}
+	cfg, err := NewConfig(strings.NewReader(`{"triggers": [` + strings.Join(triggers, ",") + `]}`))
+	if err != nil {
+		b.Fatalf("Unexpected error loading config: %v", err)
+	}
+	return cfg
+}
+
+var benchmarkPayload = []byte(`{
+	"event": "media.play",
+	"user": true,
+	"owner": true,
+	"Account": {"id": 1, "thumb": "https://plex.tv/users/1/avatar", "title": "someone"},
+	"Server": {"title": "server", "uuid": "54664a3d8acc39983675640ec9ce00b70af9cc36"},
+	"Player": {"local": true, "publicAddress": "10.0.0.1", "title": "Living Room", "uuid": "abc123"},
+	"Metadata": {
+		"librarySectionType": "show",
+		"ratingKey": "1936545",
+		"key": "/library/metadata/1936545",
+		"type": "episode",
+		"title": "An Episode",
+		"grandparentTitle": "A Show",
+		"index": 4,
+		"parentIndex": 2
+	}
+}`)
+
+func BenchmarkConfigHandle(b *testing.B) {
+	logger := log.NewNopLogger()
+	act := Activity{}
+	if err := json.Unmarshal(benchmarkPayload, &act.Payload); err != nil {
+		b.Fatal(err)
+	}
+	for _, n := range []int{10, 100, 500} {
+		cfg := benchmarkConfig(b, n)
+		b.Run(fmt.Sprintf("triggers=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				doc, err := ParseDocument(benchmarkPayload)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := cfg.Handle(logger, act, doc); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkTriggerIsMatch measures matching the raw payload against every trigger, parsing it each time
+func BenchmarkTriggerIsMatch(b *testing.B) {
+	for _, n := range []int{10, 100, 500} {
+		cfg := benchmarkConfig(b, n)
+		b.Run(fmt.Sprintf("triggers=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, t := range cfg.Triggers {
+					t.IsMatch(benchmarkPayload)
+				}
+			}
+		})
+	}
+}
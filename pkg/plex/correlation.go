@@ -0,0 +1,233 @@
+package plex
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Correlation turns a Trigger into an absence trigger.  When the trigger matches, a timer is armed instead of
+// running the actions.  If an event matching any of the Cancel conditions arrives with the same values at the Key
+// paths (e.g. from the same Player.uuid) before the Timeout passes, the timer is cancelled; otherwise the actions run
+// with the event that armed it.  Matching the trigger again while armed restarts the timer.
+type Correlation struct {
+	Key     []string    `json:"key,omitempty"`
+	Timeout string      `json:"timeout"`
+	Cancel  []Condition `json:"cancel,omitempty"`
+}
+
+// correlationPolicy is a compiled Correlation
+type correlationPolicy struct {
+	key     []propertyPath
+	timeout time.Duration
+	cancel  []*matcher
+}
+
+func (c Correlation) compile() (*correlationPolicy, error) {
+	p := correlationPolicy{}
+	var err error
+	if p.key, err = parsePaths(c.Key); err != nil {
+		return nil, err
+	}
+	if p.timeout, err = parsePositiveDuration("timeout", c.Timeout); err != nil {
+		return nil, err
+	}
+	if p.timeout == 0 {
+		return nil, fmt.Errorf("correlation must specify a timeout")
+	}
+	for _, cc := range c.Cancel {
+		m, err := cc.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid cancel condition: %v", err)
+		}
+		p.cancel = append(p.cancel, m)
+	}
+	return &p, nil
+}
+
+// cancels determines if the given payload document cancels the correlation
+func (p *correlationPolicy) cancels(doc interface{}) bool {
+	for _, m := range p.cancel {
+		if m.match(doc) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pending is an armed correlation timer.  Pending timers are persisted in the Store so that a restart does not lose
+// them.
+type Pending struct {
+	ID        string      `json:"id"`
+	Trigger   string      `json:"trigger"`
+	Key       string      `json:"key"`
+	ArmedAt   time.Time   `json:"armedAt"`
+	ExpiresAt time.Time   `json:"expiresAt"`
+	Activity  Activity    `json:"activity"`
+	Document  interface{} `json:"document"`
+}
+
+// Correlator holds the armed timers of correlation triggers.  It is safe for concurrent use.
+type Correlator struct {
+	mu      sync.Mutex
+	store   *Store
+	pending map[string]*pendingTimer
+
+	// triggers resolves a trigger ID to the trigger currently configured with it, so that a timer armed before a
+	// config reload runs the reloaded actions.  When nil, the trigger that armed the timer is used.
+	triggers func(id string) (Trigger, bool)
+
+	// executor runs the actions of expired timers
+	executor *Executor
+
+	// toggles decides if the trigger of an expired timer is still enabled
+	toggles *Toggles
+}
+
+type pendingTimer struct {
+	Pending
+	trigger Trigger
+	timer   Timer
+}
+
+// NewCorrelator creates a Correlator persisting its timers to the given Store.  store may be nil, in which case
+// timers are only held in memory.
+func NewCorrelator(store *Store) *Correlator {
+	return &Correlator{
+		store:   store,
+		pending: map[string]*pendingTimer{},
+	}
+}
+
+// Pending returns the currently armed timers, soonest first
+func (c *Correlator) Pending() []Pending {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ps := make([]Pending, 0, len(c.pending))
+	for _, p := range c.pending {
+		ps = append(ps, p.Pending)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].ExpiresAt.Before(ps[j].ExpiresAt) })
+	return ps
+}
+
+// arm starts (or restarts) the correlation timer for the given event
+func (c *Correlator) arm(logger log.Logger, t Trigger, act Activity, doc interface{}, at time.Time, clock Clock) {
+	key := docKey(t.correlation.key, doc)
+	p := Pending{
+		ID:        pendingID(t.ID, key),
+		Trigger:   t.ID,
+		Key:       key,
+		ArmedAt:   at,
+		ExpiresAt: at.Add(t.correlation.timeout),
+		Activity:  act,
+		Document:  doc,
+	}
+	logger.Log("msg", "matched correlation trigger, arming timer", "key", key, "expires", p.ExpiresAt)
+	c.schedule(logger, p, t, clock)
+}
+
+// cancel stops the correlation timer for the given event, if one is armed
+func (c *Correlator) cancel(logger log.Logger, t Trigger, doc interface{}) {
+	key := docKey(t.correlation.key, doc)
+	id := pendingID(t.ID, key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[id]
+	if !ok {
+		return
+	}
+	p.timer.Stop()
+	delete(c.pending, id)
+	c.forget(logger, id)
+	logger.Log("msg", "correlation cancelled by follow-up event", "key", key)
+}
+
+// restore re-arms timers persisted by a previous run.  Timers that expired while plexus was down fire immediately.
+func (c *Correlator) restore(logger log.Logger, clock Clock) error {
+	if c.store == nil {
+		return nil
+	}
+	ps, err := c.store.GetAllPending()
+	if err != nil {
+		return err
+	}
+	for _, p := range ps {
+		c.schedule(log.With(logger, "trigger", p.Trigger), p, Trigger{}, clock)
+	}
+	if len(ps) > 0 {
+		logger.Log("msg", "restored pending correlation timers", "count", len(ps))
+	}
+	return nil
+}
+
+func (c *Correlator) schedule(logger log.Logger, p Pending, t Trigger, clock Clock) {
+	pt := &pendingTimer{Pending: p, trigger: t}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.store != nil {
+		if err := c.store.AddPending(p); err != nil {
+			logger.Log("msg", "could not persist correlation timer", "err", err)
+		}
+	}
+	if old, ok := c.pending[p.ID]; ok {
+		old.timer.Stop()
+	}
+	d := p.ExpiresAt.Sub(clock.Now())
+	if d < 0 {
+		d = 0
+	}
+	pt.timer = clock.AfterFunc(d, func() {
+		c.expire(logger, pt)
+	})
+	c.pending[p.ID] = pt
+}
+
+// expire runs the actions of a correlation whose timer ran out
+func (c *Correlator) expire(logger log.Logger, pt *pendingTimer) {
+	c.mu.Lock()
+	if c.pending[pt.ID] != pt {
+		// Restarted or cancelled in the meantime
+		c.mu.Unlock()
+		return
+	}
+	delete(c.pending, pt.ID)
+	c.forget(logger, pt.ID)
+	c.mu.Unlock()
+
+	t, ok := pt.trigger, pt.trigger.ID != ""
+	if c.triggers != nil {
+		t, ok = c.triggers(pt.Trigger)
+	}
+	if !ok {
+		logger.Log("msg", "correlation timer expired, but its trigger is no longer configured", "trigger", pt.Trigger)
+		return
+	}
+	if !c.toggles.enabled(t) {
+		logger.Log("msg", "correlation timer expired, but its trigger is disabled", "key", pt.Key)
+		return
+	}
+	logger.Log("msg", "correlation timer expired, executing actions", "key", pt.Key)
+	c.executor.execute(logger, t, newEvent(t, pt.Activity, pt.Document))
+}
+
+// forget removes a persisted timer.  The caller must hold c.mu.
+func (c *Correlator) forget(logger log.Logger, id string) {
+	if c.store == nil {
+		return
+	}
+	if err := c.store.DeletePending(id); err != nil {
+		logger.Log("msg", "could not remove persisted correlation timer", "err", err)
+	}
+}
+
+// pendingID derives a stable, filesystem safe ID for the timer of a trigger and correlation key
+func pendingID(trigger, key string) string {
+	sum := sha1.Sum([]byte(trigger + "|" + key))
+	return hex.EncodeToString(sum[:])
+}
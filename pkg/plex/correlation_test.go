@@ -0,0 +1,169 @@
+package plex
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+const correlationConfig = `{
+	"triggers": [
+		{
+			"id": "paused",
+			"properties": {"event": "media.pause"},
+			"correlation": {
+				"key": ["Player.uuid"],
+				"timeout": "5m",
+				"cancel": [
+					{"event": {"$in": ["media.resume", "media.stop"]}}
+				]
+			},
+			"actions": []
+		}
+	]
+}`
+
+// correlatedEngine loads correlationConfig into an Engine backed by store, recording action executions
+func correlatedEngine(t *testing.T, store *Store, clock Clock, payloads *[]WebhookPayload) *Engine {
+	cfg, err := NewConfig(strings.NewReader(correlationConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	cfg.Clock = clock
+	cfg.Triggers[0].ParsedActions = []Action{recordingAction{payloads: payloads}}
+	return NewEngine(cfg, store)
+}
+
+// send passes an event from the given player through the engine
+func send(t *testing.T, e *Engine, clock Clock, event, player string) {
+	raw := []byte(`{"event": "` + event + `", "Player": {"uuid": "` + player + `"}}`)
+	doc, err := ParseDocument(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	act := Activity{ReceivedAt: clock.Now()}
+	act.Payload.Event = event
+	act.Payload.Player.UUID = player
+	if err := e.Handle(log.NewNopLogger(), act, doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCorrelation(t *testing.T) {
+	var payloads []WebhookPayload
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	e := correlatedEngine(t, nil, clock, &payloads)
+
+	// Paused and resumed in time
+	send(t, e, clock, "media.pause", "a")
+	clock.Advance(time.Minute)
+	send(t, e, clock, "media.resume", "a")
+	clock.Advance(10 * time.Minute)
+	if len(payloads) != 0 {
+		t.Fatalf("Expected the resume to cancel the timer, got %d executions", len(payloads))
+	}
+
+	// Paused, and another player resumes
+	send(t, e, clock, "media.pause", "a")
+	clock.Advance(time.Minute)
+	send(t, e, clock, "media.resume", "b")
+	if len(e.Pending()) != 1 {
+		t.Fatalf("Expected 1 pending timer, got %d", len(e.Pending()))
+	}
+	clock.Advance(4 * time.Minute)
+	if len(payloads) != 1 || payloads[0].Player.UUID != "a" {
+		t.Fatalf("Expected the timer to expire for player a, got %+v", payloads)
+	}
+	if len(e.Pending()) != 0 {
+		t.Fatalf("Expected no pending timers, got %d", len(e.Pending()))
+	}
+
+	// Paused again while armed restarts the timer
+	send(t, e, clock, "media.pause", "a")
+	clock.Advance(4 * time.Minute)
+	send(t, e, clock, "media.pause", "a")
+	clock.Advance(4 * time.Minute)
+	if len(payloads) != 1 {
+		t.Fatalf("Expected the second pause to restart the timer, got %d executions", len(payloads))
+	}
+	clock.Advance(time.Minute)
+	if len(payloads) != 2 {
+		t.Fatalf("Expected the restarted timer to expire, got %d executions", len(payloads))
+	}
+}
+
+func TestCorrelationDisabled(t *testing.T) {
+	var payloads []WebhookPayload
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	e := correlatedEngine(t, nil, clock, &payloads)
+
+	// Disabled while armed
+	send(t, e, clock, "media.pause", "a")
+	if err := e.SetTriggerEnabled("paused", false); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(10 * time.Minute)
+	if len(payloads) != 0 {
+		t.Fatalf("Expected the disabled trigger not to run, got %d executions", len(payloads))
+	}
+	if len(e.Pending()) != 0 {
+		t.Fatalf("Expected no pending timers, got %d", len(e.Pending()))
+	}
+}
+
+func TestCorrelationRestore(t *testing.T) {
+	var payloads []WebhookPayload
+	store, cleanup := tempStore(t)
+	defer cleanup()
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	e := correlatedEngine(t, store, clock, &payloads)
+	send(t, e, clock, "media.pause", "a")
+	send(t, e, clock, "media.pause", "b")
+	send(t, e, clock, "media.stop", "b")
+
+	ps, err := store.GetAllPending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 || ps[0].Activity.Payload.Player.UUID != "a" {
+		t.Fatalf("Expected the timer for player a to be persisted, got %+v", ps)
+	}
+
+	// Restart with a fresh clock and engine
+	clock = newFakeClock(clock.Now().Add(2 * time.Minute))
+	e = correlatedEngine(t, store, clock, &payloads)
+	if err := e.Restore(log.NewNopLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Pending()) != 1 {
+		t.Fatalf("Expected 1 restored timer, got %d", len(e.Pending()))
+	}
+	clock.Advance(3 * time.Minute)
+	if len(payloads) != 1 || payloads[0].Player.UUID != "a" {
+		t.Fatalf("Expected the restored timer to expire for player a, got %+v", payloads)
+	}
+	if ps, _ := store.GetAllPending(); len(ps) != 0 {
+		t.Fatalf("Expected the expired timer to be removed from the store, got %+v", ps)
+	}
+}
+
+func TestNewConfigInvalidCorrelation(t *testing.T) {
+	tests := []struct {
+		name        string
+		correlation string
+	}{
+		{"no timeout", `{"key": ["Player.uuid"]}`},
+		{"bad timeout", `{"timeout": "later"}`},
+		{"bad cancel", `{"timeout": "5m", "cancel": [{"event": {"$bogus": 1}}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewConfig(strings.NewReader(`{"triggers": [{"properties": {}, "correlation": ` + tt.correlation + `}]}`))
+			if err == nil {
+				t.Errorf("Expected an error loading config, got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+func init() {
+	RegisterAction("email", newEmailAction)
+}
+
+// SMTPRelay is the mail server email actions send through.  Address is host:port.  Security is "starttls" (the
+// default), which requires the server to support STARTTLS, "tls" for implicit TLS (typically on port 465) or "none".
+// Username and Password authenticate with AUTH PLAIN, which is refused over an unencrypted connection to anything but
+// localhost.  From is the default sender.
+type SMTPRelay struct {
+	Address  string     `json:"address"`
+	Security string     `json:"security,omitempty"`
+	Username string     `json:"username,omitempty"`
+	Password string     `json:"password,omitempty"`
+	From     string     `json:"from,omitempty"`
+	TLS      *TLSConfig `json:"tls,omitempty"`
+
+	host string
+	tls  *tls.Config
+}
+
+// compile validates the relay's settings
+func (r *SMTPRelay) compile() error {
+	host, _, err := net.SplitHostPort(r.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", r.Address, err)
+	}
+	r.host = host
+	switch r.Security {
+	case "":
+		r.Security = "starttls"
+	case "starttls", "tls":
+	case "none":
+		if r.TLS != nil {
+			return fmt.Errorf("tls settings require starttls or tls security")
+		}
+	default:
+		return fmt.Errorf("security must be starttls, tls or none")
+	}
+	if r.tls, err = r.TLS.config(host); err != nil {
+		return fmt.Errorf("invalid tls settings: %v", err)
+	}
+	if r.From != "" {
+		if _, err := mail.ParseAddress(r.From); err != nil {
+			return fmt.Errorf("invalid from %q: %v", r.From, err)
+		}
+	}
+	return nil
+}
+
+// send delivers msg to the recipients, giving up when ctx is done
+func (r *SMTPRelay) send(ctx context.Context, from string, to []string, msg []byte) error {
+	var d net.Dialer
+	raw, err := d.DialContext(ctx, "tcp", r.Address)
+	if err != nil {
+		return err
+	}
+	// The SMTP client does not take a context, so close the connection when ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			raw.Close()
+		case <-done:
+		}
+	}()
+	conn := raw
+	if r.Security == "tls" {
+		conn = tls.Client(raw, r.tls)
+	}
+	c, err := smtp.NewClient(conn, r.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if r.Security == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s does not support STARTTLS", r.Address)
+		}
+		if err := c.StartTLS(r.tls); err != nil {
+			return err
+		}
+	}
+	if r.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", r.Username, r.Password, r.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// EmailAction sends an email about the event through the config's SMTP relay, as a multipart message with a plain
+// text and an HTML version.  Subject, Text and HTML are templates rendered over the event (see templateData); Subject
+// and Text default to the messages of chat actions (see ChatAction), and HTML to the subject and text, with the thumb
+// below them.  HTML is an html/template, so the values it renders are escaped.
+//
+// Thumb decides what is done with the poster saved with the webhook: "inline" (the default) embeds it in the HTML
+// version, where it can be referred to as cid:thumb; "attach" attaches it; "none" leaves it out.
+type EmailAction struct {
+	From    string   `json:"from,omitempty"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject,omitempty"`
+	Text    string   `json:"text,omitempty"`
+	HTML    string   `json:"html,omitempty"`
+	Thumb   string   `json:"thumb,omitempty"`
+
+	relay   *SMTPRelay
+	from    string
+	sender  string
+	to      []string
+	subject *Template
+	text    *Template
+	html    *Template
+}
+
+// newEmailAction creates an EmailAction from its configuration, compiling its templates
+func newEmailAction(c ActionConfig) (Action, error) {
+	e := EmailAction{}
+	if err := c.Decode(&e); err != nil {
+		return nil, err
+	}
+	e.relay = c.Root.SMTP
+	if e.relay == nil {
+		return nil, fmt.Errorf("invalid email action specified; missing smtp relay in config")
+	}
+	from := e.From
+	if from == "" {
+		from = e.relay.From
+	}
+	if from == "" {
+		return nil, fmt.Errorf("invalid email action specified; missing from")
+	}
+	a, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email action specified; invalid from %q: %v", from, err)
+	}
+	e.from, e.sender = from, a.Address
+	if len(e.To) == 0 {
+		return nil, fmt.Errorf("invalid email action specified; missing to")
+	}
+	for _, to := range e.To {
+		a, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid email action specified; invalid to %q: %v", to, err)
+		}
+		e.to = append(e.to, a.Address)
+	}
+	switch e.Thumb {
+	case "":
+		e.Thumb = "inline"
+	case "inline", "attach", "none":
+	default:
+		return nil, fmt.Errorf("invalid email action specified; thumb must be inline, attach or none")
+	}
+	err = compileTemplates("email",
+		templateField{"subject", e.Subject, &e.subject},
+		templateField{"text", e.Text, &e.text})
+	if err != nil {
+		return nil, err
+	}
+	if e.HTML != "" {
+		if e.html, err = compileHTMLTemplate("html", e.HTML); err != nil {
+			return nil, fmt.Errorf("invalid email html template: %v", err)
+		}
+	}
+	return e, nil
+}
+
+// Execute renders the message and sends it
+func (e EmailAction) Execute(ctx context.Context, ev Event) error {
+	msg, subject, err := e.message(ev, time.Now())
+	if err != nil {
+		return err
+	}
+	ev.logger().Log("action", "email", "to", strings.Join(e.to, ","), "subject", subject, "relay", e.relay.Address)
+	return e.relay.send(ctx, e.sender, e.to, msg)
+}
+
+// message renders the email for the event, returning it with its subject
+func (e EmailAction) message(ev Event, now time.Time) ([]byte, string, error) {
+	defaults := chatDefaults(ev)
+	subjectTmpl, textTmpl := e.subject, e.text
+	if subjectTmpl == nil {
+		subjectTmpl = defaults[0]
+	}
+	if textTmpl == nil {
+		textTmpl = defaults[1]
+	}
+	subject, err := subjectTmpl.render(ev)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not render email subject: %v", err)
+	}
+	text, err := textTmpl.render(ev)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not render email text: %v", err)
+	}
+	if text == "" {
+		text = subject
+	}
+
+	var thumb []byte
+	tp := ev.Activity.ThumbPath
+	if tp != "" && e.Thumb != "none" {
+		if thumb, err = ioutil.ReadFile(tp); err != nil {
+			return nil, "", fmt.Errorf("could not read thumb: %v", err)
+		}
+	}
+	var body string
+	if e.html != nil {
+		if body, err = e.html.render(ev); err != nil {
+			return nil, "", fmt.Errorf("could not render email html: %v", err)
+		}
+	} else {
+		body = "<p><strong>" + html.EscapeString(subject) + "</strong></p>\r\n"
+		if text != subject {
+			body += "<p>" + strings.Replace(html.EscapeString(text), "\n", "<br>\r\n", -1) + "</p>\r\n"
+		}
+		if thumb != nil && e.Thumb == "inline" {
+			body += `<p><img src="cid:thumb" alt="` + html.EscapeString(subject) + `"></p>` + "\r\n"
+		}
+	}
+
+	alt := bytes.Buffer{}
+	aw := multipart.NewWriter(&alt)
+	for _, p := range []struct{ typ, content string }{{"text/plain", text}, {"text/html", body}} {
+		pw, err := aw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.typ + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := io.WriteString(qw, p.content); err != nil {
+			return nil, "", err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := aw.Close(); err != nil {
+		return nil, "", err
+	}
+	altType := "multipart/alternative; boundary=" + aw.Boundary()
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "From: %s\r\n", e.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@plexus>\r\n", uuid.NewRandom().String())
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	if thumb == nil {
+		fmt.Fprintf(&buf, "Content-Type: %s\r\n\r\n", altType)
+		buf.Write(alt.Bytes())
+		return buf.Bytes(), subject, nil
+	}
+
+	// The alternatives are nested in a multipart/related message with the inlined thumb, or a multipart/mixed one
+	// with the attached thumb
+	mw := multipart.NewWriter(&buf)
+	ct := mime.TypeByExtension(filepath.Ext(tp))
+	if ct == "" {
+		ct = "image/jpeg"
+	}
+	name := "thumb" + filepath.Ext(tp)
+	th := textproto.MIMEHeader{
+		"Content-Type":              {ct},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	if e.Thumb == "inline" {
+		fmt.Fprintf(&buf, "Content-Type: multipart/related; type=\"multipart/alternative\"; boundary=%s\r\n\r\n", mw.Boundary())
+		th.Set("Content-ID", "<thumb>")
+		th.Set("Content-Disposition", `inline; filename="`+name+`"`)
+	} else {
+		fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+		th.Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	}
+	pw, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {altType}})
+	if err != nil {
+		return nil, "", err
+	}
+	pw.Write(alt.Bytes())
+	if pw, err = mw.CreatePart(th); err != nil {
+		return nil, "", err
+	}
+	writeBase64(pw, thumb)
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), subject, nil
+}
+
+// writeBase64 writes b in base64, in lines of 76 characters
+func writeBase64(w io.Writer, b []byte) {
+	s := base64.StdEncoding.EncodeToString(b)
+	for len(s) > 76 {
+		io.WriteString(w, s[:76]+"\r\n")
+		s = s[76:]
+	}
+	io.WriteString(w, s+"\r\n")
+}
@@ -0,0 +1,360 @@
+package plex
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSMTP is an in-process SMTP server recording the mails it receives
+type fakeSMTP struct {
+	ln       net.Listener
+	tls      *tls.Config
+	implicit bool
+	username string
+	password string
+
+	mu    sync.Mutex
+	mails []fakeMail
+}
+
+type fakeMail struct {
+	from string
+	to   []string
+	data []byte
+	tls  bool
+	auth string
+}
+
+// newFakeSMTP starts an SMTP server offering STARTTLS with the given certificates, or implicit TLS, and AUTH PLAIN
+// when username is set
+func newFakeSMTP(t *testing.T, certs []tls.Certificate, implicit bool, username, password string) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, implicit: implicit, username: username, password: password}
+	if certs != nil {
+		s.tls = &tls.Config{Certificates: certs}
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeSMTP) close() {
+	s.ln.Close()
+}
+
+func (s *fakeSMTP) received() []fakeMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fakeMail(nil), s.mails...)
+}
+
+func (s *fakeSMTP) serve(c net.Conn) {
+	defer c.Close()
+	m := fakeMail{}
+	if s.implicit {
+		c = tls.Server(c, s.tls)
+		m.tls = true
+	}
+	tp := textproto.NewConn(c)
+	tp.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		arg := strings.TrimSpace(strings.TrimPrefix(line, strings.SplitN(line, " ", 2)[0]))
+		switch verb {
+		case "EHLO", "HELO":
+			ext := []string{"fake"}
+			if s.tls != nil && !m.tls {
+				ext = append(ext, "STARTTLS")
+			}
+			if s.username != "" {
+				ext = append(ext, "AUTH PLAIN")
+			}
+			for i, e := range ext {
+				sep := "-"
+				if i == len(ext)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, e)
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 go ahead")
+			tc := tls.Server(c, s.tls)
+			if err := tc.Handshake(); err != nil {
+				return
+			}
+			c, m.tls = tc, true
+			tp = textproto.NewConn(c)
+		case "AUTH":
+			b, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			if string(b) != "\x00"+s.username+"\x00"+s.password {
+				tp.PrintfLine("535 authentication failed")
+				continue
+			}
+			m.auth = s.username
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			m.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			m.to = append(m.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			if m.data, err = tp.ReadDotBytes(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.mails = append(s.mails, m)
+			s.mu.Unlock()
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+// testCertificate returns the certificate of httptest's TLS servers, valid for 127.0.0.1, with a file holding it
+// to trust as a CA and a func removing the file
+func testCertificate(t *testing.T) ([]tls.Certificate, string, func()) {
+	srv := httptest.NewTLSServer(nil)
+	srv.Close()
+	ca, err := ioutil.TempFile("", "plexus-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	ca.Close()
+	return srv.TLS.Certificates, ca.Name(), func() { os.Remove(ca.Name()) }
+}
+
+// mailPart is a leaf part of a parsed mail
+type mailPart struct {
+	header textproto.MIMEHeader
+	body   string
+}
+
+// mailParts parses a mail, returning its header and its leaf parts in order, with their transfer encoding decoded
+func mailParts(t *testing.T, data []byte) (mail.Header, []mailPart) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Could not parse mail: %v", err)
+	}
+	var parts []mailPart
+	var walk func(h textproto.MIMEHeader, r io.Reader)
+	walk = func(h textproto.MIMEHeader, r io.Reader) {
+		mt, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+		if err != nil {
+			t.Fatalf("Invalid content type %q: %v", h.Get("Content-Type"), err)
+		}
+		if strings.HasPrefix(mt, "multipart/") {
+			mr := multipart.NewReader(r, params["boundary"])
+			for {
+				p, err := mr.NextPart()
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					t.Fatalf("Could not read part: %v", err)
+				}
+				walk(p.Header, p)
+			}
+		}
+		switch h.Get("Content-Transfer-Encoding") {
+		case "quoted-printable":
+			r = quotedprintable.NewReader(r)
+		case "base64":
+			r = base64.NewDecoder(base64.StdEncoding, r)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Could not read part: %v", err)
+		}
+		parts = append(parts, mailPart{h, string(b)})
+	}
+	walk(textproto.MIMEHeader(msg.Header), bufio.NewReader(msg.Body))
+	return msg.Header, parts
+}
+
+func TestEmailAction(t *testing.T) {
+	certs, ca, cleanup := testCertificate(t)
+	defer cleanup()
+	srv := newFakeSMTP(t, certs, false, "plexus", "s3cret")
+	defer srv.close()
+	st, cleanupStore := tempStore(t)
+	defer cleanupStore()
+
+	cfg, err := NewConfig(strings.NewReader(`{
+		"smtp": {"address": "` + srv.addr() + `", "username": "plexus", "password": "s3cret", "from": "Plexus <plexus@example.com>", "tls": {"ca": "` + ca + `"}},
+		"triggers": [{"properties": {}, "actions": [{"type": "email", "config": {"to": ["Bob <bob@example.com>", "carol@example.com"]}}]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	if err := cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), chatEvent(t, st.dbPath)); err != nil {
+		t.Fatalf("Unexpected error sending email: %v", err)
+	}
+	mails := srv.received()
+	if len(mails) != 1 {
+		t.Fatalf("Expected 1 mail, got %d", len(mails))
+	}
+	m := mails[0]
+	if m.from != "plexus@example.com" || strings.Join(m.to, ",") != "bob@example.com,carol@example.com" || !m.tls || m.auth != "plexus" {
+		t.Errorf("Unexpected envelope %+v", m)
+	}
+	h, parts := mailParts(t, m.data)
+	if got := h.Get("Subject"); got != "Alice started The Expanse S02E03 on Living Room TV" {
+		t.Errorf("Unexpected subject %q", got)
+	}
+	if got := h.Get("From"); got != "Plexus <plexus@example.com>" {
+		t.Errorf("Unexpected from %q", got)
+	}
+	if got := h.Get("To"); got != "Bob <bob@example.com>, carol@example.com" {
+		t.Errorf("Unexpected to %q", got)
+	}
+	if !strings.HasPrefix(h.Get("Content-Type"), "multipart/related") {
+		t.Errorf("Expected a multipart/related mail, got %q", h.Get("Content-Type"))
+	}
+	if len(parts) != 3 {
+		t.Fatalf("Expected text, html and thumb parts, got %d", len(parts))
+	}
+	if !strings.HasPrefix(parts[0].header.Get("Content-Type"), "text/plain") || parts[0].body != "Alice started The Expanse S02E03 on Living Room TV" {
+		t.Errorf("Unexpected text part %+v", parts[0])
+	}
+	if !strings.HasPrefix(parts[1].header.Get("Content-Type"), "text/html") || !strings.Contains(parts[1].body, `<img src="cid:thumb"`) {
+		t.Errorf("Unexpected html part %+v", parts[1])
+	}
+	if parts[2].header.Get("Content-Type") != "image/png" || parts[2].header.Get("Content-ID") != "<thumb>" || parts[2].body != "PNG" {
+		t.Errorf("Unexpected thumb part %+v", parts[2])
+	}
+}
+
+func TestEmailActionImplicitTLS(t *testing.T) {
+	certs, ca, cleanup := testCertificate(t)
+	defer cleanup()
+	srv := newFakeSMTP(t, certs, true, "", "")
+	defer srv.close()
+	st, cleanupStore := tempStore(t)
+	defer cleanupStore()
+
+	cfg, err := NewConfig(strings.NewReader(`{
+		"smtp": {"address": "` + srv.addr() + `", "security": "tls", "tls": {"ca": "` + ca + `"}},
+		"triggers": [{"properties": {}, "actions": [{"type": "email", "config": {
+			"from": "plexus@example.com",
+			"to": ["bob@example.com"],
+			"subject": "Now playing: {{.Payload.MediaTitle}} ✓",
+			"text": "{{.Payload.Player.Title}}",
+			"html": "<h1>{{.Payload.MediaTitle}}</h1><p>{{.Payload.Player.Title}}</p>",
+			"thumb": "attach"
+		}}]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	ev := chatEvent(t, st.dbPath)
+	ev.Activity.Payload.Player.Title = "Tom & Jerry's TV"
+	if err := cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), ev); err != nil {
+		t.Fatalf("Unexpected error sending email: %v", err)
+	}
+	mails := srv.received()
+	if len(mails) != 1 || !mails[0].tls {
+		t.Fatalf("Expected 1 mail over tls, got %+v", mails)
+	}
+	h, parts := mailParts(t, mails[0].data)
+	dec := mime.WordDecoder{}
+	if subject, _ := dec.DecodeHeader(h.Get("Subject")); subject != "Now playing: The Expanse S02E03 ✓" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+	if !strings.HasPrefix(h.Get("Content-Type"), "multipart/mixed") {
+		t.Errorf("Expected a multipart/mixed mail, got %q", h.Get("Content-Type"))
+	}
+	// Values are escaped in the html version only
+	if len(parts) != 3 || parts[0].body != "Tom & Jerry's TV" || parts[1].body != "<h1>The Expanse S02E03</h1><p>Tom &amp; Jerry&#39;s TV</p>" {
+		t.Fatalf("Unexpected parts %+v", parts)
+	}
+	if parts[2].header.Get("Content-Disposition") != `attachment; filename="thumb.png"` || parts[2].body != "PNG" {
+		t.Errorf("Unexpected thumb part %+v", parts[2])
+	}
+}
+
+func TestEmailActionSecurity(t *testing.T) {
+	// A server without STARTTLS is refused unless security is none
+	srv := newFakeSMTP(t, nil, false, "", "")
+	defer srv.close()
+	for _, tt := range []struct {
+		security string
+		ok       bool
+	}{{"", false}, {"none", true}} {
+		cfg, err := NewConfig(strings.NewReader(`{
+			"smtp": {"address": "` + srv.addr() + `", "security": "` + tt.security + `", "from": "plexus@example.com"},
+			"triggers": [{"properties": {}, "actions": [{"type": "email", "config": {"to": ["bob@example.com"], "thumb": "none"}}]}]
+		}`))
+		if err != nil {
+			t.Fatalf("Unexpected error loading config: %v", err)
+		}
+		err = cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t))
+		if (err == nil) != tt.ok {
+			t.Errorf("security %q: unexpected result %v", tt.security, err)
+		}
+	}
+	if n := len(srv.received()); n != 1 {
+		t.Errorf("Expected 1 mail, got %d", n)
+	}
+}
+
+func TestNewConfigInvalidEmail(t *testing.T) {
+	smtp := `"smtp": {"address": "mail.example.com:587", "from": "plexus@example.com"}`
+	for _, config := range []string{
+		`{"smtp": {"address": "mail.example.com"}, "triggers": []}`,
+		`{"smtp": {"address": "mail.example.com:25", "security": "ssl"}, "triggers": []}`,
+		`{"smtp": {"address": "mail.example.com:25", "security": "none", "tls": {}}, "triggers": []}`,
+		`{"smtp": {"address": "mail.example.com:25", "from": "not an address"}, "triggers": []}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "email", "config": {"from": "a@example.com", "to": ["b@example.com"]}}]}]}`,
+		`{"smtp": {"address": "mail.example.com:587"}, "triggers": [{"properties": {}, "actions": [{"type": "email", "config": {"to": ["b@example.com"]}}]}]}`,
+		`{` + smtp + `, "triggers": [{"properties": {}, "actions": [{"type": "email", "config": {}}]}]}`,
+		`{` + smtp + `, "triggers": [{"properties": {}, "actions": [{"type": "email", "config": {"to": ["bob"]}}]}]}`,
+		`{` + smtp + `, "triggers": [{"properties": {}, "actions": [{"type": "email", "config": {"to": ["b@example.com"], "thumb": "link"}}]}]}`,
+		`{` + smtp + `, "triggers": [{"properties": {}, "actions": [{"type": "email", "config": {"to": ["b@example.com"], "html": "{{"}}]}]}`,
+	} {
+		if _, err := NewConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("Expected an error loading %s, got none", config)
+		}
+	}
+}
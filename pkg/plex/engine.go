@@ -0,0 +1,203 @@
+package plex
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Engine runs webhook activity through the current Config.  It owns the runtime state that must outlive any single
+// Config, such as trigger limits, correlation timers, delayed actions and enabled overrides, so that the Config can be
+// swapped out with Load.  It is safe for concurrent use.
+type Engine struct {
+	mu         sync.RWMutex
+	cfg        Config
+	limiter    *Limiter
+	correlator *Correlator
+	toggles    *Toggles
+	executor   *Executor
+	scheduler  *Scheduler
+}
+
+// NewEngine creates an Engine running the given Config.  Correlation timers, delayed actions and dead letters are
+// persisted to store, which may be nil to keep timers and delayed actions in memory only and not keep dead letters.
+func NewEngine(cfg Config, store *Store) *Engine {
+	e := Engine{
+		limiter:    NewLimiter(),
+		correlator: NewCorrelator(store),
+		toggles:    NewToggles(),
+		executor:   NewExecutor(store),
+		scheduler:  NewScheduler(store),
+	}
+	e.correlator.triggers = e.trigger
+	e.correlator.executor = e.executor
+	e.correlator.toggles = e.toggles
+	e.scheduler.triggers = e.trigger
+	e.scheduler.executor = e.executor
+	e.executor.scheduler = e.scheduler
+	e.Load(cfg)
+	return &e
+}
+
+// Start runs actions asynchronously from now on, on a pool of workers configured by opts (see Executor.Start)
+func (e *Engine) Start(opts ExecutorOptions) {
+	e.executor.Start(opts)
+}
+
+// Shutdown waits for queued actions to run, or for ctx to be done (see Executor.Shutdown).  Actions matched after
+// Shutdown is called are recorded as dead letters.  Delayed actions that have not come due are left for the next run.
+func (e *Engine) Shutdown(ctx context.Context) error {
+	e.scheduler.stop()
+	err := e.executor.Shutdown(ctx)
+	for _, b := range e.Config().MQTT {
+		b.client.close()
+	}
+	return err
+}
+
+// Restore re-arms the correlation timers and delayed actions persisted by a previous run
+func (e *Engine) Restore(logger log.Logger) error {
+	if err := e.correlator.restore(logger, e.Config().clock()); err != nil {
+		return err
+	}
+	return e.scheduler.restore(logger)
+}
+
+// Load replaces the Engine's Config, carrying over the runtime state of the previous one.  Connections to MQTT brokers
+// whose settings did not change are kept, and the others are closed.
+func (e *Engine) Load(cfg Config) {
+	cfg.Limiter = e.limiter
+	cfg.Correlator = e.correlator
+	cfg.Toggles = e.toggles
+	cfg.Executor = e.executor
+	cfg.Scheduler = e.scheduler
+	e.scheduler.setClock(cfg.clock())
+	e.mu.Lock()
+	unused := carryMQTTClients(e.cfg.MQTT, cfg.MQTT)
+	e.cfg = cfg
+	e.mu.Unlock()
+	for _, c := range unused {
+		c.close()
+	}
+}
+
+// Config returns the Config currently in use
+func (e *Engine) Config() Config {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.cfg
+}
+
+// Handle transacts the given activity with the current Config (see Config.Handle)
+func (e *Engine) Handle(logger log.Logger, act Activity, doc interface{}) error {
+	return e.Config().Handle(logger, act, doc)
+}
+
+// Explain evaluates the event against the current Config without running any actions (see Config.Explain)
+func (e *Engine) Explain(doc interface{}, at time.Time) []TriggerResult {
+	return e.Config().Explain(doc, at)
+}
+
+// Pending returns the armed correlation timers
+func (e *Engine) Pending() []Pending {
+	return e.correlator.Pending()
+}
+
+// Jobs returns the delayed actions waiting to run, soonest first, followed by the most recently cancelled ones
+func (e *Engine) Jobs() []ScheduledJob {
+	return e.scheduler.Jobs()
+}
+
+// CancelJob cancels the delayed action with the given ID, or returns ErrUnknownJob
+func (e *Engine) CancelJob(logger log.Logger, id string) error {
+	return e.scheduler.Cancel(logger, id)
+}
+
+// DeadLetters returns the actions that failed after their retries, most recent first
+func (e *Engine) DeadLetters() ([]DeadLetter, error) {
+	return e.executor.DeadLetters()
+}
+
+// DeadLetter returns the dead letter with the given ID, or ErrUnknownDeadLetter
+func (e *Engine) DeadLetter(id string) (DeadLetter, error) {
+	return e.executor.DeadLetter(id)
+}
+
+// Redrive queues the action of the dead letter with the given ID to be executed again, using the currently configured
+// trigger.  The dead letter is removed when the action succeeds, and updated with the new failure otherwise.  An error
+// is returned when the redrive cannot be queued.
+func (e *Engine) Redrive(logger log.Logger, id string) error {
+	d, err := e.executor.DeadLetter(id)
+	if err != nil {
+		return err
+	}
+	t, ok := e.trigger(d.Trigger)
+	if !ok {
+		return ErrUnknownTrigger
+	}
+	if t.derivedID {
+		// The trigger's position may have changed since the dead letter was recorded
+		return fmt.Errorf("trigger %q has no id of its own, so its dead letters cannot be redriven", t.ID)
+	}
+	return e.executor.queueRedrive(logger, t, d)
+}
+
+// TriggerStatus describes a configured trigger and whether it is currently enabled
+type TriggerStatus struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Enabled     bool     `json:"enabled"`
+}
+
+// Triggers describes every configured trigger
+func (e *Engine) Triggers() []TriggerStatus {
+	cfg := e.Config()
+	ts := make([]TriggerStatus, 0, len(cfg.Triggers))
+	for _, t := range cfg.Triggers {
+		ts = append(ts, TriggerStatus{
+			ID:          t.ID,
+			Name:        t.Name,
+			Description: t.Description,
+			Tags:        t.Tags,
+			Enabled:     e.toggles.enabled(t),
+		})
+	}
+	return ts
+}
+
+// SetTriggerEnabled enables or disables the trigger with the given ID until the next restart
+func (e *Engine) SetTriggerEnabled(id string, enabled bool) error {
+	if _, ok := e.trigger(id); !ok {
+		return ErrUnknownTrigger
+	}
+	e.toggles.SetTrigger(id, enabled)
+	return nil
+}
+
+// SetTagEnabled enables or disables every trigger with the given tag until the next restart
+func (e *Engine) SetTagEnabled(tag string, enabled bool) error {
+	for _, t := range e.Config().Triggers {
+		for _, tt := range t.Tags {
+			if tt == tag {
+				e.toggles.SetTag(tag, enabled)
+				return nil
+			}
+		}
+	}
+	return ErrUnknownTag
+}
+
+// trigger finds the currently configured trigger with the given ID
+func (e *Engine) trigger(id string) (Trigger, bool) {
+	for _, t := range e.Config().Triggers {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return Trigger{}, false
+}
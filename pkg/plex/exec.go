@@ -0,0 +1,179 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxExecOutput is the amount of a command's stdout and stderr that is logged
+const maxExecOutput = 4096
+
+func init() {
+	RegisterAction("exec", newExecAction)
+}
+
+// ExecAction runs a local command for an event.  Args and the Env values are templates rendered over the event (see
+// templateData).  Besides Env, the command's environment has the variables of plexus itself, PLEXUS_TRIGGER,
+// PLEXUS_REQUEST_ID and PLEXUS_THUMB_PATH, and a PLEXUS_ variable for every field of the payload, such as
+// PLEXUS_PLAYER_TITLE for Player.title (see payloadEnv).  The payload is written to the command's stdin as JSON.
+// The command, along with any processes it started, is killed when the action times out.
+type ExecAction struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Dir     string            `json:"dir,omitempty"`
+	User    string            `json:"user,omitempty"`
+
+	args []*Template
+	env  map[string]*Template
+	cred *credential
+}
+
+// newExecAction creates an ExecAction from its configuration, compiling its templates and resolving its user
+func newExecAction(c ActionConfig) (Action, error) {
+	e := ExecAction{}
+	if err := c.Decode(&e); err != nil {
+		return nil, err
+	}
+	if e.Command == "" {
+		return nil, fmt.Errorf("invalid exec action specified; missing command")
+	}
+	for i, a := range e.Args {
+		t, err := compileTemplate(fmt.Sprintf("arg %d", i), a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exec arg %d template: %v", i, err)
+		}
+		e.args = append(e.args, t)
+	}
+	e.env = map[string]*Template{}
+	for k, v := range e.Env {
+		t, err := compileTemplate("env "+k, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exec env %q template: %v", k, err)
+		}
+		e.env[k] = t
+	}
+	if e.User != "" {
+		cred, err := lookupCredential(e.User)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exec user %q: %v", e.User, err)
+		}
+		e.cred = cred
+	}
+	return e, nil
+}
+
+// Execute runs the command, logging its exit code and output.  A command exiting with a non-zero status fails.
+func (e ExecAction) Execute(ctx context.Context, ev Event) error {
+	args := make([]string, 0, len(e.args))
+	for i, t := range e.args {
+		a, err := t.render(ev)
+		if err != nil {
+			return fmt.Errorf("could not render exec arg %d: %v", i, err)
+		}
+		args = append(args, a)
+	}
+	env := append(os.Environ(), payloadEnv(ev)...)
+	keys := make([]string, 0, len(e.env))
+	for k := range e.env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := e.env[k].render(ev)
+		if err != nil {
+			return fmt.Errorf("could not render exec env %q: %v", k, err)
+		}
+		env = append(env, k+"="+v)
+	}
+	stdin, err := json.Marshal(ev.Document)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(e.Command, args...)
+	cmd.Env = env
+	cmd.Dir = e.Dir
+	cmd.Stdin = bytes.NewReader(stdin)
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	setProcAttr(cmd, e.cred)
+
+	logger := ev.logger()
+	logger.Log("action", "exec", "msg", "running command", "command", e.Command, "args", len(args))
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			kill(cmd)
+		case <-done:
+		}
+	}()
+	err = cmd.Wait()
+	close(done)
+	code := -1
+	if cmd.ProcessState != nil {
+		code = cmd.ProcessState.ExitCode()
+	}
+	logger.Log("action", "exec", "msg", "command finished", "command", e.Command, "exit_code", code,
+		"stdout", truncate(stdout.String(), maxExecOutput), "stderr", truncate(stderr.String(), maxExecOutput))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+var envUnsafe = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// payloadEnv returns the environment variables describing the event: PLEXUS_TRIGGER, PLEXUS_REQUEST_ID,
+// PLEXUS_THUMB_PATH and a PLEXUS_ variable for every scalar field of the payload, named after its path in upper case
+// with anything but letters and digits replaced by '_'.  Array elements are numbered, e.g. PLEXUS_METADATA_GENRE_0_TAG.
+func payloadEnv(ev Event) []string {
+	env := []string{
+		"PLEXUS_TRIGGER=" + ev.Trigger,
+		"PLEXUS_REQUEST_ID=" + ev.Activity.RequestID,
+		"PLEXUS_THUMB_PATH=" + ev.Activity.ThumbPath,
+	}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(prefix+"_"+envUnsafe.ReplaceAllString(strings.ToUpper(k), "_"), t[k])
+			}
+		case []interface{}:
+			for i, e := range t {
+				walk(fmt.Sprintf("%s_%d", prefix, i), e)
+			}
+		default:
+			if s, ok := scalarString(t); ok {
+				env = append(env, prefix+"="+s)
+			}
+		}
+	}
+	walk("PLEXUS", ev.Document)
+	return env
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,82 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// execAction loads an exec action with the given config
+func execAction(t *testing.T, config string) Action {
+	if runtime.GOOS == "windows" {
+		t.Skip("exec tests use /bin/sh")
+	}
+	cfg, err := NewConfig(strings.NewReader(`{"triggers": [{"properties": {}, "actions": [{"type": "exec", "config": ` + config + `}]}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	return cfg.Triggers[0].ParsedActions[0]
+}
+
+func TestExecAction(t *testing.T) {
+	a := execAction(t, `{
+		"command": "/bin/sh",
+		"args": ["-c", "echo \"$1 $PLEXUS_PLAYER_TITLE $PLEXUS_METADATA_YEAR $TITLE\"; cat; echo oops >&2", "sh", "{{.Payload.Event}}"],
+		"env": {"TITLE": "{{.Payload.Metadata.Title | upper}}"},
+		"dir": "/"
+	}`)
+	buf := bytes.Buffer{}
+	if err := a.Execute(context.Background(), loggedEvent(t, log.NewLogfmtLogger(&buf))); err != nil {
+		t.Fatalf("Unexpected error executing command: %v", err)
+	}
+	for _, want := range []string{
+		`exit_code=0`,
+		`stdout="media.play Living Room 1979 ALIEN\n{\"Metadata\":{\"addedAt\":1559347200,\"title\":\"Alien\",\"year\":1979},\"Player\":{\"title\":\"Living Room\"},\"event\":\"media.play\"}"`,
+		`stderr="oops\n"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected the log to contain %s, got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestExecActionFailure(t *testing.T) {
+	a := execAction(t, `{"command": "/bin/sh", "args": ["-c", "exit 3"]}`)
+	buf := bytes.Buffer{}
+	if err := a.Execute(context.Background(), loggedEvent(t, log.NewLogfmtLogger(&buf))); err == nil {
+		t.Errorf("Expected a non-zero exit status to fail the action")
+	}
+	if !strings.Contains(buf.String(), "exit_code=3") {
+		t.Errorf("Expected the log to contain the exit code, got:\n%s", buf.String())
+	}
+
+	a = execAction(t, `{"command": "/bin/sh", "args": ["-c", "sleep 10"]}`)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := a.Execute(ctx, templateEvent(t)); err != context.DeadlineExceeded {
+		t.Errorf("Expected the command to time out, got: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected the command to be killed when it timed out")
+	}
+}
+
+func TestNewConfigInvalidExec(t *testing.T) {
+	for _, config := range []string{
+		`{}`,
+		`{"command": "true", "args": ["{{"]}`,
+		`{"command": "true", "env": {"A": "{{end}}"}}`,
+		`{"command": "true", "user": "no-such-user-plexus"}`,
+	} {
+		_, err := NewConfig(strings.NewReader(`{"triggers": [{"properties": {}, "actions": [{"type": "exec", "config": ` + config + `}]}]}`))
+		if err == nil {
+			t.Errorf("Expected an error loading exec config %s, got none", config)
+		}
+	}
+}
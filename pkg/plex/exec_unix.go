@@ -0,0 +1,49 @@
+//go:build !windows
+// +build !windows
+
+package plex
+
+import (
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// credential is the user and group a command runs as
+type credential struct {
+	uid, gid uint32
+}
+
+// lookupCredential resolves a user name or uid to the credential of the user and its primary group
+func lookupCredential(name string) (*credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, err
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &credential{uid: uint32(uid), gid: uint32(gid)}, nil
+}
+
+// setProcAttr makes the command run in a process group of its own, so that kill reaches any processes it starts, and
+// as the given user, if any.  Plexus must be running as root, or otherwise be allowed to change its user.
+func setProcAttr(cmd *exec.Cmd, c *credential) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if c != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: c.uid, Gid: c.gid}
+	}
+}
+
+// kill kills the command's process group
+func kill(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,20 @@
+package plex
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// credential is the user a command runs as, which is not supported on Windows
+type credential struct{}
+
+func lookupCredential(name string) (*credential, error) {
+	return nil, errors.New("running commands as another user is not supported on windows")
+}
+
+func setProcAttr(cmd *exec.Cmd, c *credential) {}
+
+// kill kills the command's process, but not any processes it started
+func kill(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,448 @@
+package plex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pborman/uuid"
+)
+
+// ErrUnknownDeadLetter is returned when a dead letter cannot be found
+var ErrUnknownDeadLetter = errors.New("unknown dead letter")
+
+// ErrQueueFull is returned when a redrive cannot be queued because every worker is busy and the queue is full
+var ErrQueueFull = errors.New("action queue is full")
+
+// ErrShuttingDown is returned when a redrive cannot be queued because the Executor is shutting down
+var ErrShuttingDown = errors.New("shutting down")
+
+// defaultRetryStatuses are the HTTP statuses retried when a Retry does not list any
+var defaultRetryStatuses = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// StatusError is returned by actions whose request was answered with an unsuccessful HTTP status
+type StatusError struct {
+	Code int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("unexpected response status %d %s", e.Code, http.StatusText(e.Code))
+}
+
+// Retry is the retry policy of an action.  A failed action is attempted up to Attempts times in all, waiting Backoff
+// (1s by default) before the second attempt and doubling the wait before each subsequent one, up to MaxBackoff (1m
+// by default).  Each wait is randomly varied by up to the Jitter fraction of it (0.2 by default).  Actions failing
+// with an HTTP status are only retried for the listed Statuses (by default 408, 429, 500, 502, 503 and 504); other
+// failures, such as connection errors, are always retried.
+type Retry struct {
+	Attempts   int      `json:"attempts"`
+	Backoff    string   `json:"backoff,omitempty"`
+	MaxBackoff string   `json:"maxBackoff,omitempty"`
+	Jitter     *float64 `json:"jitter,omitempty"`
+	Statuses   []int    `json:"statuses,omitempty"`
+}
+
+// retryPolicy is a compiled Retry
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	jitter     float64
+	statuses   map[int]bool
+}
+
+// noRetry is the policy of actions without a Retry: a single attempt
+var noRetry = retryPolicy{attempts: 1}
+
+func (r Retry) compile() (retryPolicy, error) {
+	p := retryPolicy{
+		attempts:   r.Attempts,
+		backoff:    time.Second,
+		maxBackoff: time.Minute,
+		jitter:     0.2,
+		statuses:   map[int]bool{},
+	}
+	if p.attempts < 1 {
+		return p, fmt.Errorf("attempts must be at least 1")
+	}
+	var err error
+	if r.Backoff != "" {
+		if p.backoff, err = parsePositiveDuration("backoff", r.Backoff); err != nil {
+			return p, err
+		}
+	}
+	if r.MaxBackoff != "" {
+		if p.maxBackoff, err = parsePositiveDuration("maxBackoff", r.MaxBackoff); err != nil {
+			return p, err
+		}
+	}
+	if p.maxBackoff < p.backoff {
+		return p, fmt.Errorf("maxBackoff must not be less than backoff")
+	}
+	if r.Jitter != nil {
+		if *r.Jitter < 0 || *r.Jitter > 1 {
+			return p, fmt.Errorf("jitter must be between 0 and 1")
+		}
+		p.jitter = *r.Jitter
+	}
+	statuses := r.Statuses
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatuses
+	}
+	for _, s := range statuses {
+		p.statuses[s] = true
+	}
+	return p, nil
+}
+
+// retryable determines if an action failing with err should be attempted again
+func (p retryPolicy) retryable(err error) bool {
+	if se, ok := err.(StatusError); ok {
+		return p.statuses[se.Code]
+	}
+	return true
+}
+
+// delay is the wait after the given failed attempt
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := float64(p.backoff) * math.Pow(2, float64(attempt-1))
+	d = math.Min(d, float64(p.maxBackoff))
+	d += d * p.jitter * (2*rand.Float64() - 1)
+	return time.Duration(d)
+}
+
+// actionSpec holds the settings of a parsed action that apply to any action type
+type actionSpec struct {
+	typ      string
+	retry    retryPolicy
+	timeout  time.Duration
+	delay    time.Duration
+	cancelOn *CancelOn
+	cancel   *cancelPolicy
+}
+
+// DeadLetter is an action execution that still failed after its retries
+type DeadLetter struct {
+	ID       string      `json:"id"`
+	Trigger  string      `json:"trigger"`
+	Action   int         `json:"action"`
+	Type     string      `json:"type"`
+	Attempts int         `json:"attempts"`
+	Redrives int         `json:"redrives"`
+	Error    string      `json:"error"`
+	FailedAt time.Time   `json:"failedAt"`
+	Activity Activity    `json:"activity"`
+	Document interface{} `json:"document"`
+}
+
+// ExecutorOptions configures the worker pool of an Executor
+type ExecutorOptions struct {
+	// Workers is the number of actions run concurrently
+	Workers int
+	// QueueSize is the number of triggers whose actions may wait for a worker.  Triggers matched while the queue is
+	// full have their actions recorded as dead letters instead.
+	QueueSize int
+	// Timeout bounds each attempt of an action without a timeout of its own.  Zero means no limit.
+	Timeout time.Duration
+}
+
+// Executor runs the actions of matched triggers, retrying failures according to each action's Retry and recording
+// those that still fail as dead letters in its Store.  Until Start is called, actions are run synchronously by the
+// caller; afterwards, they are queued for a pool of workers.  A nil Executor runs actions synchronously, but keeps no
+// dead letters.  It is safe for concurrent use.
+type Executor struct {
+	store   *Store
+	timeout time.Duration
+
+	// ctx is the parent of every action's context; cancel aborts actions still running when Shutdown gives up
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	queue  chan job
+	closed bool
+	wg     sync.WaitGroup
+
+	// scheduler holds the jobs of delayed actions; when nil, delayed actions are skipped
+	scheduler *Scheduler
+}
+
+// job is a matched trigger whose actions are waiting for a worker, or a dead letter waiting to be redriven
+type job struct {
+	logger  log.Logger
+	t       Trigger
+	ev      Event
+	actions []int
+	redrive *DeadLetter
+}
+
+// NewExecutor creates an Executor writing dead letters to the given Store.  store may be nil, in which case failed
+// actions are only logged.
+func NewExecutor(store *Store) *Executor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Executor{
+		store:  store,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start starts the worker pool.  It must be called at most once, before the Executor is in use.
+func (x *Executor) Start(opts ExecutorOptions) {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.timeout = opts.Timeout
+	x.queue = make(chan job, opts.QueueSize)
+	for i := 0; i < opts.Workers; i++ {
+		x.wg.Add(1)
+		go x.work(x.queue)
+	}
+}
+
+func (x *Executor) work(queue <-chan job) {
+	defer x.wg.Done()
+	for j := range queue {
+		if j.redrive != nil {
+			x.redrive(j.logger, j.t, *j.redrive)
+			continue
+		}
+		x.run(j.logger, j.t, j.ev, j.actions)
+	}
+}
+
+// cancelGrace bounds the wait, once Shutdown gives up, for cancelled actions to be recorded as dead letters
+var cancelGrace = 5 * time.Second
+
+// Shutdown stops accepting actions and waits for the queued ones to be run.  If ctx is done first, the actions still
+// running are cancelled, and they and the ones still queued are recorded as dead letters, for which Shutdown waits at
+// most cancelGrace longer.
+func (x *Executor) Shutdown(ctx context.Context) error {
+	x.mu.Lock()
+	if !x.closed && x.queue != nil {
+		close(x.queue)
+	}
+	x.closed = true
+	x.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		x.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		x.cancel()
+		select {
+		case <-done:
+		case <-time.After(cancelGrace):
+		}
+		return ctx.Err()
+	}
+}
+
+// execute runs the trigger's actions, or queues them for a worker once the pool is started.  Delayed actions are
+// handed to the Scheduler instead.
+func (x *Executor) execute(logger log.Logger, t Trigger, ev Event) {
+	actions := make([]int, 0, len(t.ParsedActions))
+	for i := range t.ParsedActions {
+		spec := t.actionSpec(i)
+		switch {
+		case spec.delay == 0:
+			actions = append(actions, i)
+		case x == nil || x.scheduler == nil:
+			logger.Log("msg", "action is delayed, but delays are not enabled; skipping", "action", spec.typ)
+		default:
+			x.scheduler.schedule(logger, t, i, ev)
+		}
+	}
+	if len(actions) > 0 {
+		x.executeActions(logger, t, ev, actions)
+	}
+}
+
+// executeActions runs the given actions of the trigger, or queues them for a worker once the pool is started
+func (x *Executor) executeActions(logger log.Logger, t Trigger, ev Event, actions []int) {
+	if x == nil {
+		x.run(logger, t, ev, actions)
+		return
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.closed {
+		x.reject(logger, t, ev, actions, ErrShuttingDown)
+		return
+	}
+	if x.queue == nil {
+		x.run(logger, t, ev, actions)
+		return
+	}
+	select {
+	case x.queue <- job{logger: logger, t: t, ev: ev, actions: actions}:
+	default:
+		x.reject(logger, t, ev, actions, ErrQueueFull)
+	}
+}
+
+// reject records the given actions as dead letters without running them
+func (x *Executor) reject(logger log.Logger, t Trigger, ev Event, actions []int, err error) {
+	logger.Log("msg", "could not run actions", "err", err)
+	for _, i := range actions {
+		x.deadLetter(logger, newDeadLetter(t, i, ev, 0, err))
+	}
+}
+
+// run runs each of the given actions of the trigger in turn.  An action that fails does not stop the ones after it.
+func (x *Executor) run(logger log.Logger, t Trigger, ev Event, actions []int) {
+	for _, i := range actions {
+		a, spec := t.ParsedActions[i], t.actionSpec(i)
+		n, err := x.attempt(logger, a, spec, ev)
+		if err == nil {
+			continue
+		}
+		logger.Log("msg", "action failed", "action", spec.typ, "attempts", n, "err", err)
+		x.deadLetter(logger, newDeadLetter(t, i, ev, n, err))
+	}
+}
+
+func newDeadLetter(t Trigger, i int, ev Event, attempts int, err error) DeadLetter {
+	return DeadLetter{
+		ID:       uuid.NewRandom().String(),
+		Trigger:  t.ID,
+		Action:   i,
+		Type:     t.actionSpec(i).typ,
+		Attempts: attempts,
+		Error:    err.Error(),
+		FailedAt: time.Now(),
+		Activity: ev.Activity,
+		Document: ev.Document,
+	}
+}
+
+// attempt executes the action until it succeeds or its retry policy gives up, returning the number of attempts made.
+// Each attempt is bounded by the action's timeout, or the Executor's.
+func (x *Executor) attempt(logger log.Logger, a Action, spec actionSpec, ev Event) (int, error) {
+	parent, timeout := context.Background(), spec.timeout
+	if x != nil {
+		parent = x.ctx
+		if timeout == 0 {
+			timeout = x.timeout
+		}
+	}
+	for n := 1; ; n++ {
+		ev.Logger, ev.Attempt = logger, n
+		err := x.try(parent, timeout, a, ev)
+		if err == nil {
+			return n, nil
+		}
+		if n >= spec.retry.attempts || !spec.retry.retryable(err) || parent.Err() != nil {
+			return n, err
+		}
+		d := spec.retry.delay(n)
+		logger.Log("msg", "action failed, retrying", "attempt", n, "wait", d, "err", err)
+		select {
+		case <-time.After(d):
+		case <-parent.Done():
+			return n, parent.Err()
+		}
+	}
+}
+
+// try makes a single attempt at the action
+func (x *Executor) try(parent context.Context, timeout time.Duration, a Action, ev Event) error {
+	ctx := parent
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, timeout)
+		defer cancel()
+	}
+	return a.Execute(ctx, ev)
+}
+
+func (x *Executor) deadLetter(logger log.Logger, d DeadLetter) {
+	if x == nil || x.store == nil {
+		return
+	}
+	if err := x.store.AddDeadLetter(d); err != nil {
+		logger.Log("msg", "could not save dead letter", "err", err)
+		return
+	}
+	logger.Log("msg", "saved failed action as a dead letter", "dead_letter", d.ID)
+}
+
+// DeadLetters returns every dead letter, most recent first
+func (x *Executor) DeadLetters() ([]DeadLetter, error) {
+	if x == nil || x.store == nil {
+		return []DeadLetter{}, nil
+	}
+	return x.store.GetAllDeadLetters()
+}
+
+// DeadLetter returns the dead letter with the given ID
+func (x *Executor) DeadLetter(id string) (DeadLetter, error) {
+	if x == nil || x.store == nil {
+		return DeadLetter{}, ErrUnknownDeadLetter
+	}
+	return x.store.GetDeadLetter(id)
+}
+
+// queueRedrive queues a dead letter to have its action executed again with the given trigger, as currently
+// configured.  Until the worker pool is started, the dead letter is redriven synchronously.
+func (x *Executor) queueRedrive(logger log.Logger, t Trigger, d DeadLetter) error {
+	if d.Action >= len(t.ParsedActions) || t.actionSpec(d.Action).typ != d.Type {
+		return fmt.Errorf("trigger %q no longer has a %s action %d", t.ID, d.Type, d.Action)
+	}
+	logger = log.With(logger, "trigger", t.ID, "dead_letter", d.ID)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.closed {
+		return ErrShuttingDown
+	}
+	if x.queue == nil {
+		x.redrive(logger, t, d)
+		return nil
+	}
+	select {
+	case x.queue <- job{logger: logger, t: t, redrive: &d}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// redrive executes a dead letter's action again with the given trigger.  The dead letter is removed when the action
+// succeeds, and updated with the new failure otherwise.
+func (x *Executor) redrive(logger log.Logger, t Trigger, d DeadLetter) {
+	ev := newEvent(t, d.Activity, d.Document)
+	n, err := x.attempt(logger, t.ParsedActions[d.Action], t.actionSpec(d.Action), ev)
+	if err == nil {
+		logger.Log("msg", "redrove dead letter")
+		if err := x.store.DeleteDeadLetter(d.ID); err != nil {
+			logger.Log("msg", "could not delete dead letter", "err", err)
+		}
+		return
+	}
+	logger.Log("msg", "redriven action failed", "action", d.Type, "attempts", n, "err", err)
+	d.Attempts += n
+	d.Redrives++
+	d.Error = err.Error()
+	d.FailedAt = time.Now()
+	x.deadLetter(logger, d)
+}
@@ -0,0 +1,358 @@
+package plex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// flakyServer responds with each of the given statuses in turn, then 200 OK, counting the requests it receives
+type flakyServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests int
+}
+
+func newFlakyServer(statuses ...int) *flakyServer {
+	s := &flakyServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		if len(s.statuses) > 0 {
+			w.WriteHeader(s.statuses[0])
+			s.statuses = s.statuses[1:]
+		}
+	}))
+	return s
+}
+
+// retryingEngine loads a trigger with a webhook action to url, retried according to retry, into an Engine
+func retryingEngine(t *testing.T, store *Store, url, retry string) *Engine {
+	cfg, err := NewConfig(strings.NewReader(`{"triggers": [{"id": "lights", "properties": {"event": "media.play"}, "actions": [
+		{"type": "webhook", "config": {"url": "` + url + `"}, "retry": ` + retry + `}
+	]}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	return NewEngine(cfg, store)
+}
+
+func play(t *testing.T, e *Engine) {
+	doc, err := ParseDocument([]byte(`{"event": "media.play"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	act := Activity{ReceivedAt: time.Now(), RequestID: "abc123"}
+	act.Payload.Event = "media.play"
+	if err := e.Handle(log.NewNopLogger(), act, doc); err != nil {
+		t.Fatalf("Expected failed actions not to fail Handle, got: %v", err)
+	}
+}
+
+func TestExecutorRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retry    string
+		requests int
+		failed   bool
+	}{
+		{"success", nil, `{"attempts": 3}`, 1, false},
+		{"recovers", []int{503, 502}, `{"attempts": 3, "backoff": "1ms", "jitter": 0}`, 3, false},
+		{"gives up", []int{503, 503, 503}, `{"attempts": 3, "backoff": "1ms", "jitter": 0}`, 3, true},
+		{"not retryable", []int{404}, `{"attempts": 3, "backoff": "1ms", "jitter": 0}`, 1, true},
+		{"custom statuses", []int{404}, `{"attempts": 3, "backoff": "1ms", "jitter": 0, "statuses": [404]}`, 2, false},
+		{"custom statuses exclude defaults", []int{503}, `{"attempts": 3, "backoff": "1ms", "jitter": 0, "statuses": [404]}`, 1, true},
+	}
+	for _, tt := range tests {
+		srv := newFlakyServer(tt.statuses...)
+		store, cleanup := tempStore(t)
+		e := retryingEngine(t, store, srv.URL, tt.retry)
+		play(t, e)
+		srv.Close()
+		if srv.requests != tt.requests {
+			t.Errorf("%s: expected %d requests, got %d", tt.name, tt.requests, srv.requests)
+		}
+		ds, err := e.DeadLetters()
+		if err != nil {
+			t.Fatalf("%s: unexpected error listing dead letters: %v", tt.name, err)
+		}
+		if tt.failed != (len(ds) == 1) || len(ds) > 1 {
+			t.Errorf("%s: expected failed to be %v, got %d dead letters", tt.name, tt.failed, len(ds))
+		}
+		cleanup()
+	}
+}
+
+func TestEngineRedrive(t *testing.T) {
+	srv := newFlakyServer(500, 500, 500, 500)
+	defer srv.Close()
+	store, cleanup := tempStore(t)
+	defer cleanup()
+	e := retryingEngine(t, store, srv.URL, `{"attempts": 2, "backoff": "1ms", "jitter": 0}`)
+	play(t, e)
+
+	ds, err := e.DeadLetters()
+	if err != nil {
+		t.Fatalf("Unexpected error listing dead letters: %v", err)
+	}
+	if len(ds) != 1 {
+		t.Fatalf("Expected 1 dead letter, got %d", len(ds))
+	}
+	d := ds[0]
+	if d.Trigger != "lights" || d.Type != "webhook" || d.Attempts != 2 || d.Activity.RequestID != "abc123" {
+		t.Errorf("Unexpected dead letter: %+v", d)
+	}
+	if got, err := e.DeadLetter(d.ID); err != nil || got.ID != d.ID {
+		t.Errorf("Expected to inspect dead letter %s, got %+v, %v", d.ID, got, err)
+	}
+
+	// Redriving retries too: the third and fourth requests fail again, the fifth succeeds.  Until the worker pool is
+	// started, redrives run synchronously.
+	if err := e.Redrive(log.NewNopLogger(), d.ID); err != nil {
+		t.Errorf("Unexpected error redriving: %v", err)
+	}
+	d, err = e.DeadLetter(d.ID)
+	if err != nil {
+		t.Fatalf("Expected the dead letter to be kept after a failed redrive, got: %v", err)
+	}
+	if d.Attempts != 4 || d.Redrives != 1 {
+		t.Errorf("Expected the failed redrive to be recorded, got: %+v", d)
+	}
+	e.Start(ExecutorOptions{Workers: 1, QueueSize: 1})
+	if err := e.Redrive(log.NewNopLogger(), d.ID); err != nil {
+		t.Errorf("Unexpected error queueing the redrive: %v", err)
+	}
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.DeadLetter(d.ID); err != ErrUnknownDeadLetter {
+		t.Errorf("Expected the dead letter to be removed after a redrive, got: %v", err)
+	}
+	if err := e.Redrive(log.NewNopLogger(), d.ID); err != ErrUnknownDeadLetter {
+		t.Errorf("Expected ErrUnknownDeadLetter, got: %v", err)
+	}
+	if _, err := e.DeadLetter("../deadletters"); err != ErrUnknownDeadLetter {
+		t.Errorf("Expected ErrUnknownDeadLetter for a malformed id, got: %v", err)
+	}
+}
+
+func TestEngineRedriveDerivedID(t *testing.T) {
+	srv := newFlakyServer(500)
+	defer srv.Close()
+	store, cleanup := tempStore(t)
+	defer cleanup()
+	cfg, err := NewConfig(strings.NewReader(`{"triggers": [{"properties": {"event": "media.play"}, "actions": [
+		{"type": "webhook", "config": {"url": "` + srv.URL + `"}}
+	]}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	e := NewEngine(cfg, store)
+	play(t, e)
+	ds, err := e.DeadLetters()
+	if err != nil || len(ds) != 1 {
+		t.Fatalf("Expected 1 dead letter, got %+v, %v", ds, err)
+	}
+	if err := e.Redrive(log.NewNopLogger(), ds[0].ID); err == nil {
+		t.Errorf("Expected redriving a dead letter of a trigger without an id to fail")
+	}
+	if srv.requests != 1 {
+		t.Errorf("Expected the action not to run again, got %d requests", srv.requests)
+	}
+}
+
+func TestRetryCompile(t *testing.T) {
+	for _, r := range []Retry{
+		{Attempts: 0},
+		{Attempts: 2, Backoff: "soon"},
+		{Attempts: 2, Backoff: "-1s"},
+		{Attempts: 2, Backoff: "1m", MaxBackoff: "1s"},
+		{Attempts: 2, Jitter: new(float64)},
+	} {
+		_, err := r.compile()
+		if r.Jitter != nil {
+			if err != nil {
+				t.Errorf("Unexpected error compiling %+v: %v", r, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Expected an error compiling %+v, got none", r)
+		}
+	}
+
+	p, err := Retry{Attempts: 5, Backoff: "1s", MaxBackoff: "5s"}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 800 * time.Millisecond, 1200 * time.Millisecond},
+		{2, 1600 * time.Millisecond, 2400 * time.Millisecond},
+		{3, 3200 * time.Millisecond, 4800 * time.Millisecond},
+		{4, 4 * time.Second, 6 * time.Second},
+	} {
+		if d := p.delay(tt.attempt); d < tt.min || d > tt.max {
+			t.Errorf("Expected the delay after attempt %d to be between %v and %v, got %v", tt.attempt, tt.min, tt.max, d)
+		}
+	}
+	if !p.retryable(errors.New("connection refused")) || !p.retryable(StatusError{Code: 503}) || p.retryable(StatusError{Code: 400}) {
+		t.Errorf("Unexpected retryable statuses")
+	}
+}
+
+// blockingAction is an Action that runs until it is released or its context is done
+type blockingAction struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (a blockingAction) Execute(ctx context.Context, ev Event) error {
+	a.started <- struct{}{}
+	select {
+	case <-a.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// blockingEngine loads a trigger running a blockingAction into an Engine with a single worker
+func blockingEngine(t *testing.T, store *Store, queue int) (*Engine, blockingAction) {
+	cfg, err := NewConfig(strings.NewReader(`{"triggers": [{"id": "lights", "properties": {"event": "media.play"}, "actions": []}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	a := blockingAction{started: make(chan struct{}, 10), release: make(chan struct{})}
+	cfg.Triggers[0].ParsedActions = []Action{a}
+	e := NewEngine(cfg, store)
+	e.Start(ExecutorOptions{Workers: 1, QueueSize: queue})
+	return e, a
+}
+
+func TestExecutorPool(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+	e, a := blockingEngine(t, store, 1)
+
+	// The first is picked up by the worker, the second is queued and the third does not fit
+	play(t, e)
+	<-a.started
+	play(t, e)
+	play(t, e)
+	ds, err := e.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 1 || ds[0].Attempts != 0 {
+		t.Errorf("Expected the action that did not fit in the queue to be a dead letter, got %+v", ds)
+	}
+
+	// Shutting down lets the queued action run
+	done := make(chan error)
+	go func() { done <- e.Shutdown(context.Background()) }()
+	a.release <- struct{}{}
+	<-a.started
+	a.release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error shutting down: %v", err)
+	}
+
+	// Once shut down, actions are not run
+	play(t, e)
+	if ds, _ := e.DeadLetters(); len(ds) != 2 {
+		t.Errorf("Expected the action matched after shutting down to be a dead letter, got %d dead letters", len(ds))
+	}
+}
+
+func TestExecutorShutdownTimeout(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+	e, a := blockingEngine(t, store, 1)
+
+	play(t, e)
+	<-a.started
+	play(t, e)
+
+	// Giving up cancels the running action, and the queued one is run with a cancelled context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the shutdown to time out, got: %v", err)
+	}
+	if ds, _ := e.DeadLetters(); len(ds) != 2 {
+		t.Errorf("Expected the cancelled actions to be dead letters, got %d dead letters", len(ds))
+	}
+}
+
+// stubbornAction is an Action that ignores its context, running until it is released
+type stubbornAction chan struct{}
+
+func (a stubbornAction) Execute(ctx context.Context, ev Event) error {
+	<-a
+	return nil
+}
+
+func TestExecutorShutdownGrace(t *testing.T) {
+	defer func(d time.Duration) { cancelGrace = d }(cancelGrace)
+	cancelGrace = 10 * time.Millisecond
+	e, _ := blockingEngine(t, nil, 1)
+	a := make(stubbornAction)
+	defer close(a)
+	e.Config().Triggers[0].ParsedActions[0] = a
+	play(t, e)
+
+	// An action ignoring its cancellation does not hold up the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- e.Shutdown(ctx) }()
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("Expected the shutdown to time out, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the shutdown not to wait for the action")
+	}
+}
+
+func TestExecutorActionTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	cfg, err := NewConfig(strings.NewReader(`{"triggers": [{"id": "lights", "properties": {"event": "media.play"}, "actions": [
+		{"type": "webhook", "config": {"url": "` + srv.URL + `"}, "timeout": "10ms", "retry": {"attempts": 2, "backoff": "1ms"}}
+	]}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	e := NewEngine(cfg, store)
+	e.Start(ExecutorOptions{Workers: 1, QueueSize: 1, Timeout: time.Minute})
+	play(t, e)
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ds, _ := e.DeadLetters()
+	if len(ds) != 1 || ds[0].Attempts != 2 {
+		t.Errorf("Expected the action to time out twice, got %+v", ds)
+	}
+}
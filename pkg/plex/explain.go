@@ -0,0 +1,124 @@
+package plex
+
+import (
+	"time"
+)
+
+// ConditionResult explains how a condition evaluated against a payload.  Property conditions report the operator,
+// the expected value and the actual value found at the path (an array of values for multi-valued paths).  Groups
+// ("all", "any" and "not") report the results of their members.
+type ConditionResult struct {
+	Type       string            `json:"type"`
+	Path       string            `json:"path,omitempty"`
+	Operator   string            `json:"operator,omitempty"`
+	Expected   interface{}       `json:"expected,omitempty"`
+	Actual     interface{}       `json:"actual"`
+	Found      bool              `json:"found"`
+	Pass       bool              `json:"pass"`
+	Conditions []ConditionResult `json:"conditions,omitempty"`
+}
+
+// TriggerResult explains how a trigger evaluated an event, and what it would have done
+type TriggerResult struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name,omitempty"`
+	Enabled    bool            `json:"enabled"`
+	Matched    bool            `json:"matched"`
+	Active     bool            `json:"active"`
+	Fired      bool            `json:"fired"`
+	Arms       bool            `json:"armsCorrelation,omitempty"`
+	Cancels    bool            `json:"cancelsCorrelation,omitempty"`
+	Conditions ConditionResult `json:"conditions"`
+	Actions    []string        `json:"actions"`
+}
+
+// Explain evaluates every trigger against an event without running any actions or touching runtime state such as
+// limits and correlation timers.  at is the time the event is considered received.
+func (c Config) Explain(doc interface{}, at time.Time) []TriggerResult {
+	rs := make([]TriggerResult, 0, len(c.Triggers))
+	for _, t := range c.Triggers {
+		m := t.matcher
+		if m == nil {
+			var err error
+			if m, err = t.condition().compile(); err != nil {
+				m = &matcher{}
+			}
+		}
+		r := TriggerResult{
+			ID:         t.ID,
+			Name:       t.Name,
+			Enabled:    c.Toggles.enabled(t),
+			Conditions: m.explain(doc),
+			Active:     t.ActiveAt(at),
+			Actions:    []string{},
+		}
+		r.Matched = r.Conditions.Pass
+		r.Fired = r.Enabled && r.Matched && r.Active
+		if t.correlation != nil {
+			r.Arms = r.Fired
+			r.Cancels = t.correlation.cancels(doc)
+		}
+		for _, ra := range t.RawActions {
+			r.Actions = append(r.Actions, ra.Type)
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// explain evaluates the compiled condition tree without short-circuiting, recording the result of every condition
+func (m *matcher) explain(doc interface{}) ConditionResult {
+	r := ConditionResult{Type: "all", Pass: true}
+	add := func(cr ConditionResult) {
+		r.Conditions = append(r.Conditions, cr)
+		r.Pass = r.Pass && cr.Pass
+	}
+	for _, p := range m.props {
+		vals, multi := p.path.resolve(doc)
+		var actual interface{}
+		switch {
+		case multi:
+			actual = vals
+		case len(vals) == 1:
+			actual = vals[0]
+		}
+		for _, op := range p.ops {
+			add(ConditionResult{
+				Type:     "property",
+				Path:     p.name,
+				Operator: op.name,
+				Expected: op.arg,
+				Actual:   actual,
+				Found:    len(vals) > 0,
+				Pass:     op.matchValues(vals, multi),
+			})
+		}
+	}
+	if len(m.all) > 0 {
+		g := ConditionResult{Type: "all", Pass: true}
+		for _, sub := range m.all {
+			sr := sub.explain(doc)
+			g.Conditions = append(g.Conditions, sr)
+			g.Pass = g.Pass && sr.Pass
+		}
+		add(g)
+	}
+	if len(m.any) > 0 {
+		g := ConditionResult{Type: "any"}
+		for _, sub := range m.any {
+			sr := sub.explain(doc)
+			g.Conditions = append(g.Conditions, sr)
+			g.Pass = g.Pass || sr.Pass
+		}
+		add(g)
+	}
+	if m.not != nil {
+		sr := m.not.explain(doc)
+		add(ConditionResult{
+			Type:       "not",
+			Pass:       !sr.Pass,
+			Conditions: []ConditionResult{sr},
+		})
+	}
+	return r
+}
@@ -0,0 +1,92 @@
+package plex
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigExplain(t *testing.T) {
+	cfg, err := NewConfig(strings.NewReader(`{
+		"triggers": [
+			{
+				"id": "living-room",
+				"name": "Dim the living room",
+				"properties": {
+					"event": {"$in": ["media.play", "media.resume"]},
+					"Player.title": "Living Room"
+				},
+				"not": {"Metadata.librarySectionType": "artist"},
+				"actions": [{"type": "webhook", "config": {"url": "http://localhost"}}]
+			},
+			{
+				"id": "den",
+				"properties": {"Player.title": "Den"},
+				"any": [{"event": "media.play"}, {"event": "media.stop"}],
+				"actions": [{"type": "webhook", "config": {"url": "http://localhost"}}]
+			},
+			{
+				"id": "night",
+				"properties": {"event": "media.play"},
+				"when": {"timezone": "UTC", "times": [{"after": "20:00", "before": "06:00"}]},
+				"actions": []
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	doc, err := ParseDocument([]byte(`{"event": "media.play", "Player": {"title": "Living Room"}, "Metadata": {"librarySectionType": "movie"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := cfg.Explain(doc, time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	if len(rs) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(rs))
+	}
+
+	// living-room matches on every condition
+	lr := rs[0]
+	if !lr.Matched || !lr.Fired || lr.Name != "Dim the living room" || len(lr.Actions) != 1 || lr.Actions[0] != "webhook" {
+		t.Errorf("Expected living-room to fire its webhook, got %+v", lr)
+	}
+	if len(lr.Conditions.Conditions) != 3 {
+		t.Fatalf("Expected 3 conditions for living-room, got %+v", lr.Conditions.Conditions)
+	}
+	for _, c := range lr.Conditions.Conditions {
+		if !c.Pass {
+			t.Errorf("Expected every living-room condition to pass, got %+v", c)
+		}
+	}
+	if c := lr.Conditions.Conditions[0]; c.Path != "Player.title" || c.Operator != "$eq" || c.Actual != "Living Room" || c.Expected != "Living Room" {
+		t.Errorf("Expected the Player.title condition to be explained, got %+v", c)
+	}
+	if c := lr.Conditions.Conditions[2]; c.Type != "not" || len(c.Conditions) != 1 || c.Conditions[0].Pass {
+		t.Errorf("Expected the not block to be explained, got %+v", c)
+	}
+
+	// den fails on the player, but its any block passes
+	den := rs[1]
+	if den.Matched || den.Fired {
+		t.Errorf("Expected den not to match, got %+v", den)
+	}
+	if c := den.Conditions.Conditions[0]; c.Pass || c.Actual != "Living Room" || c.Expected != "Den" {
+		t.Errorf("Expected the failing Player.title condition to be explained, got %+v", c)
+	}
+	if c := den.Conditions.Conditions[1]; c.Type != "any" || !c.Pass || len(c.Conditions) != 2 {
+		t.Errorf("Expected the any block to be fully explained, got %+v", c)
+	}
+
+	// night matches, but not at noon
+	night := rs[2]
+	if !night.Matched || night.Active || night.Fired {
+		t.Errorf("Expected night to match but be inactive, got %+v", night)
+	}
+
+	// Explanations agree with matching
+	for i, tr := range cfg.Triggers {
+		if tr.Matches(doc) != rs[i].Matched {
+			t.Errorf("Expected explanation of %s to agree with Matches", tr.ID)
+		}
+	}
+}
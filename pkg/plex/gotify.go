@@ -0,0 +1,136 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	RegisterAction("gotify", newGotifyAction)
+}
+
+// GotifyServer is a Gotify server that gotify actions send messages to.  Token is the token of the application the
+// messages are sent as.
+type GotifyServer struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// validate checks the server's settings
+func (g *GotifyServer) validate() error {
+	u, err := url.Parse(g.URL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https url")
+	}
+	if g.Token == "" {
+		return fmt.Errorf("missing token")
+	}
+	return nil
+}
+
+// GotifyAction sends a message to a Gotify server.  Title, Message and Click are templates rendered over the event
+// (see templateData); Message defaults to the message of chat actions (see ChatAction).  Priority is 0 to 10, and
+// the application's default when not set.
+//
+// Gotify cannot store images, so Thumb is either "link", which shows the poster saved with the webhook by its url on
+// Plexus' thumbs endpoint and requires the config's PublicURL, or "none".  It defaults to "link" when a PublicURL is
+// configured, and "none" otherwise.
+type GotifyAction struct {
+	Server   string `json:"server"`
+	Title    string `json:"title,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Priority *int   `json:"priority,omitempty"`
+	Click    string `json:"click,omitempty"`
+	Thumb    string `json:"thumb,omitempty"`
+
+	server    *GotifyServer
+	publicURL string
+	title     *Template
+	message   *Template
+	click     *Template
+}
+
+// newGotifyAction creates a GotifyAction from its configuration, compiling its templates and resolving its server
+func newGotifyAction(c ActionConfig) (Action, error) {
+	g := GotifyAction{}
+	if err := c.Decode(&g); err != nil {
+		return nil, err
+	}
+	s, ok := c.Root.Gotify[g.Server]
+	if !ok {
+		return nil, fmt.Errorf("unknown gotify server %q", g.Server)
+	}
+	g.server = s
+	if g.Priority != nil && (*g.Priority < 0 || *g.Priority > 10) {
+		return nil, fmt.Errorf("invalid gotify priority %d; must be between 0 and 10", *g.Priority)
+	}
+	g.publicURL = strings.TrimSuffix(c.Root.PublicURL, "/")
+	var err error
+	if g.Thumb, err = thumbSetting("gotify", g.Thumb, g.publicURL); err != nil {
+		return nil, err
+	}
+	err = compileTemplates("gotify",
+		templateField{"title", g.Title, &g.title},
+		templateField{"message", g.Message, &g.message},
+		templateField{"click", g.Click, &g.click})
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Execute renders the message and sends it.  The click url and the thumb are sent as the message's
+// client::notification extras, which Gotify's Android app shows.
+func (g GotifyAction) Execute(ctx context.Context, ev Event) error {
+	message := g.message
+	if message == nil {
+		message = chatDefaults(ev)[0]
+	}
+	msg := map[string]interface{}{}
+	var err error
+	if msg["message"], err = message.render(ev); err != nil {
+		return fmt.Errorf("could not render gotify message: %v", err)
+	}
+	if g.title != nil {
+		if msg["title"], err = g.title.render(ev); err != nil {
+			return fmt.Errorf("could not render gotify title: %v", err)
+		}
+	}
+	if g.Priority != nil {
+		msg["priority"] = *g.Priority
+	}
+	notification := map[string]interface{}{}
+	if g.click != nil {
+		click, err := g.click.render(ev)
+		if err != nil {
+			return fmt.Errorf("could not render gotify click: %v", err)
+		}
+		notification["click"] = map[string]string{"url": click}
+	}
+	if g.Thumb == "link" && ev.Activity.ThumbPath != "" {
+		notification["bigImageUrl"] = thumbLink(g.publicURL, ev)
+	}
+	if len(notification) > 0 {
+		msg["extras"] = map[string]interface{}{"client::notification": notification}
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(g.server.URL, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.server.Token)
+	ev.logger().Log("action", "gotify", "server", g.Server, "thumb", g.Thumb)
+	return sendRequest(ctx, req, nil)
+}
@@ -0,0 +1,71 @@
+package plex
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGotifyAction(t *testing.T) {
+	var got []interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/message" || r.Header.Get("X-Gotify-Key") != "app-t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var msg interface{}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("Could not decode message: %v", err)
+		}
+		got = append(got, msg)
+	}))
+	defer srv.Close()
+	st, cleanup := tempStore(t)
+	defer cleanup()
+
+	cfg, err := NewConfig(strings.NewReader(`{
+		"publicUrl": "https://plexus.example.com",
+		"gotify": {"home": {"url": "` + srv.URL + `", "token": "app-t0ken"}},
+		"triggers": [{"properties": {}, "actions": [
+			{"type": "gotify", "config": {"server": "home", "title": "{{.Payload.Player.Title}}", "priority": 0, "click": "https://app.plex.tv/desktop"}},
+			{"type": "gotify", "config": {"server": "home", "message": "{{.Payload.MediaTitle}}", "thumb": "none"}}
+		]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	for _, a := range cfg.Triggers[0].ParsedActions {
+		if err := a.Execute(context.Background(), chatEvent(t, st.dbPath)); err != nil {
+			t.Errorf("Unexpected error executing action: %v", err)
+		}
+	}
+	want := []interface{}{
+		jsonValue(t, `{"title": "Living Room TV", "message": "Alice started The Expanse S02E03 on Living Room TV", "priority": 0,
+			"extras": {"client::notification": {"click": {"url": "https://app.plex.tv/desktop"}, "bigImageUrl": "https://plexus.example.com/thumbs/abc123"}}}`),
+		jsonValue(t, `{"message": "The Expanse S02E03"}`),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected messages %v, got %v", want, got)
+	}
+}
+
+func TestNewConfigInvalidGotify(t *testing.T) {
+	gotify := `"gotify": {"home": {"url": "https://gotify.example.com", "token": "t"}}`
+	for _, config := range []string{
+		`{"gotify": {"home": {"url": "https://gotify.example.com"}}, "triggers": []}`,
+		`{"gotify": {"home": {"url": "gotify", "token": "t"}}, "triggers": []}`,
+		`{"gotify": {"home": null}, "triggers": []}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "gotify", "config": {"server": "home"}}]}]}`,
+		`{` + gotify + `, "triggers": [{"properties": {}, "actions": [{"type": "gotify", "config": {"server": "home", "priority": 11}}]}]}`,
+		`{` + gotify + `, "triggers": [{"properties": {}, "actions": [{"type": "gotify", "config": {"server": "home", "thumb": "attach"}}]}]}`,
+		`{` + gotify + `, "triggers": [{"properties": {}, "actions": [{"type": "gotify", "config": {"server": "home", "title": "{{"}}]}]}`,
+	} {
+		if _, err := NewConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("Expected an error loading %s, got none", config)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	RegisterAction("homeassistant", newHomeAssistantAction)
+}
+
+// HomeAssistant is a Home Assistant instance that homeassistant actions call.  Token is a long-lived access token.
+type HomeAssistant struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// validate checks the instance's settings
+func (h *HomeAssistant) validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https url")
+	}
+	if h.Token == "" {
+		return fmt.Errorf("missing token")
+	}
+	return nil
+}
+
+// HomeAssistantAction calls a Home Assistant service, such as light.turn_on, or fires a Home Assistant event.
+// EntityID and the strings in Data are templates rendered over the event (see templateData); EntityID is sent as the
+// entity_id of the service data.
+type HomeAssistantAction struct {
+	Instance string                 `json:"instance"`
+	Service  string                 `json:"service,omitempty"`
+	Event    string                 `json:"event,omitempty"`
+	EntityID string                 `json:"entityId,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+
+	ha       *HomeAssistant
+	path     string
+	entityID *Template
+	data     templateValue
+}
+
+// newHomeAssistantAction creates a HomeAssistantAction from its configuration, compiling its templates and resolving
+// its instance
+func newHomeAssistantAction(c ActionConfig) (Action, error) {
+	h := HomeAssistantAction{}
+	if err := c.Decode(&h); err != nil {
+		return nil, err
+	}
+	ha, ok := c.Root.HomeAssistant[h.Instance]
+	if !ok {
+		return nil, fmt.Errorf("unknown homeassistant instance %q", h.Instance)
+	}
+	h.ha = ha
+	switch {
+	case h.Service != "" && h.Event != "":
+		return nil, fmt.Errorf("invalid homeassistant action specified; set either a service or an event, not both")
+	case h.Service != "":
+		parts := strings.Split(h.Service, ".")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid homeassistant action specified; service %q is not domain.service", h.Service)
+		}
+		h.path = "/api/services/" + url.PathEscape(parts[0]) + "/" + url.PathEscape(parts[1])
+	case h.Event != "":
+		h.path = "/api/events/" + url.PathEscape(h.Event)
+	default:
+		return nil, fmt.Errorf("invalid homeassistant action specified; missing service or event")
+	}
+	var err error
+	if h.EntityID != "" {
+		if h.entityID, err = compileTemplate("entityId", h.EntityID); err != nil {
+			return nil, fmt.Errorf("invalid homeassistant entityId template: %v", err)
+		}
+	}
+	data := map[string]interface{}{}
+	for k, v := range h.Data {
+		data[k] = v
+	}
+	if h.data, err = compileTemplateValue("data", data); err != nil {
+		return nil, fmt.Errorf("invalid homeassistant data template: %v", err)
+	}
+	return h, nil
+}
+
+// Execute calls the service or fires the event
+func (h HomeAssistantAction) Execute(ctx context.Context, ev Event) error {
+	v, err := h.data.render(ev)
+	if err != nil {
+		return fmt.Errorf("could not render homeassistant data: %v", err)
+	}
+	data := v.(map[string]interface{})
+	if h.entityID != nil {
+		id, err := h.entityID.render(ev)
+		if err != nil {
+			return fmt.Errorf("could not render homeassistant entityId: %v", err)
+		}
+		data["entity_id"] = id
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(h.ha.URL, "/")+h.path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.ha.Token)
+	req.Header.Set("Content-Type", "application/json")
+	if h.Service != "" {
+		ev.logger().Log("action", "homeassistant", "msg", "calling service", "instance", h.Instance, "service", h.Service, "entity_id", data["entity_id"])
+	} else {
+		ev.logger().Log("action", "homeassistant", "msg", "firing event", "instance", h.Instance, "event", h.Event)
+	}
+	return sendRequest(ctx, req, nil)
+}
@@ -0,0 +1,108 @@
+package plex
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// haRequest is a request received by the Home Assistant stand-in
+type haRequest struct {
+	path  string
+	token string
+	body  map[string]interface{}
+}
+
+func TestHomeAssistantAction(t *testing.T) {
+	var got []haRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hr := haRequest{path: r.URL.Path, token: r.Header.Get("Authorization")}
+		if err := json.NewDecoder(r.Body).Decode(&hr.body); err != nil {
+			t.Errorf("Could not decode request body: %v", err)
+		}
+		got = append(got, hr)
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	cfg, err := NewConfig(strings.NewReader(`{
+		"homeassistant": {"home": {"url": "` + srv.URL + `/", "token": "l0ng-lived"}},
+		"triggers": [{"properties": {}, "actions": [
+			{"type": "homeassistant", "config": {
+				"instance": "home",
+				"service": "light.turn_on",
+				"entityId": "light.{{.Payload.Player.Title | lower | replace \" \" \"_\"}}",
+				"data": {"brightness_pct": 20, "transition": 2, "flash": false, "title": "{{.Payload.Metadata.Title}}"}
+			}},
+			{"type": "homeassistant", "config": {
+				"instance": "home",
+				"event": "plexus_event",
+				"data": {"event": "{{.Payload.Event}}", "tags": ["{{.Trigger}}", 1]}
+			}}
+		]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	ev := templateEvent(t)
+	for _, a := range cfg.Triggers[0].ParsedActions {
+		if err := a.Execute(context.Background(), ev); err != nil {
+			t.Errorf("Unexpected error executing action: %v", err)
+		}
+	}
+	want := []haRequest{
+		{
+			path:  "/api/services/light/turn_on",
+			token: "Bearer l0ng-lived",
+			body:  map[string]interface{}{"entity_id": "light.living_room", "brightness_pct": 20.0, "transition": 2.0, "flash": false, "title": "Alien"},
+		},
+		{
+			path:  "/api/events/plexus_event",
+			token: "Bearer l0ng-lived",
+			body:  map[string]interface{}{"event": "media.play", "tags": []interface{}{"living-room", 1.0}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected requests %+v, got %+v", want, got)
+	}
+}
+
+func TestHomeAssistantActionStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	cfg, err := NewConfig(strings.NewReader(`{
+		"homeassistant": {"home": {"url": "` + srv.URL + `", "token": "expired"}},
+		"triggers": [{"properties": {}, "actions": [{"type": "homeassistant", "config": {"instance": "home", "service": "scene.turn_on"}}]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	err = cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t))
+	if se, ok := err.(StatusError); !ok || se.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a 401 StatusError, got: %v", err)
+	}
+}
+
+func TestNewConfigInvalidHomeAssistant(t *testing.T) {
+	ha := `"homeassistant": {"home": {"url": "http://ha.local:8123", "token": "t"}}`
+	for _, config := range []string{
+		`{"homeassistant": {"home": {"url": "ha.local", "token": "t"}}, "triggers": []}`,
+		`{"homeassistant": {"home": {"url": "http://ha.local:8123"}}, "triggers": []}`,
+		`{"homeassistant": {"home": null}, "triggers": []}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "homeassistant", "config": {"instance": "home", "service": "light.turn_on"}}]}]}`,
+		`{` + ha + `, "triggers": [{"properties": {}, "actions": [{"type": "homeassistant", "config": {"instance": "home"}}]}]}`,
+		`{` + ha + `, "triggers": [{"properties": {}, "actions": [{"type": "homeassistant", "config": {"instance": "home", "service": "turn_on"}}]}]}`,
+		`{` + ha + `, "triggers": [{"properties": {}, "actions": [{"type": "homeassistant", "config": {"instance": "home", "service": "light.turn_on", "event": "e"}}]}]}`,
+		`{` + ha + `, "triggers": [{"properties": {}, "actions": [{"type": "homeassistant", "config": {"instance": "home", "service": "light.turn_on", "data": {"a": "{{"}}}}]}]}`,
+	} {
+		if _, err := NewConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("Expected an error loading %s, got none", config)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterAction("hue", newHueAction)
+}
+
+// HueBridge is a Philips Hue bridge that hue actions control through its local REST API.  Address is the bridge's
+// host name or IP address, or an http(s) url; Key is an app key obtained by pairing with the bridge (see
+// PairHueBridge).
+type HueBridge struct {
+	Address string `json:"address"`
+	Key     string `json:"key"`
+}
+
+// validate checks the bridge's settings
+func (b *HueBridge) validate() error {
+	if _, err := hueBaseURL(b.Address); err != nil {
+		return err
+	}
+	if b.Key == "" {
+		return fmt.Errorf("missing key")
+	}
+	return nil
+}
+
+// hueBaseURL returns the url of the API of the bridge at the given address
+func hueBaseURL(address string) (string, error) {
+	if address == "" {
+		return "", fmt.Errorf("missing address")
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid address %q", address)
+	}
+	return strings.TrimSuffix(u.String(), "/") + "/api", nil
+}
+
+// HueError is an error reported by a Hue bridge
+type HueError struct {
+	Type        int    `json:"type"`
+	Address     string `json:"address"`
+	Description string `json:"description"`
+}
+
+func (e HueError) Error() string {
+	return fmt.Sprintf("hue bridge error %d at %s: %s", e.Type, e.Address, e.Description)
+}
+
+// hueLinkButtonNotPressed is the HueError type returned while pairing, until the bridge's link button is pressed
+const hueLinkButtonNotPressed = 101
+
+// hueResult is an element of the array that the bridge responds with, which holds either a success or an error
+type hueResult struct {
+	Success map[string]interface{} `json:"success"`
+	Error   *HueError              `json:"error"`
+}
+
+// hueRequest sends a request to the bridge API and returns its results, or the first error it reported
+func hueRequest(ctx context.Context, method, u string, body interface{}) ([]hueResult, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var res []hueResult
+	if err := sendRequest(ctx, req, &res); err != nil {
+		return nil, err
+	}
+	for _, r := range res {
+		if r.Error != nil {
+			return res, *r.Error
+		}
+	}
+	return res, nil
+}
+
+// PairHueBridge creates an app key on the bridge at the given address, identifying the app by deviceType (such as
+// "plexus#hostname").  The bridge's link button must have been pressed within the last 30 seconds; until it is,
+// PairHueBridge returns a HueError of type 101.
+func PairHueBridge(ctx context.Context, address, deviceType string) (string, error) {
+	base, err := hueBaseURL(address)
+	if err != nil {
+		return "", err
+	}
+	res, err := hueRequest(ctx, "POST", base, map[string]string{"devicetype": deviceType})
+	if err != nil {
+		return "", err
+	}
+	for _, r := range res {
+		if key, ok := r.Success["username"].(string); ok {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("hue bridge did not return a key")
+}
+
+// IsHueLinkButtonError returns whether err is the error a bridge reports when pairing before its link button is
+// pressed
+func IsHueLinkButtonError(err error) bool {
+	he, ok := err.(HueError)
+	return ok && he.Type == hueLinkButtonNotPressed
+}
+
+// HueAction sets the state of a light or a group of lights on a Hue bridge, or recalls a scene.  Brightness is 1-254
+// and ColorTemp is in mireds (153-500, i.e. 6500K to 2000K).  A scene is recalled in Group, or in every group when it
+// is empty; Transition applies to scenes too.
+type HueAction struct {
+	Bridge     string `json:"bridge"`
+	Light      string `json:"light,omitempty"`
+	Group      string `json:"group,omitempty"`
+	Scene      string `json:"scene,omitempty"`
+	On         *bool  `json:"on,omitempty"`
+	Brightness *int   `json:"brightness,omitempty"`
+	ColorTemp  *int   `json:"colorTemp,omitempty"`
+	Transition string `json:"transition,omitempty"`
+
+	bridge *HueBridge
+	path   string
+	state  map[string]interface{}
+}
+
+// newHueAction creates a HueAction from its configuration, resolving its bridge and building the state it sets
+func newHueAction(c ActionConfig) (Action, error) {
+	h := HueAction{}
+	if err := c.Decode(&h); err != nil {
+		return nil, err
+	}
+	b, ok := c.Root.Hue[h.Bridge]
+	if !ok {
+		return nil, fmt.Errorf("unknown hue bridge %q", h.Bridge)
+	}
+	h.bridge = b
+	h.state = map[string]interface{}{}
+	if h.Scene != "" {
+		if h.Light != "" || h.On != nil || h.Brightness != nil || h.ColorTemp != nil {
+			return nil, fmt.Errorf("invalid hue action specified; a scene can only be recalled in a group, with a transition")
+		}
+		group := h.Group
+		if group == "" {
+			group = "0"
+		}
+		h.path = "/groups/" + url.PathEscape(group) + "/action"
+		h.state["scene"] = h.Scene
+	} else {
+		switch {
+		case h.Light != "" && h.Group != "":
+			return nil, fmt.Errorf("invalid hue action specified; set either a light or a group, not both")
+		case h.Light != "":
+			h.path = "/lights/" + url.PathEscape(h.Light) + "/state"
+		case h.Group != "":
+			h.path = "/groups/" + url.PathEscape(h.Group) + "/action"
+		default:
+			return nil, fmt.Errorf("invalid hue action specified; missing light, group or scene")
+		}
+		if h.On == nil && h.Brightness == nil && h.ColorTemp == nil {
+			return nil, fmt.Errorf("invalid hue action specified; missing on, brightness or colorTemp")
+		}
+	}
+	if h.On != nil {
+		h.state["on"] = *h.On
+	}
+	if h.Brightness != nil {
+		if *h.Brightness < 1 || *h.Brightness > 254 {
+			return nil, fmt.Errorf("invalid hue brightness %d; must be between 1 and 254", *h.Brightness)
+		}
+		h.state["bri"] = *h.Brightness
+	}
+	if h.ColorTemp != nil {
+		if *h.ColorTemp < 153 || *h.ColorTemp > 500 {
+			return nil, fmt.Errorf("invalid hue colorTemp %d; must be between 153 and 500", *h.ColorTemp)
+		}
+		h.state["ct"] = *h.ColorTemp
+	}
+	d, err := parsePositiveDuration("transition", h.Transition)
+	if err != nil {
+		return nil, err
+	}
+	if h.Transition != "" {
+		// The bridge counts transitions in multiples of 100ms
+		h.state["transitiontime"] = int(d / (100 * time.Millisecond))
+	}
+	return h, nil
+}
+
+// Execute sends the state to the bridge
+func (h HueAction) Execute(ctx context.Context, ev Event) error {
+	base, err := hueBaseURL(h.bridge.Address)
+	if err != nil {
+		return err
+	}
+	ev.logger().Log("action", "hue", "bridge", h.Bridge, "path", h.path, "state", fmt.Sprint(h.state))
+	_, err = hueRequest(ctx, "PUT", base+"/"+url.PathEscape(h.bridge.Key)+h.path, h.state)
+	return redactError(err, h.bridge.Key)
+}
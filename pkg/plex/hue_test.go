@@ -0,0 +1,152 @@
+package plex
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// hueBridgeRequest is a request received by the Hue bridge stand-in
+type hueBridgeRequest struct {
+	method string
+	path   string
+	body   map[string]interface{}
+}
+
+// newHueBridge starts a Hue bridge stand-in that records the requests it receives and responds with respond's result
+func newHueBridge(t *testing.T, got *[]hueBridgeRequest, respond func(r hueBridgeRequest) interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		br := hueBridgeRequest{method: r.Method, path: r.URL.Path}
+		if err := json.NewDecoder(r.Body).Decode(&br.body); err != nil {
+			t.Errorf("Could not decode request body: %v", err)
+		}
+		*got = append(*got, br)
+		json.NewEncoder(w).Encode(respond(br))
+	}))
+}
+
+func TestHueAction(t *testing.T) {
+	var got []hueBridgeRequest
+	srv := newHueBridge(t, &got, func(r hueBridgeRequest) interface{} {
+		return []interface{}{map[string]interface{}{"success": map[string]interface{}{r.path: true}}}
+	})
+	defer srv.Close()
+
+	cfg, err := NewConfig(strings.NewReader(`{
+		"hue": {"living-room": {"address": "` + strings.TrimPrefix(srv.URL, "http://") + `", "key": "s3cret"}},
+		"triggers": [{"properties": {}, "actions": [
+			{"type": "hue", "config": {"bridge": "living-room", "light": "3", "on": true, "brightness": 40, "colorTemp": 400, "transition": "2s"}},
+			{"type": "hue", "config": {"bridge": "living-room", "group": "1", "on": false}},
+			{"type": "hue", "config": {"bridge": "living-room", "scene": "AbCdEf", "transition": "500ms"}}
+		]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	ev := templateEvent(t)
+	for _, a := range cfg.Triggers[0].ParsedActions {
+		if err := a.Execute(context.Background(), ev); err != nil {
+			t.Errorf("Unexpected error executing action: %v", err)
+		}
+	}
+	want := []hueBridgeRequest{
+		{method: "PUT", path: "/api/s3cret/lights/3/state", body: map[string]interface{}{"on": true, "bri": 40.0, "ct": 400.0, "transitiontime": 20.0}},
+		{method: "PUT", path: "/api/s3cret/groups/1/action", body: map[string]interface{}{"on": false}},
+		{method: "PUT", path: "/api/s3cret/groups/0/action", body: map[string]interface{}{"scene": "AbCdEf", "transitiontime": 5.0}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected requests %+v, got %+v", want, got)
+	}
+}
+
+func TestHueActionError(t *testing.T) {
+	var got []hueBridgeRequest
+	srv := newHueBridge(t, &got, func(r hueBridgeRequest) interface{} {
+		return []interface{}{map[string]interface{}{"error": map[string]interface{}{"type": 1, "address": "/lights/3/state", "description": "unauthorized user"}}}
+	})
+	defer srv.Close()
+	cfg, err := NewConfig(strings.NewReader(`{
+		"hue": {"living-room": {"address": "` + srv.URL + `", "key": "expired"}},
+		"triggers": [{"properties": {}, "actions": [{"type": "hue", "config": {"bridge": "living-room", "light": "3", "on": true}}]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	err = cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t))
+	if he, ok := err.(HueError); !ok || he.Type != 1 {
+		t.Errorf("Expected an unauthorized HueError, got: %v", err)
+	}
+}
+
+func TestHueActionRedactsKey(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	cfg, err := NewConfig(strings.NewReader(`{
+		"hue": {"living-room": {"address": "` + srv.URL + `", "key": "s3cret"}},
+		"triggers": [{"properties": {}, "actions": [{"type": "hue", "config": {"bridge": "living-room", "light": "3", "on": true}}]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	err = cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t))
+	if err == nil {
+		t.Fatal("Expected an error executing the action against a closed bridge")
+	}
+	if strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("Expected the key to be redacted from the error: %v", err)
+	}
+}
+
+func TestPairHueBridge(t *testing.T) {
+	pressed := false
+	var got []hueBridgeRequest
+	srv := newHueBridge(t, &got, func(r hueBridgeRequest) interface{} {
+		if !pressed {
+			return []interface{}{map[string]interface{}{"error": map[string]interface{}{"type": 101, "address": "", "description": "link button not pressed"}}}
+		}
+		return []interface{}{map[string]interface{}{"success": map[string]interface{}{"username": "n3wkey"}}}
+	})
+	defer srv.Close()
+
+	_, err := PairHueBridge(context.Background(), srv.URL, "plexus#test")
+	if !IsHueLinkButtonError(err) {
+		t.Errorf("Expected a link button error, got: %v", err)
+	}
+	pressed = true
+	key, err := PairHueBridge(context.Background(), srv.URL, "plexus#test")
+	if err != nil {
+		t.Fatalf("Unexpected error pairing: %v", err)
+	}
+	if key != "n3wkey" {
+		t.Errorf("Expected key n3wkey, got %q", key)
+	}
+	want := hueBridgeRequest{method: "POST", path: "/api", body: map[string]interface{}{"devicetype": "plexus#test"}}
+	if len(got) != 2 || !reflect.DeepEqual(got[1], want) {
+		t.Errorf("Expected pairing request %+v, got %+v", want, got)
+	}
+}
+
+func TestNewConfigInvalidHue(t *testing.T) {
+	hue := `"hue": {"bridge": {"address": "192.168.1.2", "key": "k"}}`
+	for _, config := range []string{
+		`{"hue": {"bridge": {"address": "192.168.1.2"}}, "triggers": []}`,
+		`{"hue": {"bridge": {"address": "ftp://192.168.1.2", "key": "k"}}, "triggers": []}`,
+		`{"hue": {"bridge": null}, "triggers": []}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "hue", "config": {"bridge": "bridge", "light": "1", "on": true}}]}]}`,
+		`{` + hue + `, "triggers": [{"properties": {}, "actions": [{"type": "hue", "config": {"bridge": "bridge", "on": true}}]}]}`,
+		`{` + hue + `, "triggers": [{"properties": {}, "actions": [{"type": "hue", "config": {"bridge": "bridge", "light": "1"}}]}]}`,
+		`{` + hue + `, "triggers": [{"properties": {}, "actions": [{"type": "hue", "config": {"bridge": "bridge", "light": "1", "group": "2", "on": true}}]}]}`,
+		`{` + hue + `, "triggers": [{"properties": {}, "actions": [{"type": "hue", "config": {"bridge": "bridge", "light": "1", "brightness": 255}}]}]}`,
+		`{` + hue + `, "triggers": [{"properties": {}, "actions": [{"type": "hue", "config": {"bridge": "bridge", "light": "1", "colorTemp": 100}}]}]}`,
+		`{` + hue + `, "triggers": [{"properties": {}, "actions": [{"type": "hue", "config": {"bridge": "bridge", "light": "1", "on": true, "transition": "soon"}}]}]}`,
+		`{` + hue + `, "triggers": [{"properties": {}, "actions": [{"type": "hue", "config": {"bridge": "bridge", "scene": "s", "on": true}}]}]}`,
+	} {
+		if _, err := NewConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("Expected an error loading %s, got none", config)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+func init() {
+	RegisterAction("ifttt", newIFTTTAction)
+}
+
+// IFTTT holds the settings of the IFTTT Maker webhooks service.  Key is the key shown in the service's settings.
+type IFTTT struct {
+	Key string `json:"key"`
+}
+
+// iftttURL is the url of the IFTTT Maker webhooks service
+var iftttURL = "https://maker.ifttt.com"
+
+// IFTTTAction triggers an IFTTT Maker webhooks event, passing up to three values to the applet.  Event and the values
+// are templates rendered over the event (see templateData).
+type IFTTTAction struct {
+	Event  string `json:"event"`
+	Value1 string `json:"value1,omitempty"`
+	Value2 string `json:"value2,omitempty"`
+	Value3 string `json:"value3,omitempty"`
+
+	key    string
+	event  *Template
+	values [3]*Template
+}
+
+// newIFTTTAction creates an IFTTTAction from its configuration, compiling its templates
+func newIFTTTAction(c ActionConfig) (Action, error) {
+	a := IFTTTAction{}
+	if err := c.Decode(&a); err != nil {
+		return nil, err
+	}
+	if c.Root.IFTTT == nil || c.Root.IFTTT.Key == "" {
+		return nil, fmt.Errorf("invalid ifttt action specified; missing ifttt key in config")
+	}
+	a.key = c.Root.IFTTT.Key
+	if a.Event == "" {
+		return nil, fmt.Errorf("invalid ifttt action specified; missing event")
+	}
+	var err error
+	if a.event, err = compileTemplate("event", a.Event); err != nil {
+		return nil, fmt.Errorf("invalid ifttt event template: %v", err)
+	}
+	for i, v := range []string{a.Value1, a.Value2, a.Value3} {
+		if v == "" {
+			continue
+		}
+		name := fmt.Sprintf("value%d", i+1)
+		if a.values[i], err = compileTemplate(name, v); err != nil {
+			return nil, fmt.Errorf("invalid ifttt %s template: %v", name, err)
+		}
+	}
+	return a, nil
+}
+
+// Execute renders the event name and values and triggers the event
+func (a IFTTTAction) Execute(ctx context.Context, ev Event) error {
+	event, err := a.event.render(ev)
+	if err != nil {
+		return fmt.Errorf("could not render ifttt event: %v", err)
+	}
+	values := map[string]string{}
+	for i, t := range a.values {
+		if t == nil {
+			continue
+		}
+		name := fmt.Sprintf("value%d", i+1)
+		if values[name], err = t.render(ev); err != nil {
+			return fmt.Errorf("could not render ifttt %s: %v", name, err)
+		}
+	}
+	body, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	u := iftttURL + "/trigger/" + url.PathEscape(event) + "/with/key/" + url.PathEscape(a.key)
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	ev.logger().Log("action", "ifttt", "event", event)
+	return redactError(sendRequest(ctx, req, nil), a.key)
+}
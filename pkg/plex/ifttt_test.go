@@ -0,0 +1,80 @@
+package plex
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIFTTTAction(t *testing.T) {
+	var path string
+	var body map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Could not decode request body: %v", err)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { iftttURL = u }(iftttURL)
+	iftttURL = srv.URL
+
+	cfg, err := NewConfig(strings.NewReader(`{
+		"ifttt": {"key": "mak3r"},
+		"triggers": [{"properties": {}, "actions": [
+			{"type": "ifttt", "config": {"event": "plex_{{.Payload.Event | replace \".\" \"_\"}}", "value1": "{{.Payload.Metadata.Title}}", "value3": "{{.Trigger}}"}}
+		]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	if err := cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t)); err != nil {
+		t.Fatalf("Unexpected error executing action: %v", err)
+	}
+	if path != "/trigger/plex_media_play/with/key/mak3r" {
+		t.Errorf("Unexpected request path %q", path)
+	}
+	want := map[string]string{"value1": "Alien", "value3": "living-room"}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("Expected body %v, got %v", want, body)
+	}
+}
+
+func TestIFTTTActionRedactsKey(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	defer func(u string) { iftttURL = u }(iftttURL)
+	iftttURL = srv.URL
+
+	cfg, err := NewConfig(strings.NewReader(`{
+		"ifttt": {"key": "mak3r"},
+		"triggers": [{"properties": {}, "actions": [{"type": "ifttt", "config": {"event": "plex"}}]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	err = cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t))
+	if err == nil {
+		t.Fatal("Expected an error executing the action against a closed server")
+	}
+	if strings.Contains(err.Error(), "mak3r") {
+		t.Errorf("Expected the key to be redacted from the error: %v", err)
+	}
+}
+
+func TestNewConfigInvalidIFTTT(t *testing.T) {
+	for _, config := range []string{
+		`{"triggers": [{"properties": {}, "actions": [{"type": "ifttt", "config": {"event": "e"}}]}]}`,
+		`{"ifttt": {"key": "k"}, "triggers": [{"properties": {}, "actions": [{"type": "ifttt", "config": {}}]}]}`,
+		`{"ifttt": {"key": "k"}, "triggers": [{"properties": {}, "actions": [{"type": "ifttt", "config": {"event": "e", "value2": "{{"}}]}]}`,
+		`{"ifttt": {"key": "k"}, "triggers": [{"properties": {}, "actions": [{"type": "ifttt", "config": {"event": "e", "value4": "v"}}]}]}`,
+	} {
+		if _, err := NewConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("Expected an error loading %s, got none", config)
+		}
+	}
+}
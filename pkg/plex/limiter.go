@@ -0,0 +1,210 @@
+package plex
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limit controls how often a Trigger's actions may run.  Limits are tracked separately for every distinct
+// combination of the values found at the Key paths, so that e.g. {"key": ["Player.uuid"]} limits each player
+// independently.
+//
+// Cooldown suppresses matches for a period after the actions run.  Throttle allows the actions to run at most Count
+// times Per period.  Debounce delays the actions until the trigger has stopped matching for the given period, then
+// runs them once with the last matching event.
+type Limit struct {
+	Key      []string  `json:"key,omitempty"`
+	Cooldown string    `json:"cooldown,omitempty"`
+	Debounce string    `json:"debounce,omitempty"`
+	Throttle *Throttle `json:"throttle,omitempty"`
+}
+
+// Throttle allows at most Count executions in any Per period
+type Throttle struct {
+	Count int    `json:"count"`
+	Per   string `json:"per"`
+}
+
+// limitPolicy is a compiled Limit
+type limitPolicy struct {
+	key      []propertyPath
+	cooldown time.Duration
+	debounce time.Duration
+	count    int
+	per      time.Duration
+}
+
+func (l Limit) compile() (*limitPolicy, error) {
+	p := limitPolicy{}
+	var err error
+	if p.key, err = parsePaths(l.Key); err != nil {
+		return nil, err
+	}
+	if p.cooldown, err = parsePositiveDuration("cooldown", l.Cooldown); err != nil {
+		return nil, err
+	}
+	if p.debounce, err = parsePositiveDuration("debounce", l.Debounce); err != nil {
+		return nil, err
+	}
+	if l.Throttle != nil {
+		if l.Throttle.Count < 1 {
+			return nil, fmt.Errorf("throttle count must be at least 1")
+		}
+		p.count = l.Throttle.Count
+		if p.per, err = parsePositiveDuration("throttle per", l.Throttle.Per); err != nil {
+			return nil, err
+		}
+		if p.per == 0 {
+			return nil, fmt.Errorf("throttle must specify a period")
+		}
+	}
+	return &p, nil
+}
+
+func parsePositiveDuration(name, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", name, s, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must not be negative", name, s)
+	}
+	return d, nil
+}
+
+// stateKey identifies the limit state for the given payload document
+func (p *limitPolicy) stateKey(trigger string, doc interface{}) string {
+	if len(p.key) == 0 {
+		return trigger
+	}
+	return trigger + "|" + docKey(p.key, doc)
+}
+
+// docKey renders the values found at the given paths of a payload document as a string, for grouping events
+func docKey(paths []propertyPath, doc interface{}) string {
+	keys := make([]interface{}, 0, len(paths))
+	for _, p := range paths {
+		vals, multi := p.resolve(doc)
+		switch {
+		case multi:
+			keys = append(keys, vals)
+		case len(vals) == 1:
+			keys = append(keys, vals[0])
+		default:
+			keys = append(keys, nil)
+		}
+	}
+	b, _ := json.Marshal(keys)
+	return string(b)
+}
+
+// parsePaths compiles a list of property paths
+func parsePaths(ss []string) ([]propertyPath, error) {
+	paths := make([]propertyPath, 0, len(ss))
+	for _, s := range ss {
+		p, err := parsePath(s)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// Limiter holds the state used to enforce trigger Limits.  The state of a key is dropped once its cooldown, throttle
+// period and debounce have all passed.  It is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	states map[string]*limitState
+}
+
+type limitState struct {
+	last     time.Time
+	fired    []time.Time
+	debounce Timer
+	// expires is when the state no longer affects the policy, unless a debounce is pending
+	expires time.Time
+}
+
+// NewLimiter creates an empty Limiter
+func NewLimiter() *Limiter {
+	return &Limiter{
+		states: map[string]*limitState{},
+	}
+}
+
+// allow determines if actions may run now under the cooldown and throttle settings of the policy, and records the
+// execution when they may.
+func (l *Limiter) allow(key string, p *limitPolicy, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.evict(now)
+	s := l.state(key)
+	if p.cooldown > 0 && !s.last.IsZero() && now.Sub(s.last) < p.cooldown {
+		return false
+	}
+	if p.count > 0 {
+		// Forget executions that have left the window
+		kept := s.fired[:0]
+		for _, f := range s.fired {
+			if now.Sub(f) < p.per {
+				kept = append(kept, f)
+			}
+		}
+		s.fired = kept
+		if len(s.fired) >= p.count {
+			return false
+		}
+		s.fired = append(s.fired, now)
+	}
+	s.last = now
+	s.expires = now.Add(p.cooldown)
+	if p.per > p.cooldown {
+		s.expires = now.Add(p.per)
+	}
+	return true
+}
+
+// debounce (re)schedules f to run once the policy's debounce period passes without another call for the same key.
+// It reports whether an earlier call was superseded.
+func (l *Limiter) debounce(key string, p *limitPolicy, clock Clock, f func()) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.evict(clock.Now())
+	s := l.state(key)
+	superseded := s.debounce != nil && s.debounce.Stop()
+	var t Timer
+	t = clock.AfterFunc(p.debounce, func() {
+		l.mu.Lock()
+		if s.debounce == t {
+			s.debounce = nil
+		}
+		l.mu.Unlock()
+		f()
+	})
+	s.debounce = t
+	return superseded
+}
+
+// evict drops the states that no longer affect their policies
+func (l *Limiter) evict(now time.Time) {
+	for key, s := range l.states {
+		if s.debounce == nil && !now.Before(s.expires) {
+			delete(l.states, key)
+		}
+	}
+}
+
+func (l *Limiter) state(key string) *limitState {
+	s, ok := l.states[key]
+	if !ok {
+		s = &limitState{}
+		l.states[key] = s
+	}
+	return s
+}
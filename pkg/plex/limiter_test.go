@@ -0,0 +1,172 @@
+package plex
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// limitedConfig loads a config with a single media.pause trigger limited as given, whose executions are recorded
+func limitedConfig(t *testing.T, limit string, clock Clock, payloads *[]WebhookPayload) Config {
+	cfg, err := NewConfig(strings.NewReader(`{
+		"triggers": [
+			{
+				"properties": {"event": "media.pause"},
+				"limit": ` + limit + `,
+				"actions": []
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	cfg.Clock = clock
+	cfg.Triggers[0].ParsedActions = []Action{recordingAction{payloads: payloads}}
+	return cfg
+}
+
+// pause sends a media.pause event from the given player through the config
+func pause(t *testing.T, cfg Config, clock Clock, player string) {
+	raw := []byte(`{"event": "media.pause", "Player": {"uuid": "` + player + `"}}`)
+	doc, err := ParseDocument(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	act := Activity{ReceivedAt: clock.Now()}
+	act.Payload.Player.UUID = player
+	if err := cfg.Handle(log.NewNopLogger(), act, doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigHandleCooldown(t *testing.T) {
+	var payloads []WebhookPayload
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	cfg := limitedConfig(t, `{"cooldown": "1m", "key": ["Player.uuid"]}`, clock, &payloads)
+
+	pause(t, cfg, clock, "a")
+	clock.Advance(10 * time.Second)
+	pause(t, cfg, clock, "a")
+	pause(t, cfg, clock, "b")
+	if len(payloads) != 2 {
+		t.Fatalf("Expected 2 executions during cooldown (one per player), got %d", len(payloads))
+	}
+	clock.Advance(time.Minute)
+	pause(t, cfg, clock, "a")
+	if len(payloads) != 3 {
+		t.Fatalf("Expected 3 executions after cooldown, got %d", len(payloads))
+	}
+}
+
+func TestConfigHandleThrottle(t *testing.T) {
+	var payloads []WebhookPayload
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	cfg := limitedConfig(t, `{"throttle": {"count": 2, "per": "1m"}}`, clock, &payloads)
+
+	for i := 0; i < 5; i++ {
+		pause(t, cfg, clock, "a")
+		clock.Advance(10 * time.Second)
+	}
+	if len(payloads) != 2 {
+		t.Fatalf("Expected 2 executions within the throttle period, got %d", len(payloads))
+	}
+	clock.Advance(20 * time.Second)
+	pause(t, cfg, clock, "a")
+	if len(payloads) != 3 {
+		t.Fatalf("Expected 3 executions once the first left the throttle period, got %d", len(payloads))
+	}
+}
+
+func TestConfigHandleDebounce(t *testing.T) {
+	var payloads []WebhookPayload
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	cfg := limitedConfig(t, `{"debounce": "5s", "key": ["Player.uuid"]}`, clock, &payloads)
+
+	pause(t, cfg, clock, "a")
+	clock.Advance(2 * time.Second)
+	pause(t, cfg, clock, "b")
+	clock.Advance(2 * time.Second)
+	pause(t, cfg, clock, "a")
+	if len(payloads) != 0 {
+		t.Fatalf("Expected no executions while debouncing, got %d", len(payloads))
+	}
+	clock.Advance(3 * time.Second)
+	if len(payloads) != 1 || payloads[0].Player.UUID != "b" {
+		t.Fatalf("Expected player b to fire once its quiet period passed, got %+v", payloads)
+	}
+	clock.Advance(2 * time.Second)
+	if len(payloads) != 2 || payloads[1].Player.UUID != "a" {
+		t.Fatalf("Expected player a to fire once its quiet period passed, got %+v", payloads)
+	}
+	clock.Advance(time.Minute)
+	if len(payloads) != 2 {
+		t.Fatalf("Expected exactly 2 executions, got %d", len(payloads))
+	}
+}
+
+func TestLimiterEvict(t *testing.T) {
+	var payloads []WebhookPayload
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	cfg := limitedConfig(t, `{"cooldown": "1m", "throttle": {"count": 2, "per": "5m"}, "key": ["Player.uuid"]}`, clock, &payloads)
+
+	pause(t, cfg, clock, "a")
+	pause(t, cfg, clock, "b")
+	clock.Advance(4 * time.Minute)
+	pause(t, cfg, clock, "c")
+	if n := len(cfg.Limiter.states); n != 3 {
+		t.Fatalf("Expected the states to be kept within the throttle period, got %d", n)
+	}
+	clock.Advance(time.Minute)
+	pause(t, cfg, clock, "c")
+	if n := len(cfg.Limiter.states); n != 1 {
+		t.Fatalf("Expected the states of players a and b to be dropped, got %d", n)
+	}
+
+	// A pending debounce keeps its state
+	payloads = nil
+	cfg = limitedConfig(t, `{"debounce": "5s", "key": ["Player.uuid"]}`, clock, &payloads)
+	pause(t, cfg, clock, "a")
+	pause(t, cfg, clock, "b")
+	clock.Advance(2 * time.Second)
+	pause(t, cfg, clock, "b")
+	clock.Advance(3 * time.Second)
+	pause(t, cfg, clock, "b")
+	if n := len(cfg.Limiter.states); n != 1 || len(payloads) != 1 {
+		t.Fatalf("Expected only player b's state to remain once player a fired, got %d states and %d executions", n, len(payloads))
+	}
+}
+
+func TestEngineLoadKeepsLimits(t *testing.T) {
+	var payloads []WebhookPayload
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	e := NewEngine(limitedConfig(t, `{"cooldown": "1m"}`, clock, &payloads), nil)
+
+	pause(t, e.Config(), clock, "a")
+	e.Load(limitedConfig(t, `{"cooldown": "1m"}`, clock, &payloads))
+	pause(t, e.Config(), clock, "a")
+	if len(payloads) != 1 {
+		t.Fatalf("Expected the cooldown to survive a reload, got %d executions", len(payloads))
+	}
+}
+
+func TestNewConfigInvalidLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit string
+	}{
+		{"cooldown", `{"cooldown": "soon"}`},
+		{"negative debounce", `{"debounce": "-1s"}`},
+		{"throttle count", `{"throttle": {"count": 0, "per": "1m"}}`},
+		{"throttle period", `{"throttle": {"count": 1}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewConfig(strings.NewReader(`{"triggers": [{"properties": {}, "limit": ` + tt.limit + `}]}`))
+			if err == nil {
+				t.Errorf("Expected an error loading config, got none")
+			}
+		})
+	}
+}
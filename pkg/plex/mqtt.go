@@ -0,0 +1,173 @@
+package plex
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+func init() {
+	RegisterAction("mqtt", newMQTTAction)
+}
+
+// MQTTBroker is an MQTT broker that mqtt actions publish to.  URL is tcp://host:port, or ssl://host:port for TLS
+// (mqtt:// and mqtts:// work too); the port defaults to 1883, or 8883 for TLS.  When ClientID is empty, a random one
+// is used.  KeepAlive defaults to 60s.
+type MQTTBroker struct {
+	URL       string     `json:"url"`
+	ClientID  string     `json:"clientId,omitempty"`
+	Username  string     `json:"username,omitempty"`
+	Password  string     `json:"password,omitempty"`
+	KeepAlive string     `json:"keepAlive,omitempty"`
+	TLS       *TLSConfig `json:"tls,omitempty"`
+
+	// key identifies the broker's settings, so that its client can be carried over a config reload (see
+	// carryMQTTClients)
+	key    string
+	client *mqttClient
+}
+
+// compile validates the broker settings and creates the client connecting to it.  The client connects on first use.
+func (b *MQTTBroker) compile() error {
+	u, err := url.Parse(b.URL)
+	if err != nil {
+		return err
+	}
+	opts := mqttOptions{
+		clientID:  b.ClientID,
+		username:  b.Username,
+		password:  b.Password,
+		keepAlive: time.Minute,
+	}
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		if b.TLS != nil {
+			return fmt.Errorf("tls settings require an ssl:// url")
+		}
+	case "ssl", "tls", "mqtts":
+		port = "8883"
+		if opts.tls, err = b.TLS.config(u.Hostname()); err != nil {
+			return fmt.Errorf("invalid tls settings: %v", err)
+		}
+	default:
+		return fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("missing host in url %q", b.URL)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	opts.addr = net.JoinHostPort(u.Hostname(), port)
+	if b.KeepAlive != "" {
+		if opts.keepAlive, err = parsePositiveDuration("keepAlive", b.KeepAlive); err != nil {
+			return err
+		}
+	}
+
+	key, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	sum := sha1.Sum(key)
+	b.key = hex.EncodeToString(sum[:])
+	if opts.clientID == "" {
+		opts.clientID = "plexus-" + uuid.NewRandom().String()[:8]
+	}
+	b.client = newMQTTClient(opts)
+	return nil
+}
+
+// carryMQTTClients hands the clients of the brokers of a previous config over to the brokers of the next one with
+// the same settings, so that their connections are kept across a config reload.  It returns the previous clients that
+// were not carried over, which the caller should close once the next config is in use.
+func carryMQTTClients(prev, next map[string]*MQTTBroker) []*mqttClient {
+	carried := map[*mqttClient]bool{}
+	for _, b := range next {
+		for _, pb := range prev {
+			if pb.key == b.key && !carried[pb.client] {
+				b.client = pb.client
+				carried[pb.client] = true
+				break
+			}
+		}
+	}
+	var unused []*mqttClient
+	for _, pb := range prev {
+		if !carried[pb.client] {
+			unused = append(unused, pb.client)
+		}
+	}
+	return unused
+}
+
+// MQTTAction publishes a message to a broker for an event.  Topic and Payload are templates rendered over the event
+// (see templateData); without a Payload, the webhook payload is published as JSON.
+type MQTTAction struct {
+	Broker  string `json:"broker"`
+	Topic   string `json:"topic"`
+	Payload string `json:"payload,omitempty"`
+	QoS     byte   `json:"qos,omitempty"`
+	Retain  bool   `json:"retain,omitempty"`
+
+	broker  *MQTTBroker
+	topic   *Template
+	payload *Template
+}
+
+// newMQTTAction creates an MQTTAction from its configuration, compiling its templates and resolving its broker
+func newMQTTAction(c ActionConfig) (Action, error) {
+	m := MQTTAction{}
+	if err := c.Decode(&m); err != nil {
+		return nil, err
+	}
+	b, ok := c.Root.MQTT[m.Broker]
+	if !ok {
+		return nil, fmt.Errorf("unknown mqtt broker %q", m.Broker)
+	}
+	m.broker = b
+	if m.Topic == "" {
+		return nil, fmt.Errorf("invalid mqtt action specified; missing topic")
+	}
+	if m.QoS > 2 {
+		return nil, fmt.Errorf("invalid mqtt action specified; qos must be 0, 1 or 2")
+	}
+	var err error
+	if m.topic, err = compileTemplate("topic", m.Topic); err != nil {
+		return nil, fmt.Errorf("invalid mqtt topic template: %v", err)
+	}
+	if m.Payload != "" {
+		if m.payload, err = compileTemplate("payload", m.Payload); err != nil {
+			return nil, fmt.Errorf("invalid mqtt payload template: %v", err)
+		}
+	}
+	return m, nil
+}
+
+// Execute publishes the message, waiting for the broker to acknowledge it at QoS 1 and 2
+func (m MQTTAction) Execute(ctx context.Context, ev Event) error {
+	topic, err := m.topic.render(ev)
+	if err != nil {
+		return fmt.Errorf("could not render mqtt topic: %v", err)
+	}
+	var payload []byte
+	if m.payload != nil {
+		p, err := m.payload.render(ev)
+		if err != nil {
+			return fmt.Errorf("could not render mqtt payload: %v", err)
+		}
+		payload = []byte(p)
+	} else if payload, err = json.Marshal(ev.Document); err != nil {
+		return err
+	}
+	ev.logger().Log("action", "mqtt", "msg", "publishing message", "broker", m.Broker, "topic", topic, "qos", m.QoS, "retain", m.Retain)
+	return m.broker.client.publish(ctx, topic, payload, m.QoS, m.Retain)
+}
@@ -0,0 +1,457 @@
+package plex
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMessage is a message received by a fakeBroker
+type fakeMessage struct {
+	topic   string
+	payload string
+	qos     byte
+	retain  bool
+}
+
+// fakeBroker is an in-process MQTT broker accepting publishes from clients with the given credentials
+type fakeBroker struct {
+	ln       net.Listener
+	username string
+	password string
+	messages chan fakeMessage
+
+	mu       sync.Mutex
+	connects int
+	conns    []net.Conn
+}
+
+func newFakeBroker(t *testing.T, ln net.Listener, username, password string) *fakeBroker {
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := &fakeBroker{ln: ln, username: username, password: password, messages: make(chan fakeMessage, 10)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.conns = append(b.conns, c)
+			b.mu.Unlock()
+			go b.serve(c)
+		}
+	}()
+	return b
+}
+
+// connections counts the clients that connected successfully
+func (b *fakeBroker) connections() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.connects
+}
+
+func (b *fakeBroker) addr() string {
+	return b.ln.Addr().String()
+}
+
+// drop closes every client connection
+func (b *fakeBroker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.conns {
+		c.Close()
+	}
+	b.conns = nil
+}
+
+func (b *fakeBroker) close() {
+	b.ln.Close()
+	b.drop()
+}
+
+func (b *fakeBroker) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	p, err := readMQTTPacket(r)
+	if err != nil || p.typ != mqttConnect {
+		return
+	}
+	if username, password := parseConnect(p.body); username != b.username || password != b.password {
+		c.Write(mqttPacket{typ: mqttConnack, body: []byte{0, 4}}.bytes())
+		return
+	}
+	b.mu.Lock()
+	b.connects++
+	b.mu.Unlock()
+	c.Write(mqttPacket{typ: mqttConnack, body: []byte{0, 0}}.bytes())
+	for {
+		p, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+		switch p.typ {
+		case mqttPublish:
+			m := fakeMessage{qos: p.flags >> 1 & 0x3, retain: p.flags&0x1 != 0}
+			n := int(binary.BigEndian.Uint16(p.body))
+			m.topic = string(p.body[2 : 2+n])
+			rest := p.body[2+n:]
+			var id uint16
+			if m.qos > 0 {
+				id, rest = binary.BigEndian.Uint16(rest), rest[2:]
+			}
+			m.payload = string(rest)
+			b.messages <- m
+			switch m.qos {
+			case 1:
+				c.Write(mqttAck(mqttPuback, id).bytes())
+			case 2:
+				c.Write(mqttAck(mqttPubrec, id).bytes())
+			}
+		case mqttPubrel:
+			c.Write(mqttAck(mqttPubcomp, p.packetID()).bytes())
+		case mqttPingreq:
+			c.Write(mqttPacket{typ: mqttPingresp}.bytes())
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+// parseConnect reads the credentials from the body of a CONNECT packet
+func parseConnect(body []byte) (string, string) {
+	str := func() string {
+		n := int(binary.BigEndian.Uint16(body))
+		s := string(body[2 : 2+n])
+		body = body[2+n:]
+		return s
+	}
+	str() // protocol name
+	flags := body[1]
+	body = body[4:]
+	str() // client id
+	var username, password string
+	if flags&0x80 != 0 {
+		username = str()
+	}
+	if flags&0x40 != 0 {
+		password = str()
+	}
+	return username, password
+}
+
+// mqttConfig loads a config with the given broker and a trigger with the given mqtt actions
+func mqttConfig(t *testing.T, broker, actions string) Config {
+	cfg, err := NewConfig(strings.NewReader(`{"mqtt": {"home": ` + broker + `}, "triggers": [{"properties": {}, "actions": [` + actions + `]}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	return cfg
+}
+
+func receive(t *testing.T, b *fakeBroker) fakeMessage {
+	select {
+	case m := <-b.messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a message")
+	}
+	return fakeMessage{}
+}
+
+func TestMQTTAction(t *testing.T) {
+	b := newFakeBroker(t, nil, "plexus", "s3cret")
+	defer b.close()
+	cfg := mqttConfig(t, `{"url": "tcp://`+b.addr()+`", "username": "plexus", "password": "s3cret"}`, `
+		{"type": "mqtt", "config": {"broker": "home", "topic": "plex/{{.Payload.Player.Title | replace \" \" \"_\" | lower}}", "payload": "{{.Payload.Event}}"}},
+		{"type": "mqtt", "config": {"broker": "home", "topic": "plex/title", "payload": "{{.Payload.Metadata.Title}}", "qos": 1, "retain": true}},
+		{"type": "mqtt", "config": {"broker": "home", "topic": "plex/raw", "qos": 2}}
+	`)
+	want := []fakeMessage{
+		{topic: "plex/living_room", payload: "media.play"},
+		{topic: "plex/title", payload: "Alien", qos: 1, retain: true},
+		{topic: "plex/raw", payload: `{"Metadata":{"addedAt":1559347200,"title":"Alien","year":1979},"Player":{"title":"Living Room"},"event":"media.play"}`, qos: 2},
+	}
+	for i, a := range cfg.Triggers[0].ParsedActions {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := a.Execute(ctx, templateEvent(t)); err != nil {
+			t.Errorf("Unexpected error publishing message %d: %v", i, err)
+		}
+		cancel()
+		if m := receive(t, b); m != want[i] {
+			t.Errorf("Expected message %+v, got %+v", want[i], m)
+		}
+	}
+	if b.connections() != 1 {
+		t.Errorf("Expected the connection to be reused, got %d connections", b.connections())
+	}
+
+	// The connection is re-established after it is lost
+	b.drop()
+	time.Sleep(50 * time.Millisecond)
+	if err := cfg.Triggers[0].ParsedActions[1].Execute(context.Background(), templateEvent(t)); err != nil {
+		t.Errorf("Unexpected error publishing after the connection was lost: %v", err)
+	}
+	receive(t, b)
+	if b.connections() != 2 {
+		t.Errorf("Expected a new connection, got %d connections", b.connections())
+	}
+}
+
+func TestMQTTActionRefused(t *testing.T) {
+	b := newFakeBroker(t, nil, "plexus", "s3cret")
+	defer b.close()
+	cfg := mqttConfig(t, `{"url": "tcp://`+b.addr()+`", "username": "plexus", "password": "wrong"}`,
+		`{"type": "mqtt", "config": {"broker": "home", "topic": "plex"}}`)
+	err := cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t))
+	if err == nil || !strings.Contains(err.Error(), "bad user name or password") {
+		t.Errorf("Expected the connection to be refused, got: %v", err)
+	}
+}
+
+func TestMQTTActionTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(nil)
+	srv.Close()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newFakeBroker(t, ln, "", "")
+	defer b.close()
+
+	ca, err := ioutil.TempFile("", "plexus-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	ca.Close()
+
+	cfg := mqttConfig(t, `{"url": "ssl://`+b.addr()+`", "tls": {"ca": "`+ca.Name()+`", "serverName": "example.com"}}`,
+		`{"type": "mqtt", "config": {"broker": "home", "topic": "plex", "payload": "secure", "qos": 1}}`)
+	if err := cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t)); err != nil {
+		t.Fatalf("Unexpected error publishing over tls: %v", err)
+	}
+	if m := receive(t, b); m.payload != "secure" {
+		t.Errorf("Unexpected message %+v", m)
+	}
+
+	// Without the CA, the broker is not trusted
+	cfg = mqttConfig(t, `{"url": "ssl://`+b.addr()+`", "clientId": "untrusted"}`,
+		`{"type": "mqtt", "config": {"broker": "home", "topic": "plex"}}`)
+	if err := cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t)); err == nil {
+		t.Errorf("Expected an untrusted certificate to fail")
+	}
+}
+
+func TestMQTTWireFormat(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	payload := bytes.Repeat([]byte("x"), 200)
+	wantConnect := append([]byte{0x10, 0x1d, 0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0xc2, 0x00, 0x3c, 0x00, 0x0b},
+		"plexus-test\x00\x01u\x00\x01p"...)
+	wantPublish := append([]byte{0x33, 0xcf, 0x01, 0x00, 0x03, 'a', '/', 'b', 0x00, 0x01}, payload...)
+	errs := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		for _, step := range []struct {
+			want  []byte
+			reply []byte
+		}{
+			{wantConnect, []byte{0x20, 0x02, 0x00, 0x00}},
+			{wantPublish, []byte{0x40, 0x02, 0x00, 0x01}},
+		} {
+			got := make([]byte, len(step.want))
+			if _, err := io.ReadFull(c, got); err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(got, step.want) {
+				errs <- fmt.Errorf("expected packet % x, got % x", step.want, got)
+				return
+			}
+			c.Write(step.reply)
+		}
+		errs <- nil
+	}()
+
+	c := newMQTTClient(mqttOptions{addr: ln.Addr().String(), clientID: "plexus-test", username: "u", password: "p", keepAlive: time.Minute})
+	defer c.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.publish(ctx, "a/b", payload, 1, true); err != nil {
+		t.Errorf("Unexpected error publishing: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMQTTPingTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// The broker accepts the connection, then stops answering, as if it were half-open
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if _, err := readMQTTPacket(bufio.NewReader(c)); err != nil {
+			return
+		}
+		c.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		io.Copy(ioutil.Discard, c)
+	}()
+
+	c := newMQTTClient(mqttOptions{addr: ln.Addr().String(), clientID: "plexus-test", keepAlive: 20 * time.Millisecond})
+	defer c.close()
+	conn, err := c.connection(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-conn.closed:
+		if conn.err != errMQTTNoPong {
+			t.Errorf("Expected the connection to fail for want of a ping response, got %v", conn.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the connection to be closed without a ping response")
+	}
+}
+
+func TestMQTTReconnectBackoff(t *testing.T) {
+	defer func(b, m time.Duration) { mqttBackoff, mqttMaxBackoff = b, m }(mqttBackoff, mqttMaxBackoff)
+	mqttBackoff, mqttMaxBackoff = 50*time.Millisecond, 100*time.Millisecond
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// The broker hangs up on every client
+	var mu sync.Mutex
+	accepted := 0
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			accepted++
+			mu.Unlock()
+			c.Close()
+		}
+	}()
+	attempts := func() int {
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		return accepted
+	}
+
+	c := newMQTTClient(mqttOptions{addr: ln.Addr().String(), clientID: "plexus-test"})
+	defer c.close()
+	for _, want := range []int{1, 1} {
+		if err := c.publish(context.Background(), "plex", nil, 0, false); err == nil {
+			t.Fatal("Expected publishing to fail")
+		}
+		if n := attempts(); n != want {
+			t.Fatalf("Expected %d connection attempts, got %d", want, n)
+		}
+	}
+	// The second failure doubles the wait, up to the maximum
+	for i, wait := range []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond} {
+		time.Sleep(wait)
+		c.publish(context.Background(), "plex", nil, 0, false)
+		if n := attempts(); n != i+2 {
+			t.Fatalf("Expected %d connection attempts after waiting %v, got %d", i+2, wait, n)
+		}
+		if d := time.Until(c.retryAt); d < wait/2 || d > 2*wait {
+			t.Errorf("Expected to wait about %v before reconnecting, got %v", 2*wait, d)
+		}
+	}
+}
+
+func TestMQTTReload(t *testing.T) {
+	b := newFakeBroker(t, nil, "", "")
+	defer b.close()
+	broker := `{"url": "tcp://` + b.addr() + `", "clientId": "reload"}`
+	action := `{"type": "mqtt", "config": {"broker": "home", "topic": "plex"}}`
+	e := NewEngine(mqttConfig(t, broker, action), nil)
+	defer e.Shutdown(context.Background())
+	client := e.Config().MQTT["home"].client
+	if err := e.Config().Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t)); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, b)
+
+	// Reloading the same settings keeps the connection
+	e.Load(mqttConfig(t, broker, action))
+	if c := e.Config().MQTT["home"].client; c != client {
+		t.Errorf("Expected the client to be carried over the reload")
+	}
+	if err := e.Config().Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t)); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, b)
+	if b.connections() != 1 {
+		t.Errorf("Expected the connection to be reused, got %d connections", b.connections())
+	}
+
+	// Changing them closes the previous client
+	e.Load(mqttConfig(t, `{"url": "tcp://`+b.addr()+`", "clientId": "changed"}`, action))
+	if c := e.Config().MQTT["home"].client; c == client {
+		t.Errorf("Expected a new client after the settings changed")
+	}
+	if err := client.publish(context.Background(), "plex", nil, 0, false); err != errMQTTClientClosed {
+		t.Errorf("Expected the previous client to be closed, got %v", err)
+	}
+}
+
+func TestNewConfigInvalidMQTT(t *testing.T) {
+	for _, config := range []string{
+		`{"mqtt": {"home": {"url": "http://localhost"}}, "triggers": []}`,
+		`{"mqtt": {"home": {"url": "tcp://"}}, "triggers": []}`,
+		`{"mqtt": {"home": {"url": "tcp://localhost", "tls": {}}}, "triggers": []}`,
+		`{"mqtt": {"home": {"url": "tcp://localhost", "keepAlive": "soon"}}, "triggers": []}`,
+		`{"mqtt": {"home": null}, "triggers": []}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "mqtt", "config": {"broker": "home", "topic": "plex"}}]}]}`,
+		`{"mqtt": {"home": {"url": "tcp://localhost"}}, "triggers": [{"properties": {}, "actions": [{"type": "mqtt", "config": {"broker": "home"}}]}]}`,
+		`{"mqtt": {"home": {"url": "tcp://localhost"}}, "triggers": [{"properties": {}, "actions": [{"type": "mqtt", "config": {"broker": "home", "topic": "plex", "qos": 3}}]}]}`,
+	} {
+		if _, err := NewConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("Expected an error loading %s, got none", config)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package plex
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttPubrec     = 5
+	mqttPubrel     = 6
+	mqttPubcomp    = 7
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+// errMQTTClosed is returned when the connection to a broker is lost while waiting for it
+var errMQTTClosed = errors.New("mqtt connection closed")
+
+// errMQTTNoPong fails a connection whose broker did not answer a ping in time, such as a half-open one
+var errMQTTNoPong = errors.New("mqtt: no ping response from broker")
+
+// mqttBackoff is the wait before reconnecting after a failed connection attempt.  It doubles with every further
+// failure, up to mqttMaxBackoff.
+var mqttBackoff, mqttMaxBackoff = time.Second, time.Minute
+
+// errMQTTClientClosed is returned when publishing with a client that was closed, because its broker is no longer
+// configured
+var errMQTTClientClosed = errors.New("mqtt client closed")
+
+// mqttPacket is an MQTT control packet
+type mqttPacket struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// readMQTTPacket reads a control packet
+func readMQTTPacket(r *bufio.Reader) (mqttPacket, error) {
+	p := mqttPacket{}
+	h, err := r.ReadByte()
+	if err != nil {
+		return p, err
+	}
+	p.typ, p.flags = h>>4, h&0x0f
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return p, errors.New("mqtt: malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return p, err
+		}
+		n += int(b&0x7f) * mult
+		mult *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	p.body = make([]byte, n)
+	_, err = io.ReadFull(r, p.body)
+	return p, err
+}
+
+// bytes encodes the packet
+func (p mqttPacket) bytes() []byte {
+	b := []byte{p.typ<<4 | p.flags}
+	n := len(p.body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, p.body...)
+}
+
+// packetID reads the packet identifier at the start of an acknowledgement's body
+func (p mqttPacket) packetID() uint16 {
+	if len(p.body) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(p.body)
+}
+
+// appendMQTTString appends a length prefixed UTF-8 string
+func appendMQTTString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// mqttAck is an acknowledgement packet, identified by the given packet id
+func mqttAck(typ byte, id uint16) mqttPacket {
+	flags := byte(0)
+	if typ == mqttPubrel {
+		flags = 0x2
+	}
+	return mqttPacket{typ: typ, flags: flags, body: []byte{byte(id >> 8), byte(id)}}
+}
+
+// mqttOptions are the settings of an MQTT broker connection
+type mqttOptions struct {
+	addr      string
+	tls       *tls.Config
+	clientID  string
+	username  string
+	password  string
+	keepAlive time.Duration
+}
+
+// mqttClient publishes messages to a broker over a persistent connection, which is (re)established as needed, backing
+// off after failed attempts.  It is safe for concurrent use.
+type mqttClient struct {
+	opts mqttOptions
+
+	mu       sync.Mutex
+	conn     *mqttConn
+	closed   bool
+	failures int
+	retryAt  time.Time
+	err      error
+}
+
+func newMQTTClient(opts mqttOptions) *mqttClient {
+	return &mqttClient{opts: opts}
+}
+
+// publish sends a message to the broker, waiting for it to be acknowledged according to qos
+func (c *mqttClient) publish(ctx context.Context, topic string, payload []byte, qos byte, retain bool) error {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.publish(ctx, topic, payload, qos, retain)
+}
+
+// connection returns the current connection, connecting if there is none
+func (c *mqttClient) connection(ctx context.Context) (*mqttConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errMQTTClientClosed
+	}
+	if c.conn != nil && !c.conn.isClosed() {
+		return c.conn, nil
+	}
+	if d := time.Until(c.retryAt); d > 0 {
+		return nil, fmt.Errorf("mqtt: reconnecting in %v after: %v", d.Round(time.Millisecond), c.err)
+	}
+	conn, err := dialMQTT(ctx, c.opts)
+	if err != nil {
+		wait := mqttMaxBackoff
+		if c.failures < 16 && mqttBackoff<<uint(c.failures) < mqttMaxBackoff {
+			wait = mqttBackoff << uint(c.failures)
+		}
+		c.failures++
+		c.retryAt, c.err = time.Now().Add(wait), err
+		return nil, err
+	}
+	c.failures, c.retryAt, c.err = 0, time.Time{}, nil
+	c.conn = conn
+	return conn, nil
+}
+
+// close disconnects from the broker for good
+func (c *mqttClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn != nil {
+		c.conn.disconnect()
+		c.conn = nil
+	}
+}
+
+// mqttConn is an established broker connection
+type mqttConn struct {
+	conn      net.Conn
+	keepAlive time.Duration
+
+	wmu sync.Mutex // serializes writes
+
+	mu     sync.Mutex
+	nextID uint16
+	acks   map[uint16]chan mqttPacket
+	pong   chan struct{}
+	closed chan struct{}
+	err    error
+}
+
+// dialMQTT connects to the broker and sends CONNECT, waiting for its CONNACK
+func dialMQTT(ctx context.Context, opts mqttOptions) (*mqttConn, error) {
+	d := net.Dialer{}
+	nc, err := d.DialContext(ctx, "tcp", opts.addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	} else {
+		nc.SetDeadline(time.Now().Add(30 * time.Second))
+	}
+	if opts.tls != nil {
+		tc := tls.Client(nc, opts.tls)
+		if err := tc.Handshake(); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+
+	flags := byte(0x02) // clean session
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+	if opts.username != "" {
+		flags |= 0x80
+		if opts.password != "" {
+			flags |= 0x40
+		}
+	}
+	ka := int(opts.keepAlive / time.Second)
+	body = append(body, flags, byte(ka>>8), byte(ka))
+	body = appendMQTTString(body, opts.clientID)
+	if opts.username != "" {
+		body = appendMQTTString(body, opts.username)
+		if opts.password != "" {
+			body = appendMQTTString(body, opts.password)
+		}
+	}
+	r := bufio.NewReader(nc)
+	if _, err := nc.Write(mqttPacket{typ: mqttConnect, body: body}.bytes()); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	ack, err := readMQTTPacket(r)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if ack.typ != mqttConnack || len(ack.body) != 2 {
+		nc.Close()
+		return nil, fmt.Errorf("mqtt: unexpected packet type %d in reply to connect", ack.typ)
+	}
+	if code := ack.body[1]; code != 0 {
+		nc.Close()
+		return nil, fmt.Errorf("mqtt: connection refused: %s", connackReason(code))
+	}
+	nc.SetDeadline(time.Time{})
+
+	c := &mqttConn{
+		conn:      nc,
+		keepAlive: opts.keepAlive,
+		acks:      map[uint16]chan mqttPacket{},
+		pong:      make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+	go c.read(r)
+	if c.keepAlive > 0 {
+		go c.ping()
+	}
+	return c, nil
+}
+
+func connackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("return code %d", code)
+}
+
+// read dispatches acknowledgements to the publishers waiting for them until the connection fails
+func (c *mqttConn) read(r *bufio.Reader) {
+	for {
+		p, err := readMQTTPacket(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch p.typ {
+		case mqttPuback, mqttPubrec, mqttPubcomp:
+			c.mu.Lock()
+			ch, ok := c.acks[p.packetID()]
+			delete(c.acks, p.packetID())
+			c.mu.Unlock()
+			if ok {
+				ch <- p
+			}
+		case mqttPingresp:
+			select {
+			case c.pong <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// ping keeps the connection alive while it is idle.  It fails the connection when the broker does not answer a ping
+// within 1.5 times the keepalive.
+func (c *mqttConn) ping() {
+	t := time.NewTicker(c.keepAlive / 2)
+	defer t.Stop()
+	// deadline is set while waiting for a PINGRESP
+	var deadline <-chan time.Time
+	for {
+		select {
+		case <-t.C:
+			if deadline != nil {
+				continue
+			}
+			if err := c.write(mqttPacket{typ: mqttPingreq}); err != nil {
+				c.fail(err)
+				return
+			}
+			deadline = time.After(c.keepAlive * 3 / 2)
+		case <-c.pong:
+			deadline = nil
+		case <-deadline:
+			c.fail(errMQTTNoPong)
+			return
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *mqttConn) write(p mqttPacket) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := c.conn.Write(p.bytes())
+	return err
+}
+
+// fail closes the connection, releasing everything waiting on it
+func (c *mqttConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.closed)
+	c.conn.Close()
+}
+
+func (c *mqttConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// disconnect closes the connection gracefully
+func (c *mqttConn) disconnect() {
+	c.write(mqttPacket{typ: mqttDisconnect})
+	c.fail(errMQTTClosed)
+}
+
+// expect registers for the acknowledgement of the next packet id, returning the id
+func (c *mqttConn) expect() (uint16, chan mqttPacket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID++
+	}
+	ch := make(chan mqttPacket, 1)
+	c.acks[c.nextID] = ch
+	return c.nextID, ch
+}
+
+func (c *mqttConn) forget(id uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.acks, id)
+}
+
+// await waits for the acknowledgement of the given type
+func (c *mqttConn) await(ctx context.Context, id uint16, ch chan mqttPacket, typ byte) error {
+	select {
+	case p := <-ch:
+		if p.typ != typ {
+			return fmt.Errorf("mqtt: unexpected packet type %d, expected %d", p.typ, typ)
+		}
+		return nil
+	case <-c.closed:
+		return errMQTTClosed
+	case <-ctx.Done():
+		c.forget(id)
+		return ctx.Err()
+	}
+}
+
+// publish sends a PUBLISH packet, completing the acknowledgement flow of the given qos
+func (c *mqttConn) publish(ctx context.Context, topic string, payload []byte, qos byte, retain bool) error {
+	flags := qos << 1
+	if retain {
+		flags |= 0x1
+	}
+	body := appendMQTTString(nil, topic)
+	if qos == 0 {
+		return c.write(mqttPacket{typ: mqttPublish, flags: flags, body: append(body, payload...)})
+	}
+	id, ch := c.expect()
+	body = append(body, byte(id>>8), byte(id))
+	if err := c.write(mqttPacket{typ: mqttPublish, flags: flags, body: append(body, payload...)}); err != nil {
+		c.forget(id)
+		c.fail(err)
+		return err
+	}
+	if qos == 1 {
+		return c.await(ctx, id, ch, mqttPuback)
+	}
+	if err := c.await(ctx, id, ch, mqttPubrec); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.acks[id] = ch
+	c.mu.Unlock()
+	if err := c.write(mqttAck(mqttPubrel, id)); err != nil {
+		c.forget(id)
+		c.fail(err)
+		return err
+	}
+	return c.await(ctx, id, ch, mqttPubcomp)
+}
@@ -0,0 +1,184 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterAction("ntfy", newNtfyAction)
+}
+
+// NtfyServer is an ntfy server that ntfy actions publish to.  Requests are authenticated with Token, an access token,
+// or with Username and Password, when set.
+type NtfyServer struct {
+	URL      string `json:"url"`
+	Token    string `json:"token,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// validate checks the server's settings
+func (n *NtfyServer) validate() error {
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https url")
+	}
+	if n.Token != "" && (n.Username != "" || n.Password != "") {
+		return fmt.Errorf("set either a token or a username and password, not both")
+	}
+	return nil
+}
+
+// NtfyAction publishes a notification to a topic of an ntfy server.  Title, Message, Click and Tags are templates
+// rendered over the event (see templateData); Message defaults to the message of chat actions (see ChatAction).
+// Priority is 1 (min) to 5 (max), and the server's default when 0.
+//
+// Thumb decides how the poster saved with the webhook is shown: "attach" uploads it as the notification's attachment,
+// which requires a server with attachments enabled; "link" attaches it by its url on Plexus' thumbs endpoint, which
+// requires the config's PublicURL; "none" leaves it out.  It defaults to "link" when a PublicURL is configured, and
+// "none" otherwise.
+type NtfyAction struct {
+	Server   string   `json:"server"`
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	Priority int      `json:"priority,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Click    string   `json:"click,omitempty"`
+	Thumb    string   `json:"thumb,omitempty"`
+
+	server    *NtfyServer
+	publicURL string
+	title     *Template
+	message   *Template
+	tags      []*Template
+	click     *Template
+}
+
+// newNtfyAction creates an NtfyAction from its configuration, compiling its templates and resolving its server
+func newNtfyAction(c ActionConfig) (Action, error) {
+	n := NtfyAction{}
+	if err := c.Decode(&n); err != nil {
+		return nil, err
+	}
+	s, ok := c.Root.Ntfy[n.Server]
+	if !ok {
+		return nil, fmt.Errorf("unknown ntfy server %q", n.Server)
+	}
+	n.server = s
+	if n.Topic == "" || strings.Contains(n.Topic, "/") {
+		return nil, fmt.Errorf("invalid ntfy action specified; missing or invalid topic")
+	}
+	if n.Priority < 0 || n.Priority > 5 {
+		return nil, fmt.Errorf("invalid ntfy priority %d; must be 0 (server default) or 1-5", n.Priority)
+	}
+	n.publicURL = strings.TrimSuffix(c.Root.PublicURL, "/")
+	var err error
+	if n.Thumb, err = thumbSetting("ntfy", n.Thumb, n.publicURL, "attach"); err != nil {
+		return nil, err
+	}
+	err = compileTemplates("ntfy",
+		templateField{"title", n.Title, &n.title},
+		templateField{"message", n.Message, &n.message},
+		templateField{"click", n.Click, &n.click})
+	if err != nil {
+		return nil, err
+	}
+	for i, tag := range n.Tags {
+		tmpl, err := compileTemplate(fmt.Sprintf("tags[%d]", i), tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ntfy tag template: %v", err)
+		}
+		n.tags = append(n.tags, tmpl)
+	}
+	return n, nil
+}
+
+// Execute renders the notification and publishes it.  The notification is described by headers, so that the body
+// can be the attached thumb; header values are encoded as RFC 2047 words when they are not plain ASCII.
+func (n NtfyAction) Execute(ctx context.Context, ev Event) error {
+	message := n.message
+	if message == nil {
+		message = chatDefaults(ev)[0]
+	}
+	msg, err := message.render(ev)
+	if err != nil {
+		return fmt.Errorf("could not render ntfy message: %v", err)
+	}
+	h := http.Header{}
+	set := func(k, v string) {
+		if v != "" {
+			h.Set(k, mime.BEncoding.Encode("utf-8", v))
+		}
+	}
+	if n.title != nil {
+		title, err := n.title.render(ev)
+		if err != nil {
+			return fmt.Errorf("could not render ntfy title: %v", err)
+		}
+		set("X-Title", title)
+	}
+	if n.click != nil {
+		click, err := n.click.render(ev)
+		if err != nil {
+			return fmt.Errorf("could not render ntfy click: %v", err)
+		}
+		set("X-Click", click)
+	}
+	var tags []string
+	for _, t := range n.tags {
+		tag, err := t.render(ev)
+		if err != nil {
+			return fmt.Errorf("could not render ntfy tag: %v", err)
+		}
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	set("X-Tags", strings.Join(tags, ","))
+	if n.Priority > 0 {
+		h.Set("X-Priority", strconv.Itoa(n.Priority))
+	}
+
+	method, body := "POST", []byte(msg)
+	if tp := ev.Activity.ThumbPath; tp != "" {
+		switch n.Thumb {
+		case "attach":
+			if body, err = ioutil.ReadFile(tp); err != nil {
+				return fmt.Errorf("could not read thumb: %v", err)
+			}
+			method = "PUT"
+			set("X-Message", msg)
+			h.Set("X-Filename", "thumb"+filepath.Ext(tp))
+		case "link":
+			h.Set("X-Attach", thumbLink(n.publicURL, ev))
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(n.server.URL, "/")+"/"+url.PathEscape(n.Topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	switch {
+	case n.server.Token != "":
+		req.Header.Set("Authorization", "Bearer "+n.server.Token)
+	case n.server.Username != "":
+		req.SetBasicAuth(n.server.Username, n.server.Password)
+	}
+	ev.logger().Log("action", "ntfy", "server", n.Server, "topic", n.Topic, "thumb", n.Thumb)
+	return sendRequest(ctx, req, nil)
+}
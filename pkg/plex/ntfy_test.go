@@ -0,0 +1,123 @@
+package plex
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ntfyRequest is a request received by the ntfy stand-in
+type ntfyRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+// newNtfyServer starts an ntfy stand-in recording the requests it receives and responding with status
+func newNtfyServer(t *testing.T, got *[]ntfyRequest, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		*got = append(*got, ntfyRequest{method: r.Method, path: r.URL.Path, header: r.Header, body: string(b)})
+		w.WriteHeader(status)
+	}))
+}
+
+func TestNtfyAction(t *testing.T) {
+	var got []ntfyRequest
+	srv := newNtfyServer(t, &got, http.StatusOK)
+	defer srv.Close()
+	st, cleanup := tempStore(t)
+	defer cleanup()
+
+	cfg, err := NewConfig(strings.NewReader(`{
+		"publicUrl": "https://plexus.example.com",
+		"ntfy": {"home": {"url": "` + srv.URL + `/", "token": "tk_s3cret"}, "basic": {"url": "` + srv.URL + `", "username": "u", "password": "p"}},
+		"triggers": [{"properties": {}, "actions": [
+			{"type": "ntfy", "config": {"server": "home", "topic": "plex", "title": "Now playing ▶", "priority": 4, "tags": ["tv", "{{.Trigger}}"], "click": "https://app.plex.tv/desktop"}},
+			{"type": "ntfy", "config": {"server": "basic", "topic": "plex", "message": "{{.Payload.MediaTitle}}", "thumb": "attach"}}
+		]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	for _, a := range cfg.Triggers[0].ParsedActions {
+		if err := a.Execute(context.Background(), chatEvent(t, st.dbPath)); err != nil {
+			t.Errorf("Unexpected error executing action: %v", err)
+		}
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(got))
+	}
+
+	linked := got[0]
+	if linked.method != "POST" || linked.path != "/plex" || linked.body != "Alice started The Expanse S02E03 on Living Room TV" {
+		t.Errorf("Unexpected linked notification %+v", linked)
+	}
+	for k, v := range map[string]string{
+		"Authorization": "Bearer tk_s3cret",
+		"X-Title":       "=?utf-8?b?Tm93IHBsYXlpbmcg4pa2?=",
+		"X-Priority":    "4",
+		"X-Tags":        "tv,living-room",
+		"X-Click":       "https://app.plex.tv/desktop",
+		"X-Attach":      "https://plexus.example.com/thumbs/abc123",
+	} {
+		if linked.header.Get(k) != v {
+			t.Errorf("Expected %s %q, got %q", k, v, linked.header.Get(k))
+		}
+	}
+
+	attached := got[1]
+	if attached.method != "PUT" || attached.body != "PNG" {
+		t.Errorf("Expected the thumb uploaded, got %s %q", attached.method, attached.body)
+	}
+	user, pass, _ := (&http.Request{Header: attached.header}).BasicAuth()
+	if user != "u" || pass != "p" {
+		t.Errorf("Expected basic auth as u, got %q:%q", user, pass)
+	}
+	if m, f := attached.header.Get("X-Message"), attached.header.Get("X-Filename"); m != "The Expanse S02E03" || f != "thumb.png" {
+		t.Errorf("Unexpected message %q or filename %q", m, f)
+	}
+}
+
+func TestNtfyActionStatus(t *testing.T) {
+	var got []ntfyRequest
+	srv := newNtfyServer(t, &got, http.StatusServiceUnavailable)
+	defer srv.Close()
+	cfg, err := NewConfig(strings.NewReader(`{
+		"ntfy": {"home": {"url": "` + srv.URL + `"}},
+		"triggers": [{"properties": {}, "actions": [{"type": "ntfy", "config": {"server": "home", "topic": "plex"}}]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	p, err := Retry{Attempts: 2}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t))
+	if se, ok := err.(StatusError); !ok || !p.retryable(se) {
+		t.Errorf("Expected a retryable StatusError, got: %v", err)
+	}
+}
+
+func TestNewConfigInvalidNtfy(t *testing.T) {
+	ntfy := `"ntfy": {"home": {"url": "https://ntfy.sh"}}`
+	for _, config := range []string{
+		`{"ntfy": {"home": {"url": "ntfy.sh"}}, "triggers": []}`,
+		`{"ntfy": {"home": {"url": "https://ntfy.sh", "token": "t", "username": "u"}}, "triggers": []}`,
+		`{"ntfy": {"home": null}, "triggers": []}`,
+		`{"triggers": [{"properties": {}, "actions": [{"type": "ntfy", "config": {"server": "home", "topic": "plex"}}]}]}`,
+		`{` + ntfy + `, "triggers": [{"properties": {}, "actions": [{"type": "ntfy", "config": {"server": "home"}}]}]}`,
+		`{` + ntfy + `, "triggers": [{"properties": {}, "actions": [{"type": "ntfy", "config": {"server": "home", "topic": "plex", "priority": 6}}]}]}`,
+		`{` + ntfy + `, "triggers": [{"properties": {}, "actions": [{"type": "ntfy", "config": {"server": "home", "topic": "plex", "thumb": "link"}}]}]}`,
+		`{` + ntfy + `, "triggers": [{"properties": {}, "actions": [{"type": "ntfy", "config": {"server": "home", "topic": "plex", "tags": ["{{"]}}]}]}`,
+	} {
+		if _, err := NewConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("Expected an error loading %s, got none", config)
+		}
+	}
+}
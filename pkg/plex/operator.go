@@ -0,0 +1,307 @@
+package plex
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Property conditions are either a literal value, which must equal the value found at the property path, or an
+// operator object such as {"$in": ["movie", "show"]}.  When an operator object holds several operators, all of them
+// must be satisfied.
+const (
+	opEq       = "$eq"
+	opNe       = "$ne"
+	opIn       = "$in"
+	opNin      = "$nin"
+	opGt       = "$gt"
+	opGte      = "$gte"
+	opLt       = "$lt"
+	opLte      = "$lte"
+	opRegex    = "$regex"
+	opPrefix   = "$prefix"
+	opSuffix   = "$suffix"
+	opContains = "$contains"
+	opExists   = "$exists"
+)
+
+// operator is a single compiled property test.  Literal conditions compile to an $eq operator.
+type operator struct {
+	name string
+	arg  interface{}
+	list []interface{}
+	re   *regexp.Regexp
+	str  string
+	want bool
+}
+
+// isOperatorObject determines if the given condition value is an operator object rather than a literal
+func isOperatorObject(v interface{}) (map[string]interface{}, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		return nil, false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+// compileOperators turns a property condition into the list of operators that must all be satisfied
+func compileOperators(expected interface{}) ([]operator, error) {
+	m, ok := isOperatorObject(expected)
+	if !ok {
+		return []operator{{name: opEq, arg: expected}}, nil
+	}
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	ops := make([]operator, 0, len(m))
+	for _, name := range names {
+		op, err := compileOperator(name, m[name])
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func compileOperator(name string, arg interface{}) (operator, error) {
+	op := operator{name: name, arg: arg}
+	switch name {
+	case opEq, opNe, opGt, opGte, opLt, opLte:
+	case opIn, opNin:
+		list, ok := arg.([]interface{})
+		if !ok {
+			return op, fmt.Errorf("%s expects an array", name)
+		}
+		op.list = list
+	case opRegex:
+		re, err := compileRegex(arg)
+		if err != nil {
+			return op, err
+		}
+		op.re = re
+	case opPrefix, opSuffix, opContains:
+		s, ok := scalarString(arg)
+		if !ok {
+			return op, fmt.Errorf("%s expects a string", name)
+		}
+		op.str = s
+	case opExists:
+		want, ok := arg.(bool)
+		if !ok {
+			return op, fmt.Errorf("%s expects a boolean", name)
+		}
+		op.want = want
+	default:
+		return op, fmt.Errorf("unknown operator %s", name)
+	}
+	return op, nil
+}
+
+// negated reports whether the operator tests for the absence of something, in which case every value of a
+// multi-valued path must satisfy it
+func (o operator) negated() bool {
+	return o.name == opNe || o.name == opNin || o.name == opExists && !o.want
+}
+
+// matchValues determines if the values resolved from a property path satisfy the operator
+func (o operator) matchValues(vals []interface{}, multi bool) bool {
+	if len(vals) == 0 {
+		return o.match(nil, false)
+	}
+	if !multi {
+		return o.match(vals[0], true)
+	}
+	if o.negated() {
+		for _, v := range vals {
+			if !o.match(v, true) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range vals {
+		if o.match(v, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// match determines if the actual value found at a property path satisfies the operator.  found reports whether the
+// path was present in the payload at all.
+func (o operator) match(actual interface{}, found bool) bool {
+	switch o.name {
+	case opExists:
+		return found == o.want
+	case opEq:
+		return valuesEqual(actual, o.arg)
+	case opNe:
+		return !valuesEqual(actual, o.arg)
+	case opIn, opNin:
+		in := false
+		for _, v := range o.list {
+			if valuesEqual(actual, v) {
+				in = true
+				break
+			}
+		}
+		return in == (o.name == opIn)
+	case opGt, opGte, opLt, opLte:
+		if !found || actual == nil {
+			return false
+		}
+		c, ok := compareValues(actual, o.arg)
+		if !ok {
+			return false
+		}
+		switch o.name {
+		case opGt:
+			return c > 0
+		case opGte:
+			return c >= 0
+		case opLt:
+			return c < 0
+		default:
+			return c <= 0
+		}
+	case opRegex:
+		s, ok := scalarString(actual)
+		return ok && o.re.MatchString(s)
+	case opPrefix, opSuffix, opContains:
+		s, ok := scalarString(actual)
+		if !ok {
+			return false
+		}
+		switch o.name {
+		case opPrefix:
+			return strings.HasPrefix(s, o.str)
+		case opSuffix:
+			return strings.HasSuffix(s, o.str)
+		default:
+			return strings.Contains(s, o.str)
+		}
+	}
+	return false
+}
+
+// compileRegex compiles a $regex argument.  Both Go syntax ("(?i)living") and the slash-delimited form with trailing
+// flags ("/living/i") are accepted.
+func compileRegex(arg interface{}) (*regexp.Regexp, error) {
+	s, ok := arg.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a string", opRegex)
+	}
+	if strings.HasPrefix(s, "/") {
+		if end := strings.LastIndex(s, "/"); end > 0 {
+			flags := s[end+1:]
+			if strings.Trim(flags, "ims") == "" {
+				s = s[1:end]
+				if flags != "" {
+					s = "(?" + flags + ")" + s
+				}
+			}
+		}
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", opRegex, err)
+	}
+	return re, nil
+}
+
+// valuesEqual compares two JSON values, coercing between numbers, booleans and their string representations so that
+// "1" equals 1 and "true" equals true.
+func valuesEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if af, ok := toNumber(a); ok {
+		if bf, ok := toNumber(b); ok {
+			return af == bf
+		}
+	}
+	as, aok := scalarString(a)
+	bs, bok := scalarString(b)
+	if aok && bok {
+		return as == bs
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders two values numerically when both are numeric, otherwise as strings.  The second return value
+// is false when the values cannot be ordered.
+func compareValues(a, b interface{}) (int, bool) {
+	if af, ok := toNumber(a); ok {
+		if bf, ok := toNumber(b); ok {
+			switch {
+			case af < bf:
+				return -1, true
+			case af > bf:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	as, aok := scalarString(a)
+	bs, bok := scalarString(b)
+	if !aok || !bok {
+		return 0, false
+	}
+	return strings.Compare(as, bs), true
+}
+
+// toNumber coerces the given value to a float64, accepting numeric strings
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, false
+		}
+		return f, true
+	}
+	return 0, false
+}
+
+// scalarString renders a scalar JSON value as a string.  The second return value is false for objects and arrays.
+func scalarString(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case bool:
+		return strconv.FormatBool(s), true
+	case nil:
+		return "", false
+	}
+	if f, ok := toNumber(v); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64), true
+	}
+	return "", false
+}
@@ -0,0 +1,166 @@
+package plex
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A property path is a dot separated list of keys, e.g. "Player.uuid".  Each key may be followed by array selectors:
+// "[2]" picks an element (negative indexes count from the end), while "[]" and "[*]" pick every element.  A key of
+// "*" picks every value of an object.  Traversing an array without a selector also picks every element, so
+// "Metadata.Genre.tag" is the same as "Metadata.Genre[].tag".
+//
+// A path that can pick more than one value is multi-valued.  Conditions on multi-valued paths are satisfied when any
+// of the values satisfies them, except for the negative operators ($ne, $nin and $exists: false), which must be
+// satisfied by every value: {"Metadata.Genre[].tag": "Horror"} matches if any genre is Horror, while
+// {"Metadata.Genre[].tag": {"$ne": "Horror"}} matches only if no genre is Horror.
+type propertyPath []pathStep
+
+// pathStep is a single key of a propertyPath and its array selectors
+type pathStep struct {
+	key       string
+	anyKey    bool
+	selectors []arraySelector
+}
+
+// arraySelector picks either one element of an array or every element
+type arraySelector struct {
+	all   bool
+	index int
+}
+
+// parsePath compiles a property path
+func parsePath(s string) (propertyPath, error) {
+	p := propertyPath{}
+	for _, part := range strings.Split(s, ".") {
+		step := pathStep{}
+		key := part
+		if i := strings.Index(part, "["); i >= 0 {
+			key = part[:i]
+			sels := part[i:]
+			for sels != "" {
+				end := strings.Index(sels, "]")
+				if sels[0] != '[' || end < 0 {
+					return nil, fmt.Errorf("invalid array selector in path %q", s)
+				}
+				sel := strings.TrimSpace(sels[1:end])
+				switch sel {
+				case "", "*":
+					step.selectors = append(step.selectors, arraySelector{all: true})
+				default:
+					n, err := strconv.Atoi(sel)
+					if err != nil {
+						return nil, fmt.Errorf("invalid array index %q in path %q", sel, s)
+					}
+					step.selectors = append(step.selectors, arraySelector{index: n})
+				}
+				sels = sels[end+1:]
+			}
+		}
+		if key == "" && len(step.selectors) == 0 {
+			return nil, fmt.Errorf("empty key in path %q", s)
+		}
+		step.key = key
+		step.anyKey = key == "*"
+		p = append(p, step)
+	}
+	return p, nil
+}
+
+// resolve finds the values at the path in a parsed JSON document.  multi reports whether the path picked (or could
+// have picked) more than one value.
+func (p propertyPath) resolve(doc interface{}) (vals []interface{}, multi bool) {
+	for _, step := range p {
+		if step.anyKey || len(step.selectors) > 0 && step.hasAll() {
+			multi = true
+		}
+	}
+	return p.walk(doc, nil, &multi), multi
+}
+
+func (s pathStep) hasAll() bool {
+	for _, sel := range s.selectors {
+		if sel.all {
+			return true
+		}
+	}
+	return false
+}
+
+func (p propertyPath) walk(cur interface{}, vals []interface{}, multi *bool) []interface{} {
+	if len(p) == 0 {
+		return append(vals, cur)
+	}
+	step := p[0]
+	switch v := cur.(type) {
+	case map[string]interface{}:
+		var next []interface{}
+		switch {
+		case step.anyKey:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				next = append(next, v[k])
+			}
+		case step.key == "":
+			next = []interface{}{v}
+		default:
+			n, ok := v[step.key]
+			if !ok {
+				return vals
+			}
+			next = []interface{}{n}
+		}
+		for _, n := range next {
+			vals = step.selectFrom(n, p[1:], vals, multi)
+		}
+		return vals
+	case []interface{}:
+		if step.key == "" {
+			// Selectors applied directly to an array
+			return step.selectFrom(v, p[1:], vals, multi)
+		}
+		// Implicitly traverse every element
+		*multi = true
+		for _, el := range v {
+			vals = p.walk(el, vals, multi)
+		}
+		return vals
+	}
+	return vals
+}
+
+// selectFrom applies the step's array selectors to the value found at its key, then walks the rest of the path
+func (s pathStep) selectFrom(cur interface{}, rest propertyPath, vals []interface{}, multi *bool) []interface{} {
+	cands := []interface{}{cur}
+	for _, sel := range s.selectors {
+		var next []interface{}
+		for _, c := range cands {
+			arr, ok := c.([]interface{})
+			if !ok {
+				continue
+			}
+			if sel.all {
+				next = append(next, arr...)
+				continue
+			}
+			i := sel.index
+			if i < 0 {
+				i += len(arr)
+			}
+			if i >= 0 && i < len(arr) {
+				next = append(next, arr[i])
+			}
+		}
+		cands = next
+	}
+	for _, c := range cands {
+		vals = rest.walk(c, vals, multi)
+	}
+	return vals
+}
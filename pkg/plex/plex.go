@@ -1,5 +1,19 @@
 package plex
 
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+)
+
+// ParseDocument parses a raw webhook payload into a generic JSON document suitable for matching against triggers
+func ParseDocument(raw []byte) (interface{}, error) {
+	var doc interface{}
+	err := json.Unmarshal(raw, &doc)
+	return doc, err
+}
+
 // WebhookPayload represents a payload from a plex webhook
 type WebhookPayload struct {
 	Event   string `json:"event"`
@@ -46,4 +60,53 @@ type WebhookPayload struct {
 		AddedAt              int    `json:"addedAt"`
 		UpdatedAt            int    `json:"updatedAt"`
 	} `json:"Metadata"`
-}
\ No newline at end of file
+}
+
+// MediaTitle describes the payload's media the way Plex shows it, e.g. "The Expanse S02E03" for an episode, "Artist -
+// Track" for a track or "Show Season 2" for a season.  Templates can call it as {{.Payload.MediaTitle}}.
+func (p WebhookPayload) MediaTitle() string {
+	m := p.Metadata
+	switch m.Type {
+	case "episode":
+		return fmt.Sprintf("%s S%02dE%02d", m.GrandparentTitle, m.ParentIndex, m.Index)
+	case "track":
+		return m.GrandparentTitle + " - " + m.Title
+	case "season", "album":
+		return m.ParentTitle + " " + m.Title
+	}
+	return m.Title
+}
+
+// Event is a webhook being acted upon by an Action
+type Event struct {
+	// Activity is the webhook as it was received
+	Activity Activity
+	// Document is the webhook payload parsed into a generic JSON document (see ParseDocument)
+	Document interface{}
+	// Trigger, TriggerName and Tags describe the trigger the webhook matched
+	Trigger     string
+	TriggerName string
+	Tags        []string
+	// Attempt counts the attempts at the action, starting at 1
+	Attempt int
+	// Logger is the logger of the trigger, which actions should log to
+	Logger log.Logger
+}
+
+func newEvent(t Trigger, act Activity, doc interface{}) Event {
+	return Event{
+		Activity:    act,
+		Document:    doc,
+		Trigger:     t.ID,
+		TriggerName: t.Name,
+		Tags:        t.Tags,
+	}
+}
+
+// logger returns the Event's Logger, or a logger discarding everything when it has none
+func (ev Event) logger() log.Logger {
+	if ev.Logger == nil {
+		return log.NewNopLogger()
+	}
+	return ev.Logger
+}
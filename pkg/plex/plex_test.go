@@ -0,0 +1,27 @@
+package plex
+
+import "testing"
+
+func TestMediaTitle(t *testing.T) {
+	tests := []struct {
+		typ, title, parent, grandparent string
+		want                            string
+	}{
+		{"movie", "Alien", "", "", "Alien"},
+		{"episode", "Dulcinea", "Season 1", "The Expanse", "The Expanse S01E01"},
+		{"season", "Season 1", "The Expanse", "", "The Expanse Season 1"},
+		{"track", "Heroes", "Heroes", "David Bowie", "David Bowie - Heroes"},
+	}
+	for _, tt := range tests {
+		p := WebhookPayload{}
+		p.Metadata.Type = tt.typ
+		p.Metadata.Title = tt.title
+		p.Metadata.ParentTitle = tt.parent
+		p.Metadata.GrandparentTitle = tt.grandparent
+		p.Metadata.ParentIndex = 1
+		p.Metadata.Index = 1
+		if got := p.MediaTitle(); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.typ, tt.want, got)
+		}
+	}
+}
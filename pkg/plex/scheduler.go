@@ -0,0 +1,314 @@
+package plex
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pborman/uuid"
+)
+
+// ErrUnknownJob is returned when there is no pending job with a given ID
+var ErrUnknownJob = errors.New("no pending job with that id")
+
+// Statuses of a ScheduledJob
+const (
+	JobPending   = "pending"
+	JobCancelled = "cancelled"
+)
+
+// maxCancelledJobs is the number of cancelled jobs kept for inspection
+const maxCancelledJobs = 100
+
+// CancelOn cancels a delayed action when an event of one of the Events arrives with the same values at the Key paths
+// (e.g. from the same Player.uuid) as the event that scheduled it.  Without a Key, any such event cancels it.
+type CancelOn struct {
+	Events []string `json:"events"`
+	Key    []string `json:"key,omitempty"`
+}
+
+// cancelPolicy is a compiled CancelOn
+type cancelPolicy struct {
+	events map[string]bool
+	key    []propertyPath
+}
+
+func (c *CancelOn) compile() (*cancelPolicy, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if len(c.Events) == 0 {
+		return nil, fmt.Errorf("cancelOn must list events")
+	}
+	p := cancelPolicy{events: map[string]bool{}}
+	for _, e := range c.Events {
+		p.events[e] = true
+	}
+	var err error
+	if p.key, err = parsePaths(c.Key); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ScheduledJob is an action whose trigger matched, waiting for its delay to pass, or one that was cancelled before it
+// ran.  Pending jobs are persisted in the Store so that a restart does not lose them.
+type ScheduledJob struct {
+	ID          string      `json:"id"`
+	Status      string      `json:"status"`
+	Trigger     string      `json:"trigger"`
+	Action      int         `json:"action"`
+	Type        string      `json:"type"`
+	Key         string      `json:"key,omitempty"`
+	ScheduledAt time.Time   `json:"scheduledAt"`
+	RunAt       time.Time   `json:"runAt"`
+	CancelOn    *CancelOn   `json:"cancelOn,omitempty"`
+	CancelledAt *time.Time  `json:"cancelledAt,omitempty"`
+	CancelledBy string      `json:"cancelledBy,omitempty"`
+	Activity    Activity    `json:"activity"`
+	Document    interface{} `json:"document"`
+}
+
+// slot identifies the job's action and key, so that scheduling the same action for the same key again replaces the
+// job.  Jobs without a cancelOn key are never replaced, and each have a slot of their own.
+func (j ScheduledJob) slot() string {
+	if j.CancelOn == nil || len(j.CancelOn.Key) == 0 {
+		return j.ID
+	}
+	return j.Trigger + "|" + strconv.Itoa(j.Action) + "|" + j.Key
+}
+
+// Scheduler holds the jobs of delayed actions.  It is safe for concurrent use.
+type Scheduler struct {
+	mu        sync.Mutex
+	store     *Store
+	clock     Clock
+	pending   map[string]*scheduledTimer
+	cancelled []ScheduledJob
+
+	// triggers resolves a trigger ID to the trigger currently configured with it, so that a job scheduled before a
+	// config reload runs the reloaded action.  When nil, the trigger that scheduled the job is used.
+	triggers func(id string) (Trigger, bool)
+
+	// executor runs the actions of jobs that come due
+	executor *Executor
+}
+
+type scheduledTimer struct {
+	ScheduledJob
+	trigger Trigger
+	cancel  *cancelPolicy
+	timer   Timer
+}
+
+// NewScheduler creates a Scheduler persisting its jobs to the given Store.  store may be nil, in which case jobs are
+// only held in memory.
+func NewScheduler(store *Store) *Scheduler {
+	return &Scheduler{
+		store:   store,
+		clock:   SystemClock,
+		pending: map[string]*scheduledTimer{},
+	}
+}
+
+// setClock sets the clock jobs are scheduled with
+func (s *Scheduler) setClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// Jobs returns the pending jobs, soonest first, followed by the most recently cancelled jobs, latest first
+func (s *Scheduler) Jobs() []ScheduledJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	js := make([]ScheduledJob, 0, len(s.pending)+len(s.cancelled))
+	for _, st := range s.pending {
+		js = append(js, st.ScheduledJob)
+	}
+	sort.Slice(js, func(i, j int) bool { return js[i].RunAt.Before(js[j].RunAt) })
+	for i := len(s.cancelled) - 1; i >= 0; i-- {
+		js = append(js, s.cancelled[i])
+	}
+	return js
+}
+
+// schedule creates a job running the trigger's i'th action for the event once its delay has passed
+func (s *Scheduler) schedule(logger log.Logger, t Trigger, i int, ev Event) {
+	spec := t.actionSpec(i)
+	s.mu.Lock()
+	now := s.clock.Now()
+	s.mu.Unlock()
+	j := ScheduledJob{
+		ID:          uuid.NewRandom().String(),
+		Status:      JobPending,
+		Trigger:     t.ID,
+		Action:      i,
+		Type:        spec.typ,
+		ScheduledAt: now,
+		RunAt:       now.Add(spec.delay),
+		CancelOn:    spec.cancelOn,
+		Activity:    ev.Activity,
+		Document:    ev.Document,
+	}
+	if spec.cancel != nil {
+		j.Key = docKey(spec.cancel.key, ev.Document)
+	}
+	logger.Log("msg", "delaying action", "action", spec.typ, "job", j.ID, "runs", j.RunAt)
+	s.add(logger, j, t, spec.cancel)
+}
+
+// add arms the timer of a pending job, replacing any job in the same slot
+func (s *Scheduler) add(logger log.Logger, j ScheduledJob, t Trigger, cancel *cancelPolicy) {
+	st := &scheduledTimer{ScheduledJob: j, trigger: t, cancel: cancel}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store != nil {
+		if err := s.store.AddJob(j); err != nil {
+			logger.Log("msg", "could not persist job", "err", err)
+		}
+	}
+	if old, ok := s.pending[j.slot()]; ok {
+		old.timer.Stop()
+		s.forget(logger, old.ID)
+		logger.Log("msg", "replaced pending job", "job", old.ID)
+	}
+	d := j.RunAt.Sub(s.clock.Now())
+	if d < 0 {
+		d = 0
+	}
+	st.timer = s.clock.AfterFunc(d, func() {
+		s.run(logger, st)
+	})
+	s.pending[j.slot()] = st
+}
+
+// run executes the action of a job that came due
+func (s *Scheduler) run(logger log.Logger, st *scheduledTimer) {
+	s.mu.Lock()
+	if s.pending[st.slot()] != st {
+		// Replaced or cancelled in the meantime
+		s.mu.Unlock()
+		return
+	}
+	delete(s.pending, st.slot())
+	s.forget(logger, st.ID)
+	s.mu.Unlock()
+
+	t, ok := st.trigger, st.trigger.ID != ""
+	if s.triggers != nil {
+		t, ok = s.triggers(st.Trigger)
+	}
+	if !ok || st.Action >= len(t.ParsedActions) || t.actionSpec(st.Action).typ != st.Type {
+		logger.Log("msg", "job came due, but its action is no longer configured", "job", st.ID, "action", st.Type)
+		return
+	}
+	logger.Log("msg", "job came due, executing action", "job", st.ID, "action", st.Type)
+	s.executor.executeActions(logger, t, newEvent(t, st.Activity, st.Document), []int{st.Action})
+}
+
+// cancelMatching cancels the pending jobs that the given event cancels
+func (s *Scheduler) cancelMatching(logger log.Logger, act Activity, doc interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range s.pending {
+		if st.cancel == nil || !st.cancel.events[act.Payload.Event] || docKey(st.cancel.key, doc) != st.Key {
+			continue
+		}
+		logger := log.With(logger, "trigger", st.Trigger)
+		s.cancelJob(logger, st, act.RequestID)
+		logger.Log("msg", "delayed action cancelled by follow-up event", "job", st.ID, "event", act.Payload.Event)
+	}
+}
+
+// Cancel cancels the pending job with the given ID, or returns ErrUnknownJob
+func (s *Scheduler) Cancel(logger log.Logger, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range s.pending {
+		if st.ID == id {
+			s.cancelJob(logger, st, "api")
+			logger.Log("msg", "delayed action cancelled", "job", id)
+			return nil
+		}
+	}
+	return ErrUnknownJob
+}
+
+// cancelJob stops a pending job and records it as cancelled by the given request.  The caller must hold s.mu.
+func (s *Scheduler) cancelJob(logger log.Logger, st *scheduledTimer, by string) {
+	st.timer.Stop()
+	delete(s.pending, st.slot())
+	now := s.clock.Now()
+	j := st.ScheduledJob
+	j.Status, j.CancelledAt, j.CancelledBy = JobCancelled, &now, by
+	s.cancelled = append(s.cancelled, j)
+	if s.store != nil {
+		if err := s.store.AddJob(j); err != nil {
+			logger.Log("msg", "could not persist cancelled job", "err", err)
+		}
+	}
+	for len(s.cancelled) > maxCancelledJobs {
+		s.forget(logger, s.cancelled[0].ID)
+		s.cancelled = s.cancelled[1:]
+	}
+}
+
+// restore re-arms the pending jobs persisted by a previous run, and reloads the cancelled ones.  Jobs that came due
+// while plexus was down run immediately.
+func (s *Scheduler) restore(logger log.Logger) error {
+	if s.store == nil {
+		return nil
+	}
+	js, err := s.store.GetAllJobs()
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, j := range js {
+		if j.Status == JobCancelled {
+			s.mu.Lock()
+			s.cancelled = append(s.cancelled, j)
+			s.mu.Unlock()
+			continue
+		}
+		cancel, err := j.CancelOn.compile()
+		if err != nil {
+			logger.Log("msg", "could not restore job", "job", j.ID, "err", err)
+			continue
+		}
+		s.add(log.With(logger, "trigger", j.Trigger), j, Trigger{}, cancel)
+		n++
+	}
+	s.mu.Lock()
+	sort.Slice(s.cancelled, func(i, j int) bool { return s.cancelled[i].CancelledAt.Before(*s.cancelled[j].CancelledAt) })
+	s.mu.Unlock()
+	if n > 0 {
+		logger.Log("msg", "restored pending jobs", "count", n)
+	}
+	return nil
+}
+
+// stop stops the timers of the pending jobs, which stay persisted for the next run
+func (s *Scheduler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for slot, st := range s.pending {
+		st.timer.Stop()
+		delete(s.pending, slot)
+	}
+}
+
+// forget removes a persisted job.  The caller must hold s.mu.
+func (s *Scheduler) forget(logger log.Logger, id string) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.DeleteJob(id); err != nil {
+		logger.Log("msg", "could not remove persisted job", "err", err)
+	}
+}
@@ -0,0 +1,207 @@
+package plex
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+const schedulerConfig = `{
+	"triggers": [
+		{
+			"id": "lights",
+			"properties": {"event": "media.stop"},
+			"actions": [
+				{"type": "counting"},
+				{
+					"type": "counting",
+					"delay": "10m",
+					"cancelOn": {"events": ["media.play", "media.resume"], "key": ["Player.uuid"]}
+				}
+			]
+		}
+	]
+}`
+
+// scheduledEngine loads schedulerConfig into an Engine backed by store
+func scheduledEngine(t *testing.T, store *Store, clock Clock) *Engine {
+	cfg, err := NewConfig(strings.NewReader(schedulerConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	cfg.Clock = clock
+	return NewEngine(cfg, store)
+}
+
+func TestScheduler(t *testing.T) {
+	countedEvents = nil
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	e := scheduledEngine(t, nil, clock)
+
+	// Stopped and resumed in time; only the immediate action runs
+	send(t, e, clock, "media.stop", "a")
+	if len(countedEvents) != 1 {
+		t.Fatalf("Expected the immediate action to run, got %d executions", len(countedEvents))
+	}
+	if js := e.Jobs(); len(js) != 1 || js[0].Status != JobPending || js[0].Key == "" || !js[0].RunAt.Equal(clock.Now().Add(10*time.Minute)) {
+		t.Fatalf("Expected a pending job in 10 minutes, got %+v", js)
+	}
+	clock.Advance(time.Minute)
+	send(t, e, clock, "media.resume", "a")
+	clock.Advance(10 * time.Minute)
+	if len(countedEvents) != 1 {
+		t.Fatalf("Expected the resume to cancel the delayed action, got %d executions", len(countedEvents))
+	}
+	js := e.Jobs()
+	if len(js) != 1 || js[0].Status != JobCancelled || js[0].CancelledAt == nil {
+		t.Fatalf("Expected a cancelled job, got %+v", js)
+	}
+
+	// Stopped, and another player resumes
+	countedEvents = nil
+	send(t, e, clock, "media.stop", "a")
+	send(t, e, clock, "media.play", "b")
+	clock.Advance(10 * time.Minute)
+	if len(countedEvents) != 2 || countedEvents[1].Activity.Payload.Player.UUID != "a" {
+		t.Fatalf("Expected the delayed action to run for player a, got %+v", countedEvents)
+	}
+
+	// Stopped again while pending replaces the job
+	countedEvents = nil
+	send(t, e, clock, "media.stop", "a")
+	clock.Advance(5 * time.Minute)
+	send(t, e, clock, "media.stop", "a")
+	clock.Advance(5 * time.Minute)
+	if len(countedEvents) != 2 {
+		t.Fatalf("Expected the second stop to replace the job, got %d executions", len(countedEvents))
+	}
+	clock.Advance(5 * time.Minute)
+	if len(countedEvents) != 3 {
+		t.Fatalf("Expected the delayed action to run once, got %d executions", len(countedEvents))
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	countedEvents = nil
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	e := scheduledEngine(t, nil, clock)
+	send(t, e, clock, "media.stop", "a")
+	js := e.Jobs()
+	if len(js) != 1 {
+		t.Fatalf("Expected a pending job, got %+v", js)
+	}
+	if err := e.CancelJob(log.NewNopLogger(), "nope"); err != ErrUnknownJob {
+		t.Errorf("Expected ErrUnknownJob, got %v", err)
+	}
+	if err := e.CancelJob(log.NewNopLogger(), js[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(10 * time.Minute)
+	if len(countedEvents) != 1 {
+		t.Fatalf("Expected the delayed action to be cancelled, got %d executions", len(countedEvents))
+	}
+	if js := e.Jobs(); len(js) != 1 || js[0].CancelledBy != "api" {
+		t.Fatalf("Expected a job cancelled by the api, got %+v", js)
+	}
+	if err := e.CancelJob(log.NewNopLogger(), js[0].ID); err != ErrUnknownJob {
+		t.Errorf("Expected cancelling twice to return ErrUnknownJob, got %v", err)
+	}
+}
+
+func TestSchedulerRestore(t *testing.T) {
+	countedEvents = nil
+	store, cleanup := tempStore(t)
+	defer cleanup()
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	e := scheduledEngine(t, store, clock)
+	send(t, e, clock, "media.stop", "a")
+	send(t, e, clock, "media.stop", "b")
+	send(t, e, clock, "media.stop", "c")
+	send(t, e, clock, "media.play", "b")
+	clock.Advance(time.Minute)
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	js, err := store.GetAllJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(js) != 3 {
+		t.Fatalf("Expected 2 pending and 1 cancelled job to be persisted, got %+v", js)
+	}
+
+	// Restart with a fresh clock and engine
+	countedEvents = nil
+	clock = newFakeClock(clock.Now().Add(2 * time.Minute))
+	e = scheduledEngine(t, store, clock)
+	if err := e.Restore(log.NewNopLogger()); err != nil {
+		t.Fatal(err)
+	}
+	js = e.Jobs()
+	if len(js) != 3 || js[0].Status != JobPending || js[1].Status != JobPending || js[2].Status != JobCancelled {
+		t.Fatalf("Expected 2 restored pending jobs and 1 cancelled job, got %+v", js)
+	}
+	send(t, e, clock, "media.resume", "c")
+	clock.Advance(7 * time.Minute)
+	if len(countedEvents) != 1 || countedEvents[0].Activity.Payload.Player.UUID != "a" {
+		t.Fatalf("Expected the restored job to run for player a, got %+v", countedEvents)
+	}
+	if js, err := store.GetAllJobs(); err != nil || len(js) != 2 {
+		t.Fatalf("Expected only the cancelled jobs to remain persisted, got %+v, %v", js, err)
+	}
+}
+
+func TestNewConfigInvalidDelay(t *testing.T) {
+	for _, raw := range []string{
+		`{"type": "counting", "delay": "soon"}`,
+		`{"type": "counting", "delay": "-1m"}`,
+		`{"type": "counting", "cancelOn": {"events": ["media.play"]}}`,
+		`{"type": "counting", "delay": "1m", "cancelOn": {"events": []}}`,
+	} {
+		_, err := NewConfig(strings.NewReader(`{"triggers": [{"actions": [` + raw + `]}]}`))
+		if err == nil {
+			t.Errorf("Expected an error for %s", raw)
+		}
+	}
+}
+
+func TestSchedulerWithoutKey(t *testing.T) {
+	countedEvents = nil
+	clock := newFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	cfg, err := NewConfig(strings.NewReader(`{"triggers": [{
+		"id": "lights",
+		"properties": {"event": "media.stop"},
+		"actions": [{"type": "counting", "delay": "10m", "cancelOn": {"events": ["media.play"]}}]
+	}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Clock = clock
+	e := NewEngine(cfg, nil)
+
+	// Stops from two players each schedule a job
+	send(t, e, clock, "media.stop", "a")
+	clock.Advance(time.Minute)
+	send(t, e, clock, "media.stop", "b")
+	if js := e.Jobs(); len(js) != 2 {
+		t.Fatalf("Expected 2 pending jobs, got %+v", js)
+	}
+	clock.Advance(10 * time.Minute)
+	if len(countedEvents) != 2 || countedEvents[0].Activity.Payload.Player.UUID != "a" || countedEvents[1].Activity.Payload.Player.UUID != "b" {
+		t.Fatalf("Expected the delayed action to run for players a and b, got %+v", countedEvents)
+	}
+
+	// Without a key, any play cancels every job
+	countedEvents = nil
+	send(t, e, clock, "media.stop", "a")
+	send(t, e, clock, "media.stop", "b")
+	send(t, e, clock, "media.play", "c")
+	clock.Advance(10 * time.Minute)
+	if len(countedEvents) != 0 {
+		t.Fatalf("Expected the play to cancel both jobs, got %d executions", len(countedEvents))
+	}
+}
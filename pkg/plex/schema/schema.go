@@ -8,7 +8,7 @@ import (
 )
 
 type Validator struct {
-	sLoader gojsonschema.JSONLoader
+	schema *gojsonschema.Schema
 }
 
 func NewValidator() (*Validator, error) {
@@ -17,15 +17,23 @@ func NewValidator() (*Validator, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Compile the schema once, rather than for every payload
+	s, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(schema))
+	if err != nil {
+		return nil, err
+	}
 	v := Validator{
-		sLoader: gojsonschema.NewStringLoader(schema),
+		schema: s,
 	}
 	return &v, nil
 }
 
 func (v *Validator) Validate(bytes []byte) error {
-	doc := gojsonschema.NewStringLoader(string(bytes))
-	result, err := gojsonschema.Validate(v.sLoader, doc)
+	return v.validate(gojsonschema.NewBytesLoader(bytes))
+}
+
+func (v *Validator) validate(doc gojsonschema.JSONLoader) error {
+	result, err := v.schema.Validate(doc)
 	if err != nil {
 		return err
 	}
@@ -2,15 +2,28 @@ package plex
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"time"
 
 	scribble "github.com/nanobox-io/golang-scribble"
+	"github.com/pborman/uuid"
 )
 
+const (
+	pendingCollection    = "pending"
+	deadLetterCollection = "deadletters"
+	jobsCollection       = "jobs"
+)
+
+// ErrUnknownThumb is returned when there is no thumb for a request
+var ErrUnknownThumb = errors.New("unknown thumb")
+
 type Activity struct {
 	ReceivedAt time.Time      `json:"receivedAt"`
 	RequestID  string         `json:"requestId"`
@@ -66,3 +79,119 @@ func (s *Store) AddThumb(reqID string, origFilename string, thumb []byte) (strin
 	err := ioutil.WriteFile(fp, thumb, 0644)
 	return fp, err
 }
+
+// GetThumb returns the path of the thumb saved with the activity of the given request, or ErrUnknownThumb
+func (s *Store) GetThumb(reqID string) (string, error) {
+	if uuid.Parse(reqID) == nil {
+		return "", ErrUnknownThumb
+	}
+	act := Activity{}
+	err := s.db.Read("activity", reqID, &act)
+	if os.IsNotExist(err) || (err == nil && act.ThumbPath == "") {
+		return "", ErrUnknownThumb
+	}
+	return act.ThumbPath, err
+}
+
+// AddPending saves the given pending correlation timer, replacing any with the same ID
+func (s *Store) AddPending(p Pending) error {
+	return s.db.Write(pendingCollection, p.ID, p)
+}
+
+// DeletePending removes the pending correlation timer with the given ID
+func (s *Store) DeletePending(id string) error {
+	return s.db.Delete(pendingCollection, id)
+}
+
+// GetAllPending returns every pending correlation timer in the Store
+func (s *Store) GetAllPending() ([]Pending, error) {
+	ps := []Pending{}
+	err := s.readAll(pendingCollection, func(b []byte) error {
+		p := Pending{}
+		if err := json.Unmarshal(b, &p); err != nil {
+			return err
+		}
+		ps = append(ps, p)
+		return nil
+	})
+	return ps, err
+}
+
+// AddDeadLetter saves the given dead letter, replacing any with the same ID
+func (s *Store) AddDeadLetter(d DeadLetter) error {
+	return s.db.Write(deadLetterCollection, d.ID, d)
+}
+
+// DeleteDeadLetter removes the dead letter with the given ID
+func (s *Store) DeleteDeadLetter(id string) error {
+	return s.db.Delete(deadLetterCollection, id)
+}
+
+// GetDeadLetter returns the dead letter with the given ID, or ErrUnknownDeadLetter
+func (s *Store) GetDeadLetter(id string) (DeadLetter, error) {
+	d := DeadLetter{}
+	if uuid.Parse(id) == nil {
+		return d, ErrUnknownDeadLetter
+	}
+	err := s.db.Read(deadLetterCollection, id, &d)
+	if os.IsNotExist(err) {
+		return d, ErrUnknownDeadLetter
+	}
+	return d, err
+}
+
+// GetAllDeadLetters returns every dead letter in the Store, most recent first
+func (s *Store) GetAllDeadLetters() ([]DeadLetter, error) {
+	ds := []DeadLetter{}
+	err := s.readAll(deadLetterCollection, func(b []byte) error {
+		d := DeadLetter{}
+		if err := json.Unmarshal(b, &d); err != nil {
+			return err
+		}
+		ds = append(ds, d)
+		return nil
+	})
+	sort.Slice(ds, func(i, j int) bool { return ds[i].FailedAt.After(ds[j].FailedAt) })
+	return ds, err
+}
+
+// AddJob saves the given scheduled job, replacing any with the same ID
+func (s *Store) AddJob(j ScheduledJob) error {
+	return s.db.Write(jobsCollection, j.ID, j)
+}
+
+// DeleteJob removes the scheduled job with the given ID
+func (s *Store) DeleteJob(id string) error {
+	return s.db.Delete(jobsCollection, id)
+}
+
+// GetAllJobs returns every scheduled job in the Store
+func (s *Store) GetAllJobs() ([]ScheduledJob, error) {
+	js := []ScheduledJob{}
+	err := s.readAll(jobsCollection, func(b []byte) error {
+		j := ScheduledJob{}
+		if err := json.Unmarshal(b, &j); err != nil {
+			return err
+		}
+		js = append(js, j)
+		return nil
+	})
+	return js, err
+}
+
+// readAll calls fn with every record in the collection.  A collection that has never been written to is empty.
+func (s *Store) readAll(collection string, fn func([]byte) error) error {
+	recs, err := s.db.ReadAll(collection)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, r := range recs {
+		if err := fn([]byte(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
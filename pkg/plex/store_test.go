@@ -0,0 +1,48 @@
+package plex
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pborman/uuid"
+)
+
+// tempStore creates a Store in a temporary directory, returning it with a func that removes the directory
+func tempStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "plexus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestStoreGetThumb(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	id := uuid.NewRandom().String()
+	tp, err := s.AddThumb(id, "thumb.jpg", []byte("JPG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddActivity(Activity{RequestID: id, ThumbPath: tp}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.GetThumb(id); err != nil || got != tp {
+		t.Errorf("Expected thumb %q, got %q (%v)", tp, got, err)
+	}
+	other := uuid.NewRandom().String()
+	if err := s.AddActivity(Activity{RequestID: other}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{other, uuid.NewRandom().String(), "../activity"} {
+		if _, err := s.GetThumb(id); err != ErrUnknownThumb {
+			t.Errorf("%s: expected ErrUnknownThumb, got %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package plex
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Location is the position on earth used to compute sunrise and sunset for time conditions
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (l Location) validate() error {
+	if l.Latitude < -90 || l.Latitude > 90 {
+		return fmt.Errorf("invalid latitude %v", l.Latitude)
+	}
+	if l.Longitude < -180 || l.Longitude > 180 {
+		return fmt.Errorf("invalid longitude %v", l.Longitude)
+	}
+	return nil
+}
+
+const (
+	sunrise = "sunrise"
+	sunset  = "sunset"
+
+	// julian date of the unix epoch, and of the J2000 epoch
+	julianUnixEpoch = 2440587.5
+	julian2000      = 2451545.0
+)
+
+// sunTimes computes sunrise and sunset at the given location on the calendar date of day, using the sunrise equation
+// (https://en.wikipedia.org/wiki/Sunrise_equation).  ok is false when the sun does not rise or set that day (polar
+// day or night).  The results are accurate to within a couple of minutes, which is plenty for dimming the lights.
+func sunTimes(day time.Time, l Location) (rise, set time.Time, ok bool) {
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.UTC)
+	n := math.Round(float64(noon.Unix())/86400 + julianUnixEpoch - julian2000 + 0.0008)
+
+	// Mean solar time
+	j := n - l.Longitude/360
+	// Solar mean anomaly
+	m := math.Mod(357.5291+0.98560028*j, 360)
+	mr := m * math.Pi / 180
+	// Equation of the center
+	c := 1.9148*math.Sin(mr) + 0.0200*math.Sin(2*mr) + 0.0003*math.Sin(3*mr)
+	// Ecliptic longitude
+	lambda := math.Mod(m+c+180+102.9372, 360) * math.Pi / 180
+	// Solar transit
+	transit := julian2000 + j + 0.0053*math.Sin(mr) - 0.0069*math.Sin(2*lambda)
+	// Declination of the sun
+	sinDecl := math.Sin(lambda) * math.Sin(23.4397*math.Pi/180)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	// Hour angle, accounting for refraction and the solar disc
+	phi := l.Latitude * math.Pi / 180
+	cosOmega := (math.Sin(-0.833*math.Pi/180) - math.Sin(phi)*sinDecl) / (math.Cos(phi) * cosDecl)
+	if cosOmega < -1 || cosOmega > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	omega := math.Acos(cosOmega) * 180 / math.Pi
+
+	return julianToTime(transit - omega/360), julianToTime(transit + omega/360), true
+}
+
+func julianToTime(jd float64) time.Time {
+	secs := (jd - julianUnixEpoch) * 86400
+	return time.Unix(0, int64(secs*float64(time.Second))).UTC()
+}
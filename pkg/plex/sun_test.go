@@ -0,0 +1,164 @@
+package plex
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSunTimes(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	tests := []struct {
+		name    string
+		day     time.Time
+		loc     Location
+		ok      bool
+		sunrise time.Time
+		sunset  time.Time
+	}{
+		{
+			"new york summer solstice",
+			time.Date(2019, 6, 21, 12, 0, 0, 0, ny),
+			Location{Latitude: 40.7128, Longitude: -74.0060},
+			true,
+			time.Date(2019, 6, 21, 5, 25, 0, 0, ny),
+			time.Date(2019, 6, 21, 20, 31, 0, 0, ny),
+		},
+		{
+			"new york winter solstice",
+			time.Date(2019, 12, 21, 12, 0, 0, 0, ny),
+			Location{Latitude: 40.7128, Longitude: -74.0060},
+			true,
+			time.Date(2019, 12, 21, 7, 17, 0, 0, ny),
+			time.Date(2019, 12, 21, 16, 32, 0, 0, ny),
+		},
+		{
+			"sydney winter",
+			time.Date(2019, 6, 21, 12, 0, 0, 0, sydney),
+			Location{Latitude: -33.8688, Longitude: 151.2093},
+			true,
+			time.Date(2019, 6, 21, 7, 0, 0, 0, sydney),
+			time.Date(2019, 6, 21, 16, 54, 0, 0, sydney),
+		},
+		{
+			"midnight sun",
+			time.Date(2019, 6, 21, 12, 0, 0, 0, time.UTC),
+			Location{Latitude: 69.6492, Longitude: 18.9553},
+			false,
+			time.Time{},
+			time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rise, set, ok := sunTimes(tt.day, tt.loc)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok to be %v, got %v", tt.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			if d := rise.Sub(tt.sunrise); d > 3*time.Minute || d < -3*time.Minute {
+				t.Errorf("Expected sunrise near %v, got %v", tt.sunrise, rise.In(tt.sunrise.Location()))
+			}
+			if d := set.Sub(tt.sunset); d > 3*time.Minute || d < -3*time.Minute {
+				t.Errorf("Expected sunset near %v, got %v", tt.sunset, set.In(tt.sunset.Location()))
+			}
+		})
+	}
+}
+
+func TestTriggerActiveAtSun(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	cfg, err := NewConfig(strings.NewReader(`{
+		"location": {"latitude": 40.7128, "longitude": -74.0060},
+		"triggers": [
+			{
+				"properties": {},
+				"when": {
+					"timezone": "America/New_York",
+					"times": [{"after": "sunset-30m", "before": "sunrise"}]
+				}
+			},
+			{
+				"properties": {},
+				"when": {
+					"timezone": "America/New_York",
+					"times": [{"after": "sunset+5h", "before": "sunrise"}]
+				}
+			},
+			{
+				"properties": {},
+				"when": {
+					"timezone": "America/New_York",
+					"times": [{"after": "sunrise-8h", "before": "sunrise+30m"}]
+				}
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		trigger int
+		at      time.Time
+		active  bool
+	}{
+		{"summer afternoon", 0, time.Date(2019, 6, 21, 19, 30, 0, 0, ny), false},
+		{"summer dusk", 0, time.Date(2019, 6, 21, 20, 5, 0, 0, ny), true},
+		{"summer night", 0, time.Date(2019, 6, 21, 23, 0, 0, 0, ny), true},
+		{"summer early morning", 0, time.Date(2019, 6, 21, 5, 0, 0, 0, ny), true},
+		{"summer morning", 0, time.Date(2019, 6, 21, 6, 0, 0, 0, ny), false},
+		{"winter afternoon", 0, time.Date(2019, 12, 21, 16, 5, 0, 0, ny), true},
+		{"winter early morning", 0, time.Date(2019, 12, 21, 7, 0, 0, 0, ny), true},
+		{"winter morning", 0, time.Date(2019, 12, 21, 7, 30, 0, 0, ny), false},
+		// Sunset is around 20:30 in the summer, so sunset+5h is past midnight
+		{"offset past midnight, before midnight", 1, time.Date(2019, 6, 21, 23, 0, 0, 0, ny), false},
+		{"offset past midnight, after midnight", 1, time.Date(2019, 6, 21, 0, 30, 0, 0, ny), false},
+		{"offset past midnight, in the window", 1, time.Date(2019, 6, 21, 2, 0, 0, 0, ny), true},
+		{"offset past midnight, after sunrise", 1, time.Date(2019, 6, 21, 6, 0, 0, 0, ny), false},
+		// Sunrise is around 05:25 in the summer, so sunrise-8h is before midnight
+		{"offset before midnight, evening", 2, time.Date(2019, 6, 21, 20, 0, 0, 0, ny), false},
+		{"offset before midnight, night", 2, time.Date(2019, 6, 21, 22, 0, 0, 0, ny), true},
+		{"offset before midnight, early morning", 2, time.Date(2019, 6, 21, 5, 45, 0, 0, ny), true},
+		{"offset before midnight, morning", 2, time.Date(2019, 6, 21, 6, 0, 0, 0, ny), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if a := cfg.Triggers[tt.trigger].ActiveAt(tt.at); a != tt.active {
+				t.Errorf("Expected ActiveAt to be %v, got %v", tt.active, a)
+			}
+		})
+	}
+}
+
+func TestNewConfigInvalidSunTimes(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+	}{
+		{"no location", `{"triggers": [{"when": {"times": [{"after": "sunset"}]}}]}`},
+		{"bad offset", `{"location": {"latitude": 1, "longitude": 1}, "triggers": [{"when": {"times": [{"after": "sunset30m"}]}}]}`},
+		{"bad duration", `{"location": {"latitude": 1, "longitude": 1}, "triggers": [{"when": {"times": [{"after": "sunset-30x"}]}}]}`},
+		{"bad latitude", `{"location": {"latitude": 91, "longitude": 1}, "triggers": []}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewConfig(strings.NewReader(tt.cfg)); err == nil {
+				t.Errorf("Expected an error loading config, got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package plex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are the helper functions available to action templates, in addition to text/template's builtins
+var templateFuncs = template.FuncMap{
+	"json":       templateJSON,
+	"default":    templateDefault,
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"title":      strings.Title,
+	"trim":       strings.TrimSpace,
+	"replace":    templateReplace,
+	"formatTime": templateFormatTime,
+}
+
+// Template is an action setting rendered with text/template, or html/template for HTML, for each event.  See
+// templateData for what is available to the template.
+type Template struct {
+	text string
+	tmpl interface {
+		Execute(w io.Writer, data interface{}) error
+	}
+}
+
+// compileTemplate parses the given template text, naming it for error messages
+func compileTemplate(name, text string) (*Template, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{text: text, tmpl: t}, nil
+}
+
+// templateField is an optional template setting of an action, compiled into tmpl
+type templateField struct {
+	name string
+	text string
+	tmpl **Template
+}
+
+// compileTemplates compiles the template settings of the given action type, skipping those left empty
+func compileTemplates(action string, fields ...templateField) error {
+	for _, f := range fields {
+		if f.text == "" {
+			continue
+		}
+		t, err := compileTemplate(f.name, f.text)
+		if err != nil {
+			return fmt.Errorf("invalid %s %s template: %v", action, f.name, err)
+		}
+		*f.tmpl = t
+	}
+	return nil
+}
+
+// compileHTMLTemplate parses the given template text with html/template, so that the values it renders are escaped
+// for HTML
+func compileHTMLTemplate(name, text string) (*Template, error) {
+	t, err := htmltemplate.New(name).Funcs(htmltemplate.FuncMap(templateFuncs)).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{text: text, tmpl: t}, nil
+}
+
+// render executes the template over the given event
+func (t *Template) render(ev Event) (string, error) {
+	buf := bytes.Buffer{}
+	if err := t.tmpl.Execute(&buf, newTemplateData(ev)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// templateValue is a JSON value whose strings, however deeply nested, are templates
+type templateValue struct {
+	tmpl  *Template
+	list  []templateValue
+	obj   map[string]templateValue
+	value interface{}
+}
+
+// compileTemplateValue compiles every string in the JSON value v
+func compileTemplateValue(name string, v interface{}) (templateValue, error) {
+	tv := templateValue{}
+	var err error
+	switch t := v.(type) {
+	case string:
+		tv.tmpl, err = compileTemplate(name, t)
+	case []interface{}:
+		tv.list = make([]templateValue, len(t))
+		for i, e := range t {
+			if tv.list[i], err = compileTemplateValue(fmt.Sprintf("%s[%d]", name, i), e); err != nil {
+				break
+			}
+		}
+	case map[string]interface{}:
+		tv.obj = make(map[string]templateValue, len(t))
+		for k, e := range t {
+			if tv.obj[k], err = compileTemplateValue(name+"."+k, e); err != nil {
+				break
+			}
+		}
+	default:
+		tv.value = v
+	}
+	return tv, err
+}
+
+// render renders every template in the value over the given event
+func (tv templateValue) render(ev Event) (interface{}, error) {
+	switch {
+	case tv.tmpl != nil:
+		return tv.tmpl.render(ev)
+	case tv.list != nil:
+		l := make([]interface{}, len(tv.list))
+		for i, e := range tv.list {
+			v, err := e.render(ev)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = v
+		}
+		return l, nil
+	case tv.obj != nil:
+		m := make(map[string]interface{}, len(tv.obj))
+		for k, e := range tv.obj {
+			v, err := e.render(ev)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	}
+	return tv.value, nil
+}
+
+// templateData is the data action templates are rendered with, e.g. {{.Payload.Player.Title}} for the typed payload,
+// {{.Raw.Player.title}} for any field of the payload as received, and {{.Request.ID}} for request metadata
+type templateData struct {
+	Payload WebhookPayload
+	Raw     interface{}
+	Request templateRequest
+	Trigger string
+}
+
+type templateRequest struct {
+	ID         string
+	ReceivedAt time.Time
+	ThumbPath  string
+}
+
+func newTemplateData(ev Event) templateData {
+	return templateData{
+		Payload: ev.Activity.Payload,
+		Raw:     ev.Document,
+		Request: templateRequest{
+			ID:         ev.Activity.RequestID,
+			ReceivedAt: ev.Activity.ReceivedAt,
+			ThumbPath:  ev.Activity.ThumbPath,
+		},
+		Trigger: ev.Trigger,
+	}
+}
+
+// templateJSON encodes v as JSON, e.g. {{.Payload.Metadata.Title | json}} for a quoted and escaped string
+func templateJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// templateDefault returns def when v is missing or empty, e.g. {{.Raw.Metadata.year | default "unknown"}}
+func templateDefault(def, v interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if rv.Len() == 0 {
+			return def
+		}
+	case reflect.Bool:
+		if !rv.Bool() {
+			return def
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() == 0 {
+			return def
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() == 0 {
+			return def
+		}
+	case reflect.Float32, reflect.Float64:
+		if rv.Float() == 0 {
+			return def
+		}
+	}
+	return v
+}
+
+// templateReplace replaces every occurrence of old with new in s, e.g. {{.Payload.Player.Title | replace " " "_"}}
+func templateReplace(old, new, s string) string {
+	return strings.Replace(s, old, new, -1)
+}
+
+// templateFormatTime formats a time.Time or a unix timestamp with a Go layout, e.g. {{.Request.ReceivedAt | formatTime
+// "15:04"}} or {{.Payload.Metadata.AddedAt | formatTime "2006-01-02"}}
+func templateFormatTime(layout string, v interface{}) (string, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case int:
+		return time.Unix(int64(t), 0).UTC().Format(layout), nil
+	case int64:
+		return time.Unix(t, 0).UTC().Format(layout), nil
+	case float64:
+		return time.Unix(int64(t), 0).UTC().Format(layout), nil
+	}
+	return "", fmt.Errorf("cannot format %T as a time", v)
+}
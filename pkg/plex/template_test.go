@@ -0,0 +1,119 @@
+package plex
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// templateEvent is a media.play event from the living room player, received at a fixed time
+func templateEvent(t *testing.T) Event {
+	raw := []byte(`{"event": "media.play", "Player": {"title": "Living Room"}, "Metadata": {"title": "Alien", "year": 1979, "addedAt": 1559347200}}`)
+	doc, err := ParseDocument(raw)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing payload: %v", err)
+	}
+	ev := Event{Document: doc, Trigger: "living-room"}
+	ev.Activity.RequestID = "abc123"
+	ev.Activity.ReceivedAt = time.Date(2019, 6, 1, 21, 30, 0, 0, time.UTC)
+	ev.Activity.Payload.Event = "media.play"
+	ev.Activity.Payload.Player.Title = "Living Room"
+	ev.Activity.Payload.Metadata.Title = "Alien"
+	ev.Activity.Payload.Metadata.AddedAt = 1559347200
+	return ev
+}
+
+func TestTemplateRender(t *testing.T) {
+	ev := templateEvent(t)
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"literal", "http://example.com", "http://example.com"},
+		{"payload", "{{.Payload.Player.Title}}", "Living Room"},
+		{"raw", "{{.Raw.Metadata.year}}", "1979"},
+		{"request", "{{.Request.ID}} {{.Trigger}}", "abc123 living-room"},
+		{"json", `{"title": {{.Payload.Metadata.Title | json}}}`, `{"title": "Alien"}`},
+		{"default missing", `{{.Raw.Metadata.studio | default "unknown"}}`, "unknown"},
+		{"default empty", `{{.Payload.Account.Title | default "nobody"}}`, "nobody"},
+		{"default present", `{{.Payload.Metadata.Title | default "unknown"}}`, "Alien"},
+		{"case", "{{.Payload.Event | upper}} {{.Payload.Player.Title | lower}} {{title \"the thing\"}}", "MEDIA.PLAY living room The Thing"},
+		{"trim and replace", `{{" Living Room " | trim | replace " " "_"}}`, "Living_Room"},
+		{"urlquery", "{{.Payload.Player.Title | urlquery}}", "Living+Room"},
+		{"format time", `{{.Request.ReceivedAt | formatTime "15:04"}}`, "21:30"},
+		{"format unix", `{{.Payload.Metadata.AddedAt | formatTime "2006-01-02"}}`, "2019-06-01"},
+		{"format raw unix", `{{.Raw.Metadata.addedAt | formatTime "2006-01-02"}}`, "2019-06-01"},
+	}
+	for _, tt := range tests {
+		tmpl, err := compileTemplate(tt.name, tt.text)
+		if err != nil {
+			t.Errorf("%s: unexpected error compiling template: %v", tt.name, err)
+			continue
+		}
+		got, err := tmpl.render(ev)
+		if err != nil {
+			t.Errorf("%s: unexpected error rendering template: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestNewConfigInvalidTemplate(t *testing.T) {
+	for _, action := range []string{
+		`{"type": "webhook", "config": {"url": "http://example.com/{{.Payload.Event"}}`,
+		`{"type": "webhook", "config": {"url": "http://example.com", "body": "{{nope}}"}}`,
+		`{"type": "webhook", "config": {"url": "http://example.com", "headers": {"X-Title": "{{end}}"}}}`,
+		`{"type": "webhook", "config": {"url": "http://example.com", "headers": {"X-Count": 1}}}`,
+	} {
+		_, err := NewConfig(strings.NewReader(`{"triggers": [{"properties": {}, "actions": [` + action + `]}]}`))
+		if err == nil {
+			t.Errorf("Expected an error loading action %s, got none", action)
+		}
+	}
+}
+
+func TestWebhookActionExecute(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got, body = r, string(b)
+	}))
+	defer srv.Close()
+
+	cfg, err := NewConfig(strings.NewReader(`{"triggers": [{"properties": {}, "actions": [{"type": "webhook", "config": {
+		"url": "` + srv.URL + `/{{.Payload.Event}}?player={{.Payload.Player.Title | urlquery}}",
+		"action": "POST",
+		"headers": {"X-Trigger": "{{.Trigger}}"},
+		"body": "{\"value1\": {{.Payload.Metadata.Title | json}}}"
+	}}]}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	if err := cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t)); err != nil {
+		t.Fatalf("Unexpected error executing action: %v", err)
+	}
+	if got == nil {
+		t.Fatalf("Expected the webhook to be requested, it was not")
+	}
+	if got.Method != "POST" || got.URL.Path != "/media.play" || got.URL.Query().Get("player") != "Living Room" {
+		t.Errorf("Unexpected request %s %s", got.Method, got.URL)
+	}
+	if h := got.Header.Get("X-Trigger"); h != "living-room" {
+		t.Errorf("Expected X-Trigger header %q, got %q", "living-room", h)
+	}
+	if ct := got.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type %q, got %q", "application/json", ct)
+	}
+	if body != `{"value1": "Alien"}` {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
@@ -0,0 +1,116 @@
+package plex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/clocklear/plexus/pkg/plex/schema"
+)
+
+// TriggerTest is a sample payload embedded in a trigger, along with whether the trigger is expected to match it.
+// When ReceivedAt is given, the trigger's when block is evaluated at that time too.
+type TriggerTest struct {
+	Name       string          `json:"name,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
+	Match      bool            `json:"match"`
+	ReceivedAt *time.Time      `json:"receivedAt,omitempty"`
+}
+
+// validate checks that the test's payload is a webhook payload Plex could have sent
+func (tc TriggerTest) validate(v *schema.Validator) error {
+	if err := v.Validate(tc.Payload); err != nil {
+		return err
+	}
+	return json.Unmarshal(tc.Payload, &WebhookPayload{})
+}
+
+// TestResult is the outcome of a single TriggerTest
+type TestResult struct {
+	Trigger  string `json:"trigger"`
+	Name     string `json:"name"`
+	Expected bool   `json:"expected"`
+	Actual   bool   `json:"actual"`
+	// Error is set when the test's payload could not be decoded, which fails the test
+	Error string `json:"error,omitempty"`
+}
+
+// Passed reports whether the trigger behaved as expected
+func (r TestResult) Passed() bool {
+	return r.Error == "" && r.Expected == r.Actual
+}
+
+// TestReport holds the outcome of every TriggerTest in a Config
+type TestReport []TestResult
+
+// Failed returns the results of the tests that did not pass
+func (r TestReport) Failed() TestReport {
+	f := TestReport{}
+	for _, res := range r {
+		if !res.Passed() {
+			f = append(f, res)
+		}
+	}
+	return f
+}
+
+// Write writes a human readable report to w
+func (r TestReport) Write(w io.Writer) error {
+	for _, res := range r {
+		status := "PASS"
+		detail := ""
+		switch {
+		case res.Error != "":
+			status = "FAIL"
+			detail = fmt.Sprintf(" (invalid payload: %s)", res.Error)
+		case !res.Passed():
+			status = "FAIL"
+			detail = fmt.Sprintf(" (expected %s, got %s)", describeMatch(res.Expected), describeMatch(res.Actual))
+		}
+		if _, err := fmt.Fprintf(w, "%s %s: %s%s\n", status, res.Trigger, res.Name, detail); err != nil {
+			return err
+		}
+	}
+	failed := len(r.Failed())
+	_, err := fmt.Fprintf(w, "%d passed, %d failed\n", len(r)-failed, failed)
+	return err
+}
+
+func describeMatch(m bool) string {
+	if m {
+		return "match"
+	}
+	return "no match"
+}
+
+// RunTests runs every test case embedded in the Config's triggers through Trigger.Matches.  A payload that cannot be
+// decoded fails its test.
+func (c Config) RunTests() TestReport {
+	r := TestReport{}
+	for _, t := range c.Triggers {
+		for i, tc := range t.Tests {
+			name := tc.Name
+			if name == "" {
+				name = fmt.Sprintf("test %d", i+1)
+			}
+			res := TestResult{
+				Trigger:  t.ID,
+				Name:     name,
+				Expected: tc.Match,
+			}
+			doc, err := ParseDocument(tc.Payload)
+			if err != nil {
+				res.Error = err.Error()
+				r = append(r, res)
+				continue
+			}
+			res.Actual = t.Matches(doc)
+			if res.Actual && tc.ReceivedAt != nil {
+				res.Actual = t.ActiveAt(*tc.ReceivedAt)
+			}
+			r = append(r, res)
+		}
+	}
+	return r
+}
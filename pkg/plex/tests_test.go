@@ -0,0 +1,104 @@
+package plex
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testPayload returns a complete webhook payload of the given event, played on the given player
+func testPayload(event, player string) string {
+	return fmt.Sprintf(`{
+		"event": %q, "user": true, "owner": true,
+		"Account": {"id": 1, "thumb": "https://plex.tv/users/1/avatar", "title": "someone"},
+		"Server": {"title": "server", "uuid": "54664a3d8acc39983675640ec9ce00b70af9cc36"},
+		"Player": {"local": true, "publicAddress": "10.0.0.1", "title": %q, "uuid": "abc123"},
+		"Metadata": {
+			"librarySectionType": "movie", "ratingKey": "1", "key": "/library/metadata/1", "guid": "plex://movie/1",
+			"librarySectionID": 1, "type": "movie", "title": "Alien", "summary": "", "thumb": "/thumb", "art": "/art",
+			"addedAt": 1559390400, "updatedAt": 1559390400
+		}
+	}`, event, player)
+}
+
+var testedConfig = `{
+	"triggers": [
+		{
+			"id": "living-room",
+			"properties": {"event": "media.play", "Player.title": "Living Room"},
+			"when": {"times": [{"after": "18:00", "before": "23:00"}]},
+			"tests": [
+				{"name": "plays in the living room", "payload": ` + testPayload("media.play", "Living Room") + `, "match": true},
+				{"name": "plays in the den", "payload": ` + testPayload("media.play", "Den") + `, "match": false},
+				{"name": "plays in the morning", "payload": ` + testPayload("media.play", "Living Room") + `, "receivedAt": "2019-06-01T08:00:00Z", "match": false},
+				{"payload": ` + testPayload("media.stop", "Living Room") + `, "match": true}
+			],
+			"actions": []
+		}
+	]
+}`
+
+func TestConfigRunTests(t *testing.T) {
+	cfg, err := NewConfig(strings.NewReader(testedConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	r := cfg.RunTests()
+	if len(r) != 4 {
+		t.Fatalf("Expected 4 test results, got %d", len(r))
+	}
+	failed := r.Failed()
+	if len(failed) != 1 {
+		t.Fatalf("Expected 1 failed test, got %d", len(failed))
+	}
+	if failed[0].Name != "test 4" || !failed[0].Expected || failed[0].Actual {
+		t.Errorf("Unexpected failed test result: %+v", failed[0])
+	}
+
+	buf := bytes.Buffer{}
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("Unexpected error writing report: %v", err)
+	}
+	for _, want := range []string{
+		"PASS living-room: plays in the living room\n",
+		"FAIL living-room: test 4 (expected match, got no match)\n",
+		"3 passed, 1 failed\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected report to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestNewConfigInvalidTestPayload(t *testing.T) {
+	for name, payload := range map[string]string{
+		"incomplete": `{"event": "media.play", "Player": {"title": "Living Room"}}`,
+		"mistyped":   strings.Replace(testPayload("media.play", "Living Room"), `"user": true`, `"user": "yes"`, 1),
+	} {
+		config := `{"triggers": [{"id": "t", "properties": {}, "tests": [{"payload": ` + payload + `, "match": true}], "actions": []}]}`
+		_, err := NewConfig(strings.NewReader(config))
+		if err == nil || !strings.Contains(err.Error(), "trigger 0: test 0: invalid payload") {
+			t.Errorf("%s: expected an invalid payload error, got %v", name, err)
+		}
+	}
+}
+
+func TestConfigRunTestsUndecodable(t *testing.T) {
+	cfg := Config{Triggers: []Trigger{{
+		ID:         "t",
+		Properties: map[string]interface{}{},
+		Tests:      []TriggerTest{{Name: "garbled", Payload: []byte(`{"event":`), Match: false}},
+	}}}
+	r := cfg.RunTests()
+	if len(r) != 1 || r[0].Passed() || r[0].Error == "" {
+		t.Fatalf("Expected the undecodable payload to fail its test, got %+v", r)
+	}
+	buf := bytes.Buffer{}
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("Unexpected error writing report: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "FAIL t: garbled (invalid payload: ") {
+		t.Errorf("Expected the report to show the decode error, got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,41 @@
+package plex
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig configures TLS connections to a server
+type TLSConfig struct {
+	// CA is the path to a PEM file of certificate authorities to trust instead of the system's
+	CA string `json:"ca,omitempty"`
+	// ServerName overrides the name the server's certificate is verified against
+	ServerName string `json:"serverName,omitempty"`
+	// InsecureSkipVerify disables verifying the server's certificate
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// config builds the crypto/tls configuration for connecting to host
+func (t *TLSConfig) config(host string) (*tls.Config, error) {
+	c := &tls.Config{ServerName: host}
+	if t == nil {
+		return c, nil
+	}
+	if t.ServerName != "" {
+		c.ServerName = t.ServerName
+	}
+	c.InsecureSkipVerify = t.InsecureSkipVerify
+	if t.CA != "" {
+		pem, err := ioutil.ReadFile(t.CA)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CA)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,66 @@
+package plex
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrUnknownTrigger is returned when referring to a trigger ID that is not configured
+	ErrUnknownTrigger = errors.New("no trigger is configured with that id")
+	// ErrUnknownTag is returned when referring to a tag that no configured trigger carries
+	ErrUnknownTag = errors.New("no trigger is configured with that tag")
+)
+
+// Toggles holds runtime overrides of the enabled flag of triggers, either by trigger ID or by tag.  It is safe for
+// concurrent use.
+type Toggles struct {
+	mu       sync.RWMutex
+	triggers map[string]bool
+	tags     map[string]bool
+}
+
+// NewToggles creates a Toggles without any overrides
+func NewToggles() *Toggles {
+	return &Toggles{
+		triggers: map[string]bool{},
+		tags:     map[string]bool{},
+	}
+}
+
+// SetTrigger overrides the enabled flag of the trigger with the given ID
+func (t *Toggles) SetTrigger(id string, enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.triggers[id] = enabled
+}
+
+// SetTag overrides the enabled flag of every trigger with the given tag
+func (t *Toggles) SetTag(tag string, enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tags[tag] = enabled
+}
+
+// enabled determines if the given trigger is enabled.  An override of the trigger itself takes precedence over its
+// configured flag, and a trigger disabled in the config stays disabled whatever its tags.  Otherwise a disabled tag
+// takes precedence over an enabled one, and without tag overrides the trigger is enabled.
+func (t *Toggles) enabled(tr Trigger) bool {
+	if t == nil {
+		return tr.configuredEnabled()
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if e, ok := t.triggers[tr.ID]; ok {
+		return e
+	}
+	if !tr.configuredEnabled() {
+		return false
+	}
+	for _, tag := range tr.Tags {
+		if e, ok := t.tags[tag]; ok && !e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package plex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+const toggleConfig = `{
+	"triggers": [
+		{"id": "living-room", "tags": ["lights", "living"], "properties": {"event": "media.play"}, "actions": []},
+		{"id": "den", "tags": ["lights"], "properties": {"event": "media.play"}, "actions": []},
+		{"id": "bedroom", "tags": ["lights"], "enabled": false, "properties": {"event": "media.play"}, "actions": []},
+		{"properties": {"event": "media.stop"}, "actions": []}
+	]
+}`
+
+// toggledEngine loads toggleConfig into an Engine, recording the executions of each trigger
+func toggledEngine(t *testing.T, executions map[string]*[]WebhookPayload) *Engine {
+	cfg, err := NewConfig(strings.NewReader(toggleConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	for i, tr := range cfg.Triggers {
+		payloads := []WebhookPayload{}
+		executions[tr.ID] = &payloads
+		cfg.Triggers[i].ParsedActions = []Action{recordingAction{payloads: &payloads}}
+	}
+	return NewEngine(cfg, nil)
+}
+
+func TestNewConfigTriggerIDs(t *testing.T) {
+	cfg, err := NewConfig(strings.NewReader(toggleConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	if cfg.Triggers[3].ID != "trigger-3" {
+		t.Errorf("Expected a trigger without an id to be assigned one by its position, got %q", cfg.Triggers[3].ID)
+	}
+
+	_, err = NewConfig(strings.NewReader(`{"triggers": [{"id": "a", "properties": {}}, {"id": "a", "properties": {}}]}`))
+	if err == nil {
+		t.Errorf("Expected an error loading config with duplicate ids, got none")
+	}
+
+	cfg, err = NewConfig(strings.NewReader(`{"triggers": [{"properties": {}}, {"properties": {}}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config with identical triggers: %v", err)
+	}
+	if cfg.Triggers[0].ID == cfg.Triggers[1].ID {
+		t.Errorf("Expected identical triggers to be assigned distinct ids, both got %q", cfg.Triggers[0].ID)
+	}
+
+	// Editing a trigger keeps its id
+	before, err := NewConfig(strings.NewReader(`{"triggers": [{"name": "Dim the Lights!", "properties": {"event": "media.play"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewConfig(strings.NewReader(`{"triggers": [{"name": "Dim the Lights!", "properties": {"event": "media.resume"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Triggers[0].ID != "trigger-0-dim-the-lights" || after.Triggers[0].ID != before.Triggers[0].ID {
+		t.Errorf("Expected an edited trigger to keep the id derived from its name, got %q and %q", before.Triggers[0].ID, after.Triggers[0].ID)
+	}
+
+	_, err = NewConfig(strings.NewReader(`{"triggers": [{"properties": {}}, {"id": "trigger-0", "properties": {}}]}`))
+	if err == nil {
+		t.Errorf("Expected an error loading config with an id clashing with a derived one, got none")
+	}
+
+	// Derived ids move when triggers are reordered, so triggers keeping state by id need one of their own
+	for _, raw := range []string{
+		`{"properties": {}, "correlation": {"timeout": "1m"}, "actions": []}`,
+		`{"properties": {}, "actions": [{"type": "counting", "delay": "1m"}]}`,
+		`{"properties": {}, "actions": [{"type": "counting", "retry": {"attempts": 2}}]}`,
+	} {
+		if _, err := NewConfig(strings.NewReader(`{"triggers": [` + raw + `]}`)); err == nil {
+			t.Errorf("Expected an error loading %s without an id, got none", raw)
+		}
+	}
+}
+
+func TestEngineToggles(t *testing.T) {
+	executions := map[string]*[]WebhookPayload{}
+	e := toggledEngine(t, executions)
+	play := func() {
+		doc, _ := ParseDocument([]byte(`{"event": "media.play"}`))
+		if err := e.Handle(log.NewNopLogger(), Activity{}, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(counts map[string]int) {
+		t.Helper()
+		for id, n := range counts {
+			if got := len(*executions[id]); got != n {
+				t.Errorf("Expected %d executions of %s, got %d", n, id, got)
+			}
+		}
+	}
+
+	play()
+	expect(map[string]int{"living-room": 1, "den": 1, "bedroom": 0})
+
+	// Disable a single trigger
+	if err := e.SetTriggerEnabled("den", false); err != nil {
+		t.Fatal(err)
+	}
+	play()
+	expect(map[string]int{"living-room": 2, "den": 1, "bedroom": 0})
+
+	// Disable the whole tag, except the trigger explicitly enabled
+	if err := e.SetTagEnabled("lights", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetTriggerEnabled("living-room", true); err != nil {
+		t.Fatal(err)
+	}
+	play()
+	expect(map[string]int{"living-room": 3, "den": 1, "bedroom": 0})
+
+	// Enabling a tag does not enable triggers disabled in the config, and survives a reload
+	if err := e.SetTagEnabled("lights", true); err != nil {
+		t.Fatal(err)
+	}
+	e.Load(toggledEngine(t, executions).Config())
+	play()
+	expect(map[string]int{"living-room": 1, "den": 0, "bedroom": 0})
+
+	// Overriding the trigger itself does
+	if err := e.SetTriggerEnabled("bedroom", true); err != nil {
+		t.Fatal(err)
+	}
+	play()
+	expect(map[string]int{"living-room": 2, "den": 0, "bedroom": 1})
+
+	for _, ts := range e.Triggers() {
+		if ts.ID == "den" && ts.Enabled {
+			t.Errorf("Expected den to be reported as disabled")
+		}
+	}
+
+	if err := e.SetTriggerEnabled("garage", false); err != ErrUnknownTrigger {
+		t.Errorf("Expected ErrUnknownTrigger, got %v", err)
+	}
+	if err := e.SetTagEnabled("garage", false); err != ErrUnknownTag {
+		t.Errorf("Expected ErrUnknownTag, got %v", err)
+	}
+}
+
+func TestConfigHandleLogsTriggerID(t *testing.T) {
+	executions := map[string]*[]WebhookPayload{}
+	e := toggledEngine(t, executions)
+	buf := &bytes.Buffer{}
+	doc, _ := ParseDocument([]byte(`{"event": "media.play"}`))
+	if err := e.Handle(log.NewLogfmtLogger(buf), Activity{}, doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.Contains(line, "trigger=") {
+			t.Errorf("Expected log line to carry the trigger id: %s", line)
+		}
+	}
+	if !strings.Contains(buf.String(), "trigger=living-room") {
+		t.Errorf("Expected the living-room trigger to be logged, got %s", buf.String())
+	}
+}
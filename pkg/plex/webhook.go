@@ -0,0 +1,162 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterAction("webhook", newWebhookAction)
+}
+
+// WebhookAction makes an HTTP request for an event.  URL, the Headers values and Body are templates rendered over
+// the event (see templateData).  Requests are authenticated according to Auth, when it is set.
+type WebhookAction struct {
+	URL         string            `json:"url"`
+	Action      string            `json:"action,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Auth        *WebhookAuth      `json:"auth,omitempty"`
+
+	url     *Template
+	headers map[string]*Template
+	body    *Template
+}
+
+// newWebhookAction creates a WebhookAction from its configuration, compiling its templates
+func newWebhookAction(c ActionConfig) (Action, error) {
+	w := WebhookAction{}
+	if err := c.Decode(&w); err != nil {
+		return nil, err
+	}
+	if w.URL == "" {
+		return nil, fmt.Errorf("invalid webhook action specified; missing URL")
+	}
+	if w.Action == "" {
+		w.Action = "GET"
+	}
+	if w.Auth != nil {
+		if err := w.Auth.validate(); err != nil {
+			return nil, fmt.Errorf("invalid webhook action specified; %v", err)
+		}
+	}
+	return w.compile()
+}
+
+// compile parses the WebhookAction's templates
+func (w WebhookAction) compile() (WebhookAction, error) {
+	var err error
+	if w.url, err = compileTemplate("url", w.URL); err != nil {
+		return w, fmt.Errorf("invalid webhook url template: %v", err)
+	}
+	w.headers = map[string]*Template{}
+	for k, v := range w.Headers {
+		if w.headers[k], err = compileTemplate("header "+k, v); err != nil {
+			return w, fmt.Errorf("invalid webhook header %q template: %v", k, err)
+		}
+	}
+	if w.Body != "" {
+		if w.body, err = compileTemplate("body", w.Body); err != nil {
+			return w, fmt.Errorf("invalid webhook body template: %v", err)
+		}
+	}
+	return w, nil
+}
+
+// Execute renders the WebhookAction's templates and sends the request.  WebhookActions not loaded through NewConfig
+// are compiled on every call.  A body is sent as JSON unless a ContentType is given.  The request is abandoned when ctx
+// is done.
+func (w WebhookAction) Execute(ctx context.Context, ev Event) error {
+	if w.url == nil {
+		var err error
+		if w, err = w.compile(); err != nil {
+			return err
+		}
+	}
+	u, err := w.url.render(ev)
+	if err != nil {
+		return fmt.Errorf("could not render webhook url: %v", err)
+	}
+	var body []byte
+	var r io.Reader
+	if w.body != nil {
+		b, err := w.body.render(ev)
+		if err != nil {
+			return fmt.Errorf("could not render webhook body: %v", err)
+		}
+		body = []byte(b)
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(w.Action, u, r)
+	if err != nil {
+		return err
+	}
+	if w.ContentType != "" {
+		req.Header.Set("Content-Type", w.ContentType)
+	} else if w.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, t := range w.headers {
+		v, err := t.render(ev)
+		if err != nil {
+			return fmt.Errorf("could not render webhook header %q: %v", k, err)
+		}
+		req.Header.Set(k, v)
+	}
+	auth := "none"
+	if w.Auth != nil {
+		w.Auth.apply(req, body, time.Now())
+		auth = w.Auth.Type
+	}
+	redacted := redactURL(u, w.URL)
+	ev.logger().Log("action", "webhook", "msg", "firing webhook", "verb", w.Action, "url", redacted, "auth", auth)
+	err = sendRequest(ctx, req, nil)
+	if ue, ok := err.(*url.Error); ok {
+		return &url.Error{Op: ue.Op, URL: redacted, Err: ue.Err}
+	}
+	return err
+}
+
+// httpClient makes the requests of every action calling an HTTP API.  Requests are bounded by their context, which
+// carries the action's timeout.
+var httpClient = &http.Client{}
+
+// sendRequest makes the request, decoding a JSON response into out unless it is nil.  Responses with a status other than
+// 2xx fail with a StatusError.
+func sendRequest(ctx context.Context, req *http.Request, out interface{}) error {
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Drain the body so the connection can be reused
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+		return StatusError{Code: resp.StatusCode}
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	return nil
+}
+
+// redactError hides a secret, such as an API key, in the URL that net/http includes in the errors of failed requests,
+// so that the error can be logged and kept in a dead letter
+func redactError(err error, secret string) error {
+	ue, ok := err.(*url.Error)
+	if !ok || secret == "" {
+		return err
+	}
+	r := strings.NewReplacer(secret, "xxxxx", url.PathEscape(secret), "xxxxx")
+	return &url.Error{Op: ue.Op, URL: r.Replace(ue.URL), Err: ue.Err}
+}
@@ -0,0 +1,290 @@
+package plex
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// When restricts a Trigger to certain times.  It is evaluated against the time the webhook was received, in the
+// given IANA time zone (or the local time zone of the server when omitted).  Every criterion present must be
+// satisfied: the time of day must fall in one of Times, the day must be one of Weekdays and the date must fall in
+// one of Dates.
+type When struct {
+	TimeZone string       `json:"timezone,omitempty"`
+	Times    []TimeWindow `json:"times,omitempty"`
+	Weekdays []string     `json:"weekdays,omitempty"`
+	Dates    []DateRange  `json:"dates,omitempty"`
+}
+
+// TimeWindow is a time of day window such as {"after": "18:00", "before": "23:30"}.  After is inclusive and Before is
+// exclusive; either may be omitted to leave the window open until or from midnight.  A window whose After is later
+// than its Before crosses midnight.  Bounds may also be relative to the sun, e.g. "sunset-30m" or "sunrise+1h", which
+// requires the Config to specify a Location.
+type TimeWindow struct {
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+}
+
+// DateRange is an inclusive range of dates.  Dates are either full dates (2019-12-24) or recurring month and day
+// pairs (12-24); a recurring range whose From is later than its To wraps around the new year.
+type DateRange struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// schedule is a compiled When
+type schedule struct {
+	loc      *time.Location
+	times    []timeWindow
+	weekdays map[time.Weekday]bool
+	dates    []dateRange
+}
+
+// timeWindow is a compiled TimeWindow
+type timeWindow struct {
+	after, before timeBound
+}
+
+// timeBound is a compiled TimeWindow bound: either a fixed number of minutes past midnight, or an offset from sunrise
+// or sunset at a location.
+type timeBound struct {
+	set     bool
+	minutes int
+	sun     string
+	offset  time.Duration
+	loc     Location
+}
+
+// dateRange is a compiled DateRange.  Full dates are compared as yyyymmdd, recurring ones as mmdd.
+type dateRange struct {
+	from, to  int
+	recurring bool
+}
+
+func (w When) compile(loc *Location) (*schedule, error) {
+	s := schedule{
+		loc: time.Local,
+	}
+	if w.TimeZone != "" {
+		loc, err := time.LoadLocation(w.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", w.TimeZone, err)
+		}
+		s.loc = loc
+	}
+	for _, tw := range w.Times {
+		if tw.After == "" && tw.Before == "" {
+			return nil, fmt.Errorf("time window must specify after, before or both")
+		}
+		ctw := timeWindow{}
+		var err error
+		if ctw.after, err = parseTimeBound(tw.After, loc); err != nil {
+			return nil, err
+		}
+		if ctw.before, err = parseTimeBound(tw.Before, loc); err != nil {
+			return nil, err
+		}
+		s.times = append(s.times, ctw)
+	}
+	if len(w.Weekdays) > 0 {
+		s.weekdays = map[time.Weekday]bool{}
+		for _, d := range w.Weekdays {
+			name := strings.ToLower(d)
+			if len(name) > 3 {
+				name = name[:3]
+			}
+			wd, ok := weekdays[name]
+			if !ok {
+				return nil, fmt.Errorf("invalid weekday %q", d)
+			}
+			s.weekdays[wd] = true
+		}
+	}
+	for _, dr := range w.Dates {
+		cdr, err := compileDateRange(dr)
+		if err != nil {
+			return nil, err
+		}
+		s.dates = append(s.dates, cdr)
+	}
+	return &s, nil
+}
+
+// parseTimeBound parses a 24 hour "15:04" time, or a sun relative bound such as "sunset-30m"
+func parseTimeBound(s string, loc *Location) (timeBound, error) {
+	if s == "" {
+		return timeBound{}, nil
+	}
+	for _, sun := range []string{sunrise, sunset} {
+		if !strings.HasPrefix(s, sun) {
+			continue
+		}
+		if loc == nil {
+			return timeBound{}, fmt.Errorf("time %q requires a location to be configured", s)
+		}
+		b := timeBound{set: true, sun: sun, loc: *loc}
+		if off := s[len(sun):]; off != "" {
+			if off[0] != '+' && off[0] != '-' {
+				return timeBound{}, fmt.Errorf("invalid time %q, expected an offset such as %s-30m", s, sun)
+			}
+			d, err := time.ParseDuration(off)
+			if err != nil {
+				return timeBound{}, fmt.Errorf("invalid time %q: %v", s, err)
+			}
+			b.offset = d
+		}
+		return b, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return timeBound{}, fmt.Errorf("invalid time of day %q, expected HH:MM, sunrise or sunset", s)
+	}
+	return timeBound{set: true, minutes: t.Hour()*60 + t.Minute()}, nil
+}
+
+// resolve returns the bound in minutes past midnight on the calendar date of t.  Sun relative bounds offset far
+// enough to fall before or after the date wrap around to the time of day they fall on, so that sunset+5h is 01:30
+// rather than 25:30.  ok is false when the sun does not rise or set on that date.
+func (b timeBound) resolve(t time.Time) (int, bool) {
+	if b.sun == "" {
+		return b.minutes, true
+	}
+	rise, set, ok := sunTimes(t, b.loc)
+	if !ok {
+		return 0, false
+	}
+	at := rise
+	if b.sun == sunset {
+		at = set
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	m := int(at.Add(b.offset).Sub(midnight)/time.Minute) % minutesPerDay
+	if m < 0 {
+		m += minutesPerDay
+	}
+	return m, true
+}
+
+// minutesPerDay bounds the minutes past midnight of a time of day
+const minutesPerDay = 24 * 60
+
+func compileDateRange(dr DateRange) (dateRange, error) {
+	if dr.From == "" && dr.To == "" {
+		return dateRange{}, fmt.Errorf("date range must specify from, to or both")
+	}
+	from, fromRecurring, err := parseDate(dr.From)
+	if err != nil {
+		return dateRange{}, err
+	}
+	to, toRecurring, err := parseDate(dr.To)
+	if err != nil {
+		return dateRange{}, err
+	}
+	if dr.From != "" && dr.To != "" && fromRecurring != toRecurring {
+		return dateRange{}, fmt.Errorf("date range %q to %q mixes full and recurring dates", dr.From, dr.To)
+	}
+	if dr.From != "" && dr.To != "" && !fromRecurring && from > to {
+		return dateRange{}, fmt.Errorf("date range %q to %q ends before it starts", dr.From, dr.To)
+	}
+	cdr := dateRange{from: from, to: to, recurring: fromRecurring || toRecurring}
+	if dr.From == "" {
+		cdr.from = 0
+	}
+	if dr.To == "" {
+		cdr.to = 99991231
+		if cdr.recurring {
+			cdr.to = 1231
+		}
+	}
+	return cdr, nil
+}
+
+// parseDate parses either a full date (2006-01-02) or a recurring month and day (01-02)
+func parseDate(s string) (int, bool, error) {
+	if s == "" {
+		return 0, false, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Year()*10000 + int(t.Month())*100 + t.Day(), false, nil
+	}
+	if t, err := time.Parse("01-02", s); err == nil {
+		return int(t.Month())*100 + t.Day(), true, nil
+	}
+	return 0, false, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or MM-DD", s)
+}
+
+// active determines if the given instant satisfies the schedule
+func (s *schedule) active(t time.Time) bool {
+	t = t.In(s.loc)
+	if s.weekdays != nil && !s.weekdays[t.Weekday()] {
+		return false
+	}
+	if len(s.dates) > 0 {
+		ok := false
+		for _, dr := range s.dates {
+			if dr.contains(t) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(s.times) > 0 {
+		ok := false
+		for _, tw := range s.times {
+			if tw.contains(t) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (tw timeWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	after, aok := tw.after.resolve(t)
+	before, bok := tw.before.resolve(t)
+	if !aok || !bok {
+		return false
+	}
+	switch {
+	case !tw.after.set:
+		return m < before
+	case !tw.before.set:
+		return m >= after
+	case after <= before:
+		return m >= after && m < before
+	default:
+		// Crosses midnight
+		return m >= after || m < before
+	}
+}
+
+func (dr dateRange) contains(t time.Time) bool {
+	d := int(t.Month())*100 + t.Day()
+	if !dr.recurring {
+		d += t.Year() * 10000
+	}
+	if dr.from <= dr.to {
+		return d >= dr.from && d <= dr.to
+	}
+	// Recurring range wrapping around the new year
+	return d >= dr.from || d <= dr.to
+}
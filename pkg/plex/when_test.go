@@ -0,0 +1,129 @@
+package plex
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// recordingAction is an Action that records the payloads it was executed with
+type recordingAction struct {
+	payloads *[]WebhookPayload
+}
+
+func (a recordingAction) Execute(ctx context.Context, ev Event) error {
+	*a.payloads = append(*a.payloads, ev.Activity.Payload)
+	return nil
+}
+
+func TestTriggerActiveAt(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	tests := []struct {
+		name   string
+		when   When
+		at     time.Time
+		active bool
+	}{
+		{"empty", When{}, time.Date(2019, 6, 1, 12, 0, 0, 0, ny), true},
+		{"window", When{TimeZone: "America/New_York", Times: []TimeWindow{{After: "18:00", Before: "23:00"}}}, time.Date(2019, 6, 1, 19, 0, 0, 0, ny), true},
+		{"window start inclusive", When{TimeZone: "America/New_York", Times: []TimeWindow{{After: "18:00", Before: "23:00"}}}, time.Date(2019, 6, 1, 18, 0, 0, 0, ny), true},
+		{"window end exclusive", When{TimeZone: "America/New_York", Times: []TimeWindow{{After: "18:00", Before: "23:00"}}}, time.Date(2019, 6, 1, 23, 0, 0, 0, ny), false},
+		{"window before", When{TimeZone: "America/New_York", Times: []TimeWindow{{After: "18:00", Before: "23:00"}}}, time.Date(2019, 6, 1, 17, 59, 0, 0, ny), false},
+		{"window in another zone", When{TimeZone: "America/New_York", Times: []TimeWindow{{After: "18:00", Before: "23:00"}}}, time.Date(2019, 6, 1, 23, 30, 0, 0, time.UTC), true},
+		{"window across midnight late", When{TimeZone: "America/New_York", Times: []TimeWindow{{After: "22:00", Before: "02:00"}}}, time.Date(2019, 6, 1, 23, 0, 0, 0, ny), true},
+		{"window across midnight early", When{TimeZone: "America/New_York", Times: []TimeWindow{{After: "22:00", Before: "02:00"}}}, time.Date(2019, 6, 1, 1, 0, 0, 0, ny), true},
+		{"window across midnight outside", When{TimeZone: "America/New_York", Times: []TimeWindow{{After: "22:00", Before: "02:00"}}}, time.Date(2019, 6, 1, 12, 0, 0, 0, ny), false},
+		{"open ended after", When{TimeZone: "America/New_York", Times: []TimeWindow{{After: "20:00"}}}, time.Date(2019, 6, 1, 23, 59, 0, 0, ny), true},
+		{"open ended before", When{TimeZone: "America/New_York", Times: []TimeWindow{{Before: "06:00"}}}, time.Date(2019, 6, 1, 6, 30, 0, 0, ny), false},
+		{"second window", When{TimeZone: "America/New_York", Times: []TimeWindow{{Before: "06:00"}, {After: "20:00"}}}, time.Date(2019, 6, 1, 21, 0, 0, 0, ny), true},
+		{"weekend", When{TimeZone: "America/New_York", Weekdays: []string{"sat", "Sunday"}}, time.Date(2019, 6, 1, 12, 0, 0, 0, ny), true},
+		{"weekday", When{TimeZone: "America/New_York", Weekdays: []string{"sat", "Sunday"}}, time.Date(2019, 6, 3, 12, 0, 0, 0, ny), false},
+		{"weekday in zone", When{TimeZone: "America/New_York", Weekdays: []string{"fri"}}, time.Date(2019, 6, 1, 2, 0, 0, 0, time.UTC), true},
+		{"date range", When{TimeZone: "America/New_York", Dates: []DateRange{{From: "2019-05-01", To: "2019-06-01"}}}, time.Date(2019, 6, 1, 23, 0, 0, 0, ny), true},
+		{"date range outside", When{TimeZone: "America/New_York", Dates: []DateRange{{From: "2019-05-01", To: "2019-06-01"}}}, time.Date(2019, 6, 2, 0, 0, 0, 0, ny), false},
+		{"date range open", When{TimeZone: "America/New_York", Dates: []DateRange{{From: "2019-05-01"}}}, time.Date(2025, 1, 1, 0, 0, 0, 0, ny), true},
+		{"recurring range", When{TimeZone: "America/New_York", Dates: []DateRange{{From: "12-01", To: "12-31"}}}, time.Date(2021, 12, 24, 0, 0, 0, 0, ny), true},
+		{"recurring range across new year", When{TimeZone: "America/New_York", Dates: []DateRange{{From: "12-20", To: "01-05"}}}, time.Date(2021, 1, 2, 0, 0, 0, 0, ny), true},
+		{"recurring range across new year outside", When{TimeZone: "America/New_York", Dates: []DateRange{{From: "12-20", To: "01-05"}}}, time.Date(2021, 1, 6, 0, 0, 0, 0, ny), false},
+		{"combined", When{TimeZone: "America/New_York", Times: []TimeWindow{{After: "18:00"}}, Weekdays: []string{"sat"}}, time.Date(2019, 6, 1, 19, 0, 0, 0, ny), true},
+		{"combined wrong day", When{TimeZone: "America/New_York", Times: []TimeWindow{{After: "18:00"}}, Weekdays: []string{"sun"}}, time.Date(2019, 6, 1, 19, 0, 0, 0, ny), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.when
+			tr := Trigger{When: &w}
+			if a := tr.ActiveAt(tt.at); a != tt.active {
+				t.Errorf("Expected ActiveAt to be %v, got %v", tt.active, a)
+			}
+		})
+	}
+}
+
+func TestNewConfigInvalidWhen(t *testing.T) {
+	tests := []struct {
+		name string
+		when string
+	}{
+		{"time zone", `{"timezone": "Nowhere/Special"}`},
+		{"time of day", `{"times": [{"after": "25:00"}]}`},
+		{"empty window", `{"times": [{}]}`},
+		{"weekday", `{"weekdays": ["someday"]}`},
+		{"date", `{"dates": [{"from": "2019-13-01"}]}`},
+		{"backwards date range", `{"dates": [{"from": "2019-06-01", "to": "2019-05-01"}]}`},
+		{"mixed date range", `{"dates": [{"from": "2019-06-01", "to": "07-01"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewConfig(strings.NewReader(`{"triggers": [{"properties": {}, "when": ` + tt.when + `}]}`))
+			if err == nil {
+				t.Errorf("Expected an error loading config, got none")
+			}
+		})
+	}
+}
+
+func TestConfigHandleWhen(t *testing.T) {
+	var payloads []WebhookPayload
+	cfg := Config{
+		Triggers: []Trigger{
+			{
+				Properties: map[string]interface{}{
+					"event": "media.play",
+				},
+				When: &When{
+					TimeZone: "UTC",
+					Times:    []TimeWindow{{After: "20:00", Before: "06:00"}},
+				},
+				ParsedActions: []Action{recordingAction{payloads: &payloads}},
+			},
+		},
+		Clock: newFakeClock(time.Date(2019, 6, 1, 21, 0, 0, 0, time.UTC)),
+	}
+	doc, err := ParseDocument([]byte(`{"event": "media.play"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Received during the day
+	act := Activity{ReceivedAt: time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)}
+	if err := cfg.Handle(log.NewNopLogger(), act, doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(payloads) != 0 {
+		t.Errorf("Expected no actions to be executed during the day, got %d", len(payloads))
+	}
+
+	// No receive time, so the clock is consulted
+	if err := cfg.Handle(log.NewNopLogger(), Activity{}, doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(payloads) != 1 {
+		t.Errorf("Expected actions to be executed at night, got %d executions", len(payloads))
+	}
+}
@@ -0,0 +1,64 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+)
+
+func init() {
+	RegisterAction("wol", newWOLAction)
+}
+
+// WOLAction wakes a machine by broadcasting a Wake-on-LAN magic packet for its MAC address.  Broadcast is the address
+// the packet is sent to, 255.255.255.255 by default; it can be a subnet's broadcast address, such as 192.168.1.255,
+// and its port defaults to 9.
+type WOLAction struct {
+	MAC       string `json:"mac"`
+	Broadcast string `json:"broadcast,omitempty"`
+
+	addr   string
+	packet []byte
+}
+
+// newWOLAction creates a WOLAction from its configuration, building its magic packet
+func newWOLAction(c ActionConfig) (Action, error) {
+	w := WOLAction{}
+	if err := c.Decode(&w); err != nil {
+		return nil, err
+	}
+	mac, err := net.ParseMAC(w.MAC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wol action specified; %v", err)
+	}
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("invalid wol action specified; mac %q is not a 48-bit address", w.MAC)
+	}
+	// A magic packet is 6 bytes of 0xff followed by the MAC address 16 times
+	w.packet = append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(mac, 16)...)
+	w.addr = w.Broadcast
+	if w.addr == "" {
+		w.addr = "255.255.255.255"
+	}
+	if _, _, err := net.SplitHostPort(w.addr); err != nil {
+		w.addr = net.JoinHostPort(w.addr, "9")
+	}
+	if _, err := net.ResolveUDPAddr("udp", w.addr); err != nil {
+		return nil, fmt.Errorf("invalid wol action specified; %v", err)
+	}
+	return w, nil
+}
+
+// Execute sends the magic packet
+func (w WOLAction) Execute(ctx context.Context, ev Event) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", w.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ev.logger().Log("action", "wol", "mac", w.MAC, "broadcast", w.addr)
+	_, err = conn.Write(w.packet)
+	return err
+}
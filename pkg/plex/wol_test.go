@@ -0,0 +1,63 @@
+package plex
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWOLAction(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	defer conn.Close()
+
+	cfg, err := NewConfig(strings.NewReader(`{"triggers": [{"properties": {}, "actions": [
+		{"type": "wol", "config": {"mac": "00:11:22:aa:bb:cc", "broadcast": "` + conn.LocalAddr().String() + `"}}
+	]}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	if err := cfg.Triggers[0].ParsedActions[0].Execute(context.Background(), templateEvent(t)); err != nil {
+		t.Fatalf("Unexpected error executing action: %v", err)
+	}
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Did not receive a packet: %v", err)
+	}
+	want := bytes.Repeat([]byte{0xff}, 6)
+	for i := 0; i < 16; i++ {
+		want = append(want, 0x00, 0x11, 0x22, 0xaa, 0xbb, 0xcc)
+	}
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("Expected magic packet %x, got %x", want, buf[:n])
+	}
+}
+
+func TestWOLActionDefaults(t *testing.T) {
+	a, err := newWOLAction(ActionConfig{Type: "wol", Raw: []byte(`{"mac": "00-11-22-AA-BB-CC"}`)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if addr := a.(WOLAction).addr; addr != "255.255.255.255:9" {
+		t.Errorf("Expected default broadcast address, got %q", addr)
+	}
+	a, err = newWOLAction(ActionConfig{Type: "wol", Raw: []byte(`{"mac": "00:11:22:aa:bb:cc", "broadcast": "192.168.1.255"}`)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if addr := a.(WOLAction).addr; addr != "192.168.1.255:9" {
+		t.Errorf("Expected the default port, got %q", addr)
+	}
+	for _, raw := range []string{`{}`, `{"mac": "nope"}`, `{"mac": "00:11:22:33:44:55:66:77"}`, `{"mac": "00:11:22:aa:bb:cc", "broadcast": "1.2.3.4:x"}`} {
+		if _, err := newWOLAction(ActionConfig{Type: "wol", Raw: []byte(raw)}); err == nil {
+			t.Errorf("Expected an error for %s, got none", raw)
+		}
+	}
+}